@@ -0,0 +1,298 @@
+// Package ack 需要客户端确认的消息：未确认时定时重发，直到确认或重试次数用完，
+// 连接断开后未确认的消息保留到会话过期，客户端以相同的会话重连（见 websocket.WithSessionID）后继续投递
+//
+// 发送格式 {"ack_id": "...", "data": ...}，客户端确认 {"type": "$ack", "ack_id": "..."}
+package ack
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/assembly-hub/websocket"
+)
+
+// ErrNoSession 会话不存在或已过期
+var ErrNoSession = errors.New("ack: session not found")
+
+// Status 消息最终的投递状态
+type Status int
+
+const (
+	// Delivered 客户端已确认
+	Delivered Status = iota
+	// Failed 重试次数用完仍未确认
+	Failed
+	// Expired 连接断开后会话在 SessionTTL 内没有恢复
+	Expired
+)
+
+func (s Status) String() string {
+	switch s {
+	case Delivered:
+		return "delivered"
+	case Failed:
+		return "failed"
+	case Expired:
+		return "expired"
+	}
+	return "unknown"
+}
+
+// Result 消息的投递结果
+type Result struct {
+	ID      string
+	Session string
+	Status  Status
+	// Attempts 发送次数，包括重连后的重发
+	Attempts int
+	Data     json.RawMessage
+}
+
+type frame struct {
+	ID   string          `json:"ack_id"`
+	Data json.RawMessage `json:"data"`
+}
+
+// AckType 客户端确认帧的 type，带前缀以免与业务消息冲突
+const AckType = "$ack"
+
+type ackFrame struct {
+	Type string `json:"type"`
+	ID   string `json:"ack_id"`
+}
+
+var ackKey = []byte(`"` + AckType + `"`)
+
+type pendingMsg struct {
+	id       string
+	data     json.RawMessage
+	frame    []byte
+	attempts int
+	timer    *time.Timer
+}
+
+type session struct {
+	key     string
+	client  *websocket.Client
+	pending map[string]*pendingMsg
+	// 连接断开后的过期计时
+	expire *time.Timer
+}
+
+// Tracker 跟踪需要确认的消息，作为 Handler 使用，其他消息交给嵌入的 Handler
+type Tracker struct {
+	websocket.Handler
+
+	// Interval 未确认时的重发间隔
+	Interval time.Duration
+	// MaxRetries 最多重发次数，不含第一次发送
+	MaxRetries int
+	// SessionTTL 连接断开后保留未确认消息的时间
+	SessionTTL time.Duration
+	// OnResult 消息有最终状态时调用
+	OnResult func(r Result)
+
+	seq      atomic.Uint64
+	mutex    sync.Mutex
+	sessions map[string]*session
+}
+
+// NewTracker 创建 Tracker，默认 3s 重发一次，最多重发 5 次，会话保留 1 分钟
+func NewTracker() *Tracker {
+	return &Tracker{
+		Handler:    websocket.NopHandler{},
+		Interval:   3 * time.Second,
+		MaxRetries: 5,
+		SessionTTL: time.Minute,
+		sessions:   map[string]*session{},
+	}
+}
+
+// Send 向连接所在的会话发送需要确认的消息，返回消息 id
+func (t *Tracker) Send(c *websocket.Client, v interface{}) (string, error) {
+	return t.SendTo(c.ResumeKey(), v)
+}
+
+// SendTo 向会话发送需要确认的消息，会话断开但未过期时在重连后发送
+func (t *Tracker) SendTo(sessionKey string, v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	id := strconv.FormatUint(t.seq.Add(1), 36)
+	f, err := json.Marshal(&frame{ID: id, Data: data})
+	if err != nil {
+		return "", err
+	}
+
+	t.mutex.Lock()
+	s := t.sessions[sessionKey]
+	if s == nil {
+		t.mutex.Unlock()
+		return "", ErrNoSession
+	}
+	p := &pendingMsg{id: id, data: data, frame: f}
+	s.pending[id] = p
+	c := s.client
+	if c != nil {
+		t.schedule(s, p)
+	}
+	t.mutex.Unlock()
+
+	if c != nil {
+		c.TrySend(f)
+	}
+	return id, nil
+}
+
+// Pending 会话中未确认的消息数
+func (t *Tracker) Pending(sessionKey string) int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if s := t.sessions[sessionKey]; s != nil {
+		return len(s.pending)
+	}
+	return 0
+}
+
+// schedule 记录一次发送并开始重发计时，需持有 mutex，由调用方在释放 mutex 后发送。
+// 发送队列已满时不阻塞，由重发补上
+func (t *Tracker) schedule(s *session, p *pendingMsg) {
+	p.attempts++
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	c, key := s.client, s.key
+	p.timer = time.AfterFunc(t.Interval, func() {
+		c.Go("ack.retry", func() { t.retry(key, p.id) })
+	})
+}
+
+func (t *Tracker) retry(key, id string) {
+	t.mutex.Lock()
+	s := t.sessions[key]
+	if s == nil || s.pending[id] == nil {
+		t.mutex.Unlock()
+		return
+	}
+	p := s.pending[id]
+	if s.client == nil {
+		// 等待重连，不消耗重试次数
+		t.mutex.Unlock()
+		return
+	}
+	if p.attempts > t.MaxRetries {
+		delete(s.pending, id)
+		t.mutex.Unlock()
+		t.report(s.key, p, Failed)
+		return
+	}
+	c := s.client
+	t.schedule(s, p)
+	t.mutex.Unlock()
+	c.TrySend(p.frame)
+}
+
+func (t *Tracker) report(key string, p *pendingMsg, status Status) {
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	if t.OnResult != nil {
+		t.OnResult(Result{ID: p.id, Session: key, Status: status, Attempts: p.attempts, Data: p.data})
+	}
+}
+
+// OnConnect 创建或恢复会话，恢复时立即重发未确认的消息
+func (t *Tracker) OnConnect(ctx context.Context, c *websocket.Client) error {
+	key := c.ResumeKey()
+	t.mutex.Lock()
+	s := t.sessions[key]
+	if s == nil {
+		s = &session{key: key, pending: map[string]*pendingMsg{}}
+		t.sessions[key] = s
+	}
+	if s.expire != nil {
+		s.expire.Stop()
+		s.expire = nil
+	}
+	s.client = c
+	frames := make([][]byte, 0, len(s.pending))
+	for _, p := range s.pending {
+		t.schedule(s, p)
+		frames = append(frames, p.frame)
+	}
+	t.mutex.Unlock()
+
+	for _, f := range frames {
+		c.TrySend(f)
+	}
+
+	return t.Handler.OnConnect(ctx, c)
+}
+
+// OnClose 连接断开，停止重发，会话保留 SessionTTL
+func (t *Tracker) OnClose(ctx context.Context, c *websocket.Client, reason websocket.CloseReason) {
+	key := c.ResumeKey()
+	t.mutex.Lock()
+	// 同一会话已有新连接时不做处理
+	if s := t.sessions[key]; s != nil && s.client == c {
+		s.client = nil
+		for _, p := range s.pending {
+			if p.timer != nil {
+				p.timer.Stop()
+				p.timer = nil
+			}
+		}
+		s.expire = time.AfterFunc(t.SessionTTL, func() { t.expire(key) })
+	}
+	t.mutex.Unlock()
+
+	t.Handler.OnClose(ctx, c, reason)
+}
+
+func (t *Tracker) expire(key string) {
+	t.mutex.Lock()
+	s := t.sessions[key]
+	if s == nil || s.client != nil {
+		t.mutex.Unlock()
+		return
+	}
+	delete(t.sessions, key)
+	t.mutex.Unlock()
+
+	for _, p := range s.pending {
+		t.report(key, p, Expired)
+	}
+}
+
+func (t *Tracker) OnMessage(ctx context.Context, c *websocket.Client, mt websocket.MessageType, msg []byte) error {
+	if bytes.Contains(msg, ackKey) {
+		var a ackFrame
+		if err := json.Unmarshal(msg, &a); err == nil && a.Type == AckType && a.ID != "" {
+			t.ack(c.ResumeKey(), a.ID)
+			return nil
+		}
+	}
+	return t.Handler.OnMessage(ctx, c, mt, msg)
+}
+
+func (t *Tracker) ack(key, id string) {
+	t.mutex.Lock()
+	var p *pendingMsg
+	if s := t.sessions[key]; s != nil {
+		p = s.pending[id]
+		delete(s.pending, id)
+	}
+	t.mutex.Unlock()
+
+	if p != nil {
+		t.report(key, p, Delivered)
+	}
+}
@@ -0,0 +1,124 @@
+package ack
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/assembly-hub/websocket"
+)
+
+func newTestClient(session string) *websocket.Client {
+	c := websocket.NewClient(context.Background(), nil, 8)
+	c.SessionID = session
+	return c
+}
+
+func readFrame(t *testing.T, c *websocket.Client) frame {
+	t.Helper()
+	select {
+	case data := <-c.Send:
+		var f frame
+		if err := json.Unmarshal(data, &f); err != nil {
+			t.Fatal(err)
+		}
+		return f
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing sent")
+		return frame{}
+	}
+}
+
+func waitResult(t *testing.T, ch <-chan Result) Result {
+	t.Helper()
+	select {
+	case r := <-ch:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnResult not called")
+		return Result{}
+	}
+}
+
+func TestAckFrame(t *testing.T) {
+	results := make(chan Result, 1)
+	passed := make(chan string, 1)
+	tr := NewTracker()
+	tr.OnResult = func(r Result) { results <- r }
+	tr.Handler = websocket.HandlerFuncs{
+		Message: func(ctx context.Context, c *websocket.Client, mt websocket.MessageType, msg []byte) error {
+			passed <- string(msg)
+			return nil
+		},
+	}
+	c := newTestClient("s")
+	if err := tr.OnConnect(context.Background(), c); err != nil {
+		t.Fatal(err)
+	}
+	id, err := tr.Send(c, "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f := readFrame(t, c); f.ID != id || string(f.Data) != `"hello"` {
+		t.Fatalf("got %+v", f)
+	}
+
+	// 业务消息中的 ack 字段不被当作确认
+	user := `{"ack": "` + id + `"}`
+	_ = tr.OnMessage(context.Background(), c, websocket.TextMessage, []byte(user))
+	if got := <-passed; got != user || tr.Pending("s") != 1 {
+		t.Fatalf("user message swallowed: %q, pending %d", got, tr.Pending("s"))
+	}
+
+	ack := `{"type": "$ack", "ack_id": "` + id + `"}`
+	_ = tr.OnMessage(context.Background(), c, websocket.TextMessage, []byte(ack))
+	if r := waitResult(t, results); r.Status != Delivered || r.ID != id || r.Attempts != 1 {
+		t.Fatalf("got %+v", r)
+	}
+}
+
+func TestRetryAfterSendClosed(t *testing.T) {
+	results := make(chan Result, 1)
+	tr := NewTracker()
+	tr.Interval = 10 * time.Millisecond
+	tr.MaxRetries = 2
+	tr.OnResult = func(r Result) { results <- r }
+	c := newTestClient("s")
+	_ = tr.OnConnect(context.Background(), c)
+	if _, err := tr.Send(c, 1); err != nil {
+		t.Fatal(err)
+	}
+	readFrame(t, c)
+
+	// 组已关闭 Send，OnClose 还未调用，重发不能 panic
+	c.CloseSend()
+	if r := waitResult(t, results); r.Status != Failed || r.Attempts != 3 {
+		t.Fatalf("got %+v", r)
+	}
+}
+
+func TestCloseStopsRetry(t *testing.T) {
+	tr := NewTracker()
+	tr.Interval = 10 * time.Millisecond
+	c := newTestClient("s")
+	_ = tr.OnConnect(context.Background(), c)
+	if _, err := tr.Send(c, 1); err != nil {
+		t.Fatal(err)
+	}
+	readFrame(t, c)
+	tr.OnClose(context.Background(), c, websocket.NewCloseReason(websocket.CloseLocal, ""))
+
+	time.Sleep(50 * time.Millisecond)
+	if n := len(c.Send); n != 0 {
+		t.Fatalf("%d frames resent after close", n)
+	}
+
+	// 重连后立即重发
+	c2 := newTestClient("s")
+	_ = tr.OnConnect(context.Background(), c2)
+	readFrame(t, c2)
+	if tr.Pending("s") != 1 {
+		t.Fatalf("pending %d", tr.Pending("s"))
+	}
+}
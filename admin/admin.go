@@ -0,0 +1,124 @@
+// Package admin 组与连接的查看、管理接口，用于排查线上问题
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/assembly-hub/websocket"
+)
+
+// Source 可被管理的组管理器，simplesub、singlesub、multisub 的 Manage 均已实现
+type Source interface {
+	Groups() []websocket.GroupInfo
+	Clients(groupName string) []websocket.ClientInfo
+	Kick(id string, reason string) error
+	CloseGroup(groupName string, reason string) error
+}
+
+// AuthFunc 鉴权，返回 false 时拒绝请求
+type AuthFunc func(r *http.Request) bool
+
+type handler struct {
+	src  Source
+	auth AuthFunc
+	mux  *http.ServeMux
+}
+
+// NewHandler 创建管理接口，auth 为空时拒绝所有请求。
+// 挂载到子路径时需配合 http.StripPrefix 使用：
+//
+//	GET  /groups                         组列表及连接数
+//	GET  /clients?group=xxx              组内连接，group 为空时返回所有连接
+//	GET  /client?id=xxx                  连接详情
+//	POST /kick?id=xxx&reason=xxx         按连接 id 或用户 id 踢出
+//	POST /close_group?group=xxx&reason=  关闭组内所有连接
+func NewHandler(src Source, auth AuthFunc) http.Handler {
+	h := &handler{
+		src:  src,
+		auth: auth,
+		mux:  http.NewServeMux(),
+	}
+	h.mux.HandleFunc("/groups", h.method(http.MethodGet, h.groups))
+	h.mux.HandleFunc("/clients", h.method(http.MethodGet, h.clients))
+	h.mux.HandleFunc("/client", h.method(http.MethodGet, h.client))
+	h.mux.HandleFunc("/kick", h.method(http.MethodPost, h.kick))
+	h.mux.HandleFunc("/close_group", h.method(http.MethodPost, h.closeGroup))
+	return h
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.auth == nil || !h.auth(r) {
+		writeError(w, http.StatusForbidden, "forbidden")
+		return
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *handler) method(method string, f http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		f(w, r)
+	}
+}
+
+func (h *handler) groups(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.src.Groups())
+}
+
+func (h *handler) clients(w http.ResponseWriter, r *http.Request) {
+	list := h.src.Clients(r.URL.Query().Get("group"))
+	if list == nil {
+		list = []websocket.ClientInfo{}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (h *handler) client(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		writeError(w, http.StatusBadRequest, "id is empty")
+		return
+	}
+
+	for _, c := range h.src.Clients("") {
+		if c.ID == id {
+			writeJSON(w, http.StatusOK, c)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "client not found")
+}
+
+func (h *handler) kick(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	err := h.src.Kick(q.Get("id"), q.Get("reason"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
+}
+
+func (h *handler) closeGroup(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	err := h.src.CloseGroup(q.Get("group"), q.Get("reason"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
@@ -1,8 +1,17 @@
 // Package websocket
 package websocket
 
+import "context"
+
 type GroupExtData struct {
 	CloseSendData interface{}
 	CloseCallback func(data interface{})
 	ReceiveMsg    func(msg []byte) []byte
+
+	// 带上下文的回调，ctx 继承自 http 请求，设置后优先于 CloseCallback、ReceiveMsg
+	CloseCallbackWithCtx func(ctx context.Context, data interface{})
+	ReceiveMsgWithCtx    func(ctx context.Context, msg []byte) []byte
+
+	// CloseCallbackWithReason 带关闭原因的关闭回调，优先于 CloseCallbackWithCtx、CloseCallback
+	CloseCallbackWithReason func(ctx context.Context, data interface{}, reason CloseReason)
 }
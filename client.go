@@ -3,14 +3,20 @@ package websocket
 
 import (
 	"context"
-	"fmt"
+	"crypto/rand"
+	"encoding/hex"
 	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 
 	"github.com/assembly-hub/websocket/config"
 	"github.com/assembly-hub/websocket/log"
+	"github.com/assembly-hub/websocket/metrics"
+	"github.com/assembly-hub/websocket/tracing"
 )
 
 const (
@@ -25,6 +31,9 @@ const (
 
 	// Maximum message size allowed from peer.
 	maxMessageSize = 512
+
+	// Time to wait for the peer's close frame after sending ours.
+	closeWait = 5 * time.Second
 )
 
 var (
@@ -33,54 +42,336 @@ var (
 
 // Client is a middleman between the websocket connection and the group.
 type Client struct {
+	// ID 连接 id，Run 时自动生成
+	ID string
+	// UserID 用户 id，为空时从 context 中获取（见 WithUserID）
+	UserID string
+	// SessionID 会话 id，为空时从 context 中获取（见 WithSessionID）。
+	// 断线重连时使用相同的会话 id，可恢复未确认的消息等会话状态
+	SessionID string
 	GroupName string
 	Group     GroupAPI
 
-	initData      interface{}
-	closeCallback func(data interface{})
+	// 连接的上下文，继承 http 请求中的值，连接关闭时取消
+	ctx    context.Context
+	cancel context.CancelFunc
 
 	// The websocket connection.
 	Conn *websocket.Conn
 	// Buffered channel of outbound messages.
 	Send chan []byte
+	// Send 由组关闭，见 TrySend、CloseSend
+	sendMutex  sync.RWMutex
+	sendClosed bool
+
+	// 事件处理，为空时使用 ext 中的旧回调
+	handler Handler
+	ext     GroupExtData
+
+	// 服务端主动关闭时记录的原因，优先于读错误判断出的原因
+	reasonMutex sync.Mutex
+	reason      *CloseReason
+	closeOnce   sync.Once
+	// 读循环结束时关闭
+	done chan struct{}
+	// 关闭时请求发送循环先发完队列中的消息再发送关闭帧，发送循环退出时关闭 writeDone
+	closeReq  chan closeRequest
+	writeDone chan struct{}
+
+	// 发送消息使用的帧类型，0 表示文本
+	writeType atomic.Int32
+	// 文本消息不合并为一帧，见 SetWriteBatch
+	noBatch atomic.Bool
+	// 接收消息的最大长度，0 表示 maxMessageSize
+	readLimit int64
+	// 按 key 合并发送的消息，见 SendConflated
+	conflateOnce sync.Once
+	conflated    *conflateQueue
+
+	// 虚拟连接收到组内消息时的处理，见 NewVirtualClient
+	deliver func(msg []byte)
+	parent  *Client
+	// 其他传输的连接使用，见 NewStreamClient
+	transport  Transport
+	remoteAddr string
+
+	// 日志，Run 时附带连接 id、组、用户字段
+	logger *log.Logger
+
+	// 运行状态，见 Info
+	connectedAt  time.Time
+	lastActivity atomic.Int64
+	bytesIn      atomic.Int64
+	bytesOut     atomic.Int64
+}
+
+// SetLogger 设置连接的日志，为空时使用 log.Default。需在 Run 之前调用
+func (c *Client) SetLogger(l *log.Logger) {
+	c.logger = l
+}
+
+// Logger 连接的日志，Run 之后附带 conn_id、group、user_id 字段
+func (c *Client) Logger() *log.Logger {
+	if c.logger == nil {
+		return log.Default
+	}
+	return c.logger
+}
+
+// SetWriteType 设置发送消息使用的帧类型，默认 TextMessage。
+// 使用 BinaryMessage 时队列中的消息不再合并为一帧
+func (c *Client) SetWriteType(mt MessageType) {
+	c.writeType.Store(int32(mt))
+}
+
+// WriteType 发送消息使用的帧类型
+func (c *Client) WriteType() MessageType {
+	if mt := MessageType(c.writeType.Load()); mt != 0 {
+		return mt
+	}
+	return TextMessage
+}
 
-	// 定义数据处理函数
-	dealWithMsg func(msg []byte) []byte
+// SetWriteBatch 设置发送队列中的文本消息是否合并为一帧（以换行分隔），默认合并。
+// 对端按帧解析消息（如 JSON 协议的标准客户端）时应关闭
+func (c *Client) SetWriteBatch(enable bool) {
+	c.noBatch.Store(!enable)
+}
+
+// SetReadLimit 设置接收消息的最大长度，默认 512 字节，超过时关闭连接。需在 Run 之前或 OnConnect 中调用
+func (c *Client) SetReadLimit(n int64) {
+	c.readLimit = n
+	if c.Conn != nil && n > 0 {
+		c.Conn.SetReadLimit(n)
+	}
+}
+
+// SetHandler 设置连接的事件处理，优先于 SetDealMsg、SetCloseCallback 等旧回调。需在 Run 之前调用
+func (c *Client) SetHandler(h Handler) {
+	c.handler = h
+}
+
+func (c *Client) getHandler() Handler {
+	if c.handler != nil {
+		return c.handler
+	}
+	return extHandler{ext: &c.ext}
 }
 
 func (c *Client) SetCloseCallback(f func(data interface{})) {
-	c.closeCallback = f
+	c.ext.CloseCallback = f
+	c.ext.CloseCallbackWithCtx = nil
+	c.ext.CloseCallbackWithReason = nil
+}
+
+// SetCloseCallbackWithCtx 关闭回调，ctx 为连接的上下文
+func (c *Client) SetCloseCallbackWithCtx(f func(ctx context.Context, data interface{})) {
+	c.ext.CloseCallbackWithCtx = f
+	c.ext.CloseCallbackWithReason = nil
+}
+
+// SetCloseCallbackWithReason 关闭回调，每种关闭情况都会触发且只触发一次
+func (c *Client) SetCloseCallbackWithReason(f func(ctx context.Context, data interface{}, reason CloseReason)) {
+	c.ext.CloseCallbackWithReason = f
 }
 
 func (c *Client) SetData(data interface{}) {
-	c.initData = data
+	c.ext.CloseSendData = data
 }
 
+// SetExt 应用组扩展配置
+func (c *Client) SetExt(ext *GroupExtData) {
+	if ext == nil {
+		return
+	}
+	c.ext = *ext
+}
+
+// SetContext 设置连接的上下文，只继承 ctx 中的值（如 request id、trace 信息），
+// 不继承其取消信号，新的上下文在连接关闭时取消。需在 Run 之前调用
+func (c *Client) SetContext(ctx context.Context) {
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.ctx, c.cancel = newClientContext(ctx)
+	if c.UserID == "" {
+		c.UserID = UserIDFromContext(ctx)
+	}
+	if c.SessionID == "" {
+		c.SessionID = SessionIDFromContext(ctx)
+	}
+}
+
+// ResumeKey 会话恢复使用的 key，依次取 SessionID、UserID、ID
+func (c *Client) ResumeKey() string {
+	if c.SessionID != "" {
+		return c.SessionID
+	}
+	if c.UserID != "" {
+		return c.UserID
+	}
+	return c.ID
+}
+
+// Context 返回连接的上下文，连接关闭后被取消
+func (c *Client) Context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// Close 关闭连接，关闭原因为 CloseLocal
 func (c *Client) Close() {
+	c.setReason(NewCloseReason(CloseLocal, ""))
+	c.closeConn()
+}
+
+// CloseWith 发送关闭帧并等待对端回应，超时后断开连接
+func (c *Client) CloseWith(code int, text string) error {
+	return c.CloseWithReason(CloseReason{Kind: CloseLocal, Code: code, Text: text})
+}
+
+// CloseWithReason 以 reason 中的关闭码、说明完成关闭握手，OnClose 将收到该原因。
+// 发送队列中已有的消息先于关闭帧发送
+func (c *Client) CloseWithReason(reason CloseReason) error {
+	c.setReason(reason)
+	if c.Conn == nil {
+		c.closeConn()
+		return nil
+	}
+	msg := websocket.FormatCloseMessage(reason.Code, reason.Text)
+	err := c.writeClose(msg)
+	if err != nil && err != websocket.ErrCloseSent {
+		c.closeConn()
+		return err
+	}
+
+	if c.done != nil {
+		timer := time.NewTimer(closeWait)
+		defer timer.Stop()
+		select {
+		case <-c.done:
+		case <-timer.C:
+		}
+	}
+	c.closeConn()
+	return nil
+}
+
+type closeRequest struct {
+	msg []byte
+	err chan error
+}
+
+// writeClose 发送关闭帧，发送循环运行时由其先发完队列中已有的消息（如协议的错误帧）
+func (c *Client) writeClose(msg []byte) error {
+	if c.closeReq != nil {
+		req := closeRequest{msg: msg, err: make(chan error, 1)}
+		timer := time.NewTimer(writeWait)
+		defer timer.Stop()
+		select {
+		case c.closeReq <- req:
+			return <-req.err
+		case <-c.writeDone:
+		case <-timer.C:
+		}
+	}
+	return c.Conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(writeWait))
+}
+
+// flushClose 由发送循环调用，发送队列中已有的消息后发送关闭帧
+func (c *Client) flushClose(msg []byte) error {
+	for n := len(c.Send); n > 0; n-- {
+		queued, ok := <-c.Send
+		if !ok {
+			break
+		}
+		if err := c.writeFrame(queued); err != nil {
+			c.Logger().Event(c.ctx, log.EventWriteError, "write message failed", log.Err(err))
+			break
+		}
+	}
+	return c.Conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(writeWait))
+}
+
+// Terminate 以指定原因直接断开连接，不发送关闭帧，OnClose 将收到该原因
+func (c *Client) Terminate(reason CloseReason) {
+	c.setReason(reason)
+	c.closeConn()
+}
+
+// setReason 记录关闭原因，只有第一次有效
+func (c *Client) setReason(reason CloseReason) {
+	c.reasonMutex.Lock()
+	defer c.reasonMutex.Unlock()
+	if c.reason == nil {
+		c.reason = &reason
+	}
+}
+
+func (c *Client) closeReason(err error) CloseReason {
+	c.reasonMutex.Lock()
+	defer c.reasonMutex.Unlock()
+	if c.reason == nil {
+		r := readErrReason(err)
+		c.reason = &r
+	}
+	return *c.reason
+}
+
+// fireClose 保证每个连接只触发一次 OnClose
+func (c *Client) fireClose(h Handler, err error) {
+	c.closeOnce.Do(func() {
+		reason := c.closeReason(err)
+		if c.parent == nil {
+			metrics.Default.ConnClosed(c.GroupName, reason.Kind.String())
+		}
+		c.callClose(h, reason)
+	})
+}
+
+func (c *Client) closeConn() {
 	defer func() {
 		if p := recover(); p != nil {
-			log.Log.Error(context.Background(), fmt.Sprintf("%v", p))
+			c.Logger().Event(c.Context(), log.EventPanic, "close connection panic", log.F("panic", p))
 		}
 	}()
 
+	if c.cancel != nil {
+		c.cancel()
+	}
+
+	if c.Conn == nil {
+		return
+	}
 	err := c.Conn.Close()
 	if err != nil {
-		log.Log.Error(context.Background(), err.Error())
+		c.Logger().Event(c.Context(), log.EventCloseError, "close connection failed", log.Err(err))
 	}
 }
 
 func (c *Client) readData() {
+	h := c.getHandler()
+	var readErr error
 	defer func() {
+		close(c.done)
+		c.fireClose(h, readErr)
 		if c.Group != nil {
 			c.Group.UnRegister(c)
 		}
 		c.Close()
 	}()
 
-	c.Conn.SetReadLimit(maxMessageSize)
+	readLimit := c.readLimit
+	if readLimit <= 0 {
+		readLimit = maxMessageSize
+	}
+	c.Conn.SetReadLimit(readLimit)
 	err := c.Conn.SetReadDeadline(time.Now().Add(pongWait))
 	if err != nil {
-		log.Log.Error(context.Background(), err.Error())
+		readErr = err
+		c.callError(h, err)
 		return
 	}
 	c.Conn.SetPongHandler(func(string) error {
@@ -91,132 +382,263 @@ func (c *Client) readData() {
 		return nil
 	})
 
+	if err = c.callConnect(h); err != nil {
+		readErr = err
+		c.callError(h, err)
+		return
+	}
+
 	for {
-		_, message, err := c.Conn.ReadMessage()
+		mt, message, err := c.Conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Log.Error(context.Background(), err.Error())
-			}
-			if _, ok := err.(*websocket.CloseError); ok {
-				if c.closeCallback != nil {
-					go c.closeCallback(c.initData)
-				}
+				c.callError(h, err)
 			}
+			readErr = err
 			break
 		}
 		// message = bytes.TrimSpace(bytes.Replace(message, newline, space, -1))
-		if c.dealWithMsg != nil {
-			message = c.dealWithMsg(message)
-			if message == nil {
-				continue
-			}
+		metrics.Default.MsgIn(c.GroupName, len(message))
+		c.bytesIn.Add(int64(len(message)))
+		c.touch()
+		ctx, span := tracing.StartReceive(c.ctx, c.ID, c.UserID, c.GroupName, len(message))
+		if err = c.callMessage(ctx, h, MessageType(mt), message); err != nil {
+			c.callError(h, err)
 		}
-		if c.Group != nil {
-			err := c.Group.SendMsg(message)
-			if err != nil {
-				log.Log.Error(context.Background(), err.Error())
-			}
-		} else {
-			c.Send <- message
+		tracing.End(span, err)
+	}
+}
+
+// Broadcast 将消息发送到连接所在的组，未加入组时只发给自己
+func (c *Client) Broadcast(msg []byte) error {
+	return c.BroadcastCtx(c.Context(), msg)
+}
+
+// BroadcastCtx 同 Broadcast，ctx 中的 trace 信息会随消息传递到其他节点
+func (c *Client) BroadcastCtx(ctx context.Context, msg []byte) error {
+	if c.Group != nil {
+		if g, ok := c.Group.(GroupCtxAPI); ok {
+			return g.SendMsgCtx(ctx, msg)
 		}
+		return c.Group.SendMsg(msg)
 	}
+	c.Send <- msg
+	return nil
 }
 
 func (c *Client) SetDealMsg(f func(msg []byte) []byte) {
-	c.dealWithMsg = f
+	c.ext.ReceiveMsg = f
+	c.ext.ReceiveMsgWithCtx = nil
+}
+
+// SetDealMsgWithCtx 数据处理函数，ctx 为连接的上下文
+func (c *Client) SetDealMsgWithCtx(f func(ctx context.Context, msg []byte) []byte) {
+	c.ext.ReceiveMsgWithCtx = f
 }
 
 func (c *Client) writeData() {
 	ticker := time.NewTicker(pingPeriod)
 	defer func() {
 		ticker.Stop()
+		if c.writeDone != nil {
+			close(c.writeDone)
+		}
 		err := c.Conn.Close()
 		if err != nil {
 
 		}
 	}()
+	conflated := c.conflateQueue()
 	for {
 		select {
 		case message, ok := <-c.Send:
 			err := c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if err != nil {
-				log.Log.Error(context.Background(), err.Error())
+				c.Logger().Event(c.ctx, log.EventWriteError, "set write deadline failed", log.Err(err))
 			}
 			if !ok {
 				// The group closed the channel.
 				err = c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
 				if err != nil {
-					log.Log.Error(context.Background(), err.Error())
+					c.Logger().Event(c.ctx, log.EventWriteError, "write close message failed", log.Err(err))
 				}
 				return
 			}
 
-			w, err := c.Conn.NextWriter(websocket.TextMessage)
+			mt := c.WriteType()
+			w, err := c.Conn.NextWriter(int(mt))
 			if err != nil {
-				log.Log.Error(context.Background(), err.Error())
+				c.Logger().Event(c.ctx, log.EventWriteError, "get next writer failed", log.Err(err))
 				return
 			}
 			_, err = w.Write(message)
 			if err != nil {
-				log.Log.Error(context.Background(), err.Error())
+				c.Logger().Event(c.ctx, log.EventWriteError, "write message failed", log.Err(err))
 				return
 			}
+			metrics.Default.MsgOut(c.GroupName, len(message))
+			c.bytesOut.Add(int64(len(message)))
 
 			// Add queued chat messages to the current websocket message.
+			// 二进制消息无法用换行分隔，每条消息单独一帧；关闭合并时文本消息也单独一帧
 			n := len(c.Send)
+			if mt != TextMessage || c.noBatch.Load() {
+				n = 0
+			}
 			for i := 0; i < n; i++ {
 				_, err = w.Write(newline)
 				if err != nil {
-					log.Log.Error(context.Background(), err.Error())
+					c.Logger().Event(c.ctx, log.EventWriteError, "write message failed", log.Err(err))
 				}
-				_, err = w.Write(<-c.Send)
+				queued := <-c.Send
+				_, err = w.Write(queued)
 				if err != nil {
-					log.Log.Error(context.Background(), err.Error())
+					c.Logger().Event(c.ctx, log.EventWriteError, "write message failed", log.Err(err))
 				}
+				metrics.Default.MsgOut(c.GroupName, len(queued))
+				c.bytesOut.Add(int64(len(queued)))
 			}
 
 			if err = w.Close(); err != nil {
-				log.Log.Error(context.Background(), err.Error())
+				c.Logger().Event(c.ctx, log.EventWriteError, "flush message failed", log.Err(err))
 				return
 			}
+			c.touch()
+		case <-conflated.notify:
+			// 合并的消息每条单独一帧，发送期间到达的更新覆盖未发送的旧值
+			for msg := conflated.next(time.Now()); msg != nil; msg = conflated.next(time.Now()) {
+				if err := c.writeFrame(msg); err != nil {
+					c.Logger().Event(c.ctx, log.EventWriteError, "write message failed", log.Err(err))
+					return
+				}
+			}
+		case req := <-c.closeReq:
+			req.err <- c.flushClose(req.msg)
 		case <-ticker.C:
 			err := c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if err != nil {
-				log.Log.Error(context.Background(), err.Error())
+				c.Logger().Event(c.ctx, log.EventWriteError, "set write deadline failed", log.Err(err))
 			}
 			if err = c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
-				log.Log.Error(context.Background(), err.Error())
+				c.Logger().Event(c.ctx, log.EventWriteError, "write ping failed", log.Err(err))
 				return
 			}
 		}
 	}
 }
 
+// writeFrame 以一帧发送一条消息
+func (c *Client) writeFrame(msg []byte) error {
+	if err := c.Conn.SetWriteDeadline(time.Now().Add(writeWait)); err != nil {
+		c.Logger().Event(c.ctx, log.EventWriteError, "set write deadline failed", log.Err(err))
+	}
+	if err := c.Conn.WriteMessage(int(c.WriteType()), msg); err != nil {
+		return err
+	}
+	metrics.Default.MsgOut(c.GroupName, len(msg))
+	c.bytesOut.Add(int64(len(msg)))
+	c.touch()
+	return nil
+}
+
 func (c *Client) SendMsg(msg string) {
 	c.Send <- []byte(msg)
 }
 
+// SendBytes 只发送给当前连接
+func (c *Client) SendBytes(msg []byte) {
+	c.Send <- msg
+}
+
+// TrySend 不阻塞地发送给当前连接，Send 已关闭或队列已满时返回 false。
+// 可在连接可能已离开组的 goroutine（如定时器）中调用
+func (c *Client) TrySend(msg []byte) bool {
+	c.sendMutex.RLock()
+	defer c.sendMutex.RUnlock()
+	if c.sendClosed {
+		return false
+	}
+	select {
+	case c.Send <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+// CloseSend 关闭 Send，可重复调用。组移除连接时使用，不要直接 close(c.Send)
+func (c *Client) CloseSend() {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+	if !c.sendClosed {
+		c.sendClosed = true
+		close(c.Send)
+	}
+}
+
 func (c *Client) Run() {
+	if c.ctx == nil {
+		c.SetContext(context.Background())
+	}
+	if c.ID == "" {
+		c.ID = newConnID()
+	}
+	if c.done == nil {
+		c.done = make(chan struct{})
+	}
+	c.logger = c.Logger().With(log.F("conn_id", c.ID), log.F("group", c.GroupName), log.F("user_id", c.UserID))
+	if c.connectedAt.IsZero() {
+		c.connectedAt = time.Now()
+	}
+	if c.parent == nil {
+		metrics.Default.ConnOpened(c.GroupName)
+	}
+	if c.deliver != nil {
+		go c.forward()
+		return
+	}
 	go c.readData()
 	go c.writeData()
 }
 
+func newConnID() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
+
+// NewClient 创建连接，ctx 一般为 http 请求的 context，sendLen 为发送队列长度
+func NewClient(ctx context.Context, conn *websocket.Conn, sendLen int) *Client {
+	c := &Client{
+		ID:   newConnID(),
+		Conn: conn,
+		Send: make(chan []byte, sendLen),
+		done: make(chan struct{}),
+
+		closeReq:  make(chan closeRequest),
+		writeDone: make(chan struct{}),
+
+		connectedAt: time.Now(),
+	}
+	c.SetContext(ctx)
+	return c
+}
+
 func NewWS(w http.ResponseWriter, r *http.Request, upgrade *websocket.Upgrader) (*Client, error) {
 	if upgrade == nil {
 		upgrade = &config.WSDefaultUpdate
 	}
-	conn, err := upgrade.Upgrade(w, r, nil)
+	_, span := tracing.StartUpgrade(r.Context(), "")
+	conn, err := upgrade.Upgrade(w, r, w.Header())
+	tracing.End(span, err)
 	if err != nil {
 		return nil, err
 	}
 
-	client := &Client{
-		GroupName: "",
-		Group:     nil,
-		Conn:      conn,
-		Send:      make(chan []byte, 256),
-	}
-
+	client := NewClient(r.Context(), conn, 256)
 	client.Run()
 	return client, nil
 }
@@ -0,0 +1,123 @@
+// Package websocket
+package websocket
+
+import (
+	"errors"
+	"net"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrBanned 用户被禁止加入该组
+var ErrBanned = errors.New("user is banned from group")
+
+// ErrClosed 连接已关闭
+var ErrClosed = errors.New("connection is closed")
+
+// ErrNotSent 发送队列已满或连接已关闭，消息未发送
+var ErrNotSent = errors.New("send queue full or connection closed")
+
+// CloseKind 连接关闭的原因分类
+type CloseKind int
+
+const (
+	// ClosePeer 对端发送了关闭帧
+	ClosePeer CloseKind = iota + 1
+	// CloseAbnormal 连接异常断开，未收到关闭帧
+	CloseAbnormal
+	// CloseTimeout 读超时，心跳丢失
+	CloseTimeout
+	// CloseReadLimit 消息超过最大长度
+	CloseReadLimit
+	// CloseSlowConsumer 发送队列已满，连接消费过慢
+	CloseSlowConsumer
+	// CloseServerShutdown 服务关闭
+	CloseServerShutdown
+	// CloseKicked 被服务端踢出
+	CloseKicked
+	// CloseLocal 服务端主动关闭
+	CloseLocal
+	// CloseInternalError 用户回调 panic
+	CloseInternalError
+)
+
+var closeKindNames = map[CloseKind]string{
+	ClosePeer:           "peer",
+	CloseAbnormal:       "abnormal",
+	CloseTimeout:        "timeout",
+	CloseReadLimit:      "read_limit",
+	CloseSlowConsumer:   "slow_consumer",
+	CloseServerShutdown: "server_shutdown",
+	CloseKicked:         "kicked",
+	CloseLocal:          "local",
+	CloseInternalError:  "internal_error",
+}
+
+func (k CloseKind) String() string {
+	if s, ok := closeKindNames[k]; ok {
+		return s
+	}
+	return "unknown"
+}
+
+// closeKindCodes 服务端判定的关闭原因对应的关闭码
+var closeKindCodes = map[CloseKind]int{
+	CloseAbnormal:       websocket.CloseAbnormalClosure,
+	CloseTimeout:        websocket.CloseAbnormalClosure,
+	CloseReadLimit:      websocket.CloseMessageTooBig,
+	CloseSlowConsumer:   websocket.CloseTryAgainLater,
+	CloseServerShutdown: websocket.CloseGoingAway,
+	CloseKicked:         websocket.ClosePolicyViolation,
+	CloseLocal:          websocket.CloseNormalClosure,
+	CloseInternalError:  websocket.CloseInternalServerErr,
+}
+
+// CloseReason 连接关闭的原因
+type CloseReason struct {
+	Kind CloseKind
+	// Code 关闭码，对端关闭时为关闭帧中的值
+	Code int
+	// Text 关闭说明，对端关闭时为关闭帧中的值
+	Text string
+	// Err 导致关闭的错误，可能为空
+	Err error
+}
+
+// NewCloseReason 按原因分类生成 CloseReason，使用该分类默认的关闭码
+func NewCloseReason(kind CloseKind, text string) CloseReason {
+	code, ok := closeKindCodes[kind]
+	if !ok {
+		code = websocket.CloseNormalClosure
+	}
+	return CloseReason{Kind: kind, Code: code, Text: text}
+}
+
+func (r CloseReason) String() string {
+	if r.Text == "" {
+		return r.Kind.String()
+	}
+	return r.Kind.String() + ": " + r.Text
+}
+
+// readErrReason 由读错误判断关闭原因
+func readErrReason(err error) CloseReason {
+	var ce *websocket.CloseError
+	// 1006 不会出现在关闭帧中，gorilla 在连接意外断开时生成
+	if errors.As(err, &ce) && ce.Code != websocket.CloseAbnormalClosure {
+		return CloseReason{Kind: ClosePeer, Code: ce.Code, Text: ce.Text, Err: err}
+	}
+
+	var kind CloseKind
+	var ne net.Error
+	switch {
+	case errors.Is(err, websocket.ErrReadLimit):
+		kind = CloseReadLimit
+	case errors.As(err, &ne) && ne.Timeout():
+		kind = CloseTimeout
+	default:
+		kind = CloseAbnormal
+	}
+	r := NewCloseReason(kind, err.Error())
+	r.Err = err
+	return r
+}
@@ -0,0 +1,184 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// startServer 每个 ws 连接以 h 运行，返回服务地址
+func startServer(t *testing.T, h Handler) string {
+	t.Helper()
+	up := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := up.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		c := NewClient(r.Context(), conn, 16)
+		c.SetHandler(h)
+		c.Run()
+	}))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func dial(t *testing.T, url string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+// reasonHandler OnClose 时将原因写入 ch，收到 "close" 时服务端主动关闭
+func reasonHandler(ch chan<- CloseReason) Handler {
+	return HandlerFuncs{
+		Message: func(ctx context.Context, c *Client, mt MessageType, msg []byte) error {
+			switch string(msg) {
+			case "close":
+				go c.Close()
+			case "kick":
+				go c.CloseWithReason(NewCloseReason(CloseKicked, "bye"))
+			}
+			return nil
+		},
+		Close: func(ctx context.Context, c *Client, reason CloseReason) {
+			ch <- reason
+		},
+	}
+}
+
+func waitReason(t *testing.T, ch <-chan CloseReason) CloseReason {
+	t.Helper()
+	select {
+	case r := <-ch:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnClose not called")
+		return CloseReason{}
+	}
+}
+
+type timeoutErr struct{}
+
+func (timeoutErr) Error() string   { return "i/o timeout" }
+func (timeoutErr) Timeout() bool   { return true }
+func (timeoutErr) Temporary() bool { return true }
+
+var _ net.Error = timeoutErr{}
+
+func TestReadErrReason(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		kind CloseKind
+		code int
+	}{
+		{"peer", &websocket.CloseError{Code: websocket.CloseGoingAway, Text: "away"}, ClosePeer, websocket.CloseGoingAway},
+		{"read limit", websocket.ErrReadLimit, CloseReadLimit, websocket.CloseMessageTooBig},
+		{"timeout", timeoutErr{}, CloseTimeout, websocket.CloseAbnormalClosure},
+		{"abnormal", errors.New("unexpected EOF"), CloseAbnormal, websocket.CloseAbnormalClosure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := readErrReason(tt.err)
+			if r.Kind != tt.kind || r.Code != tt.code || r.Err == nil {
+				t.Fatalf("got %+v, want kind %v code %d", r, tt.kind, tt.code)
+			}
+		})
+	}
+}
+
+func TestCloseReasonPaths(t *testing.T) {
+	tests := []struct {
+		name string
+		// act 客户端的操作
+		act  func(conn *websocket.Conn)
+		kind CloseKind
+		code int
+	}{
+		{
+			name: "peer close frame",
+			act: func(conn *websocket.Conn) {
+				msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "done")
+				_ = conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+			},
+			kind: ClosePeer,
+			code: websocket.CloseNormalClosure,
+		},
+		{
+			name: "abnormal drop",
+			act:  func(conn *websocket.Conn) { _ = conn.UnderlyingConn().Close() },
+			kind: CloseAbnormal,
+			code: websocket.CloseAbnormalClosure,
+		},
+		{
+			name: "read limit",
+			act: func(conn *websocket.Conn) {
+				_ = conn.WriteMessage(websocket.TextMessage, make([]byte, maxMessageSize+1))
+			},
+			kind: CloseReadLimit,
+			code: websocket.CloseMessageTooBig,
+		},
+		{
+			name: "local close",
+			act:  func(conn *websocket.Conn) { _ = conn.WriteMessage(websocket.TextMessage, []byte("close")) },
+			kind: CloseLocal,
+			code: websocket.CloseNormalClosure,
+		},
+		{
+			name: "close handshake with reason",
+			act:  func(conn *websocket.Conn) { _ = conn.WriteMessage(websocket.TextMessage, []byte("kick")) },
+			kind: CloseKicked,
+			code: websocket.ClosePolicyViolation,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := make(chan CloseReason, 1)
+			conn := dial(t, startServer(t, reasonHandler(ch)))
+			tt.act(conn)
+			go func() {
+				// 读到关闭帧后回应，完成关闭握手
+				for {
+					if _, _, err := conn.ReadMessage(); err != nil {
+						return
+					}
+				}
+			}()
+			r := waitReason(t, ch)
+			if r.Kind != tt.kind || r.Code != tt.code {
+				t.Fatalf("got %v (code %d), want %v (code %d)", r.Kind, r.Code, tt.kind, tt.code)
+			}
+		})
+	}
+}
+
+func TestCloseReasonTerminate(t *testing.T) {
+	ch := make(chan CloseReason, 1)
+	clients := make(chan *Client, 1)
+	h := reasonHandler(ch)
+	url := startServer(t, HandlerFuncs{
+		Connect: func(ctx context.Context, c *Client) error {
+			clients <- c
+			return nil
+		},
+		Close: h.OnClose,
+	})
+	dial(t, url)
+	c := <-clients
+	c.Terminate(NewCloseReason(CloseSlowConsumer, ""))
+	if r := waitReason(t, ch); r.Kind != CloseSlowConsumer || r.Code != websocket.CloseTryAgainLater {
+		t.Fatalf("got %+v", r)
+	}
+}
@@ -0,0 +1,113 @@
+// Package codec 消息编解码，提供 JSON、msgpack、protobuf 实现及类型化的发送、处理函数
+package codec
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/assembly-hub/websocket"
+)
+
+// Codec 消息编解码
+type Codec interface {
+	Name() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+	// MessageType 编码结果使用的帧类型
+	MessageType() websocket.MessageType
+}
+
+var (
+	JSON     Codec = jsonCodec{}
+	MsgPack  Codec = msgpackCodec{}
+	Protobuf Codec = protoCodec{}
+)
+
+var (
+	protocolMutex sync.RWMutex
+	protocols     = map[string]Codec{"json": JSON, "msgpack": MsgPack, "protobuf": Protobuf}
+)
+
+// Register 子协议使用的编解码，默认子协议 json、msgpack、protobuf 对应同名编解码
+func Register(subprotocol string, c Codec) {
+	protocolMutex.Lock()
+	defer protocolMutex.Unlock()
+	protocols[subprotocol] = c
+}
+
+// ForClient 连接协商出的子协议对应的编解码，未协商或未注册时为 JSON
+func ForClient(cli *websocket.Client) Codec {
+	protocolMutex.RLock()
+	defer protocolMutex.RUnlock()
+	if c, ok := protocols[cli.Subprotocol()]; ok {
+		return c
+	}
+	return JSON
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) MessageType() websocket.MessageType {
+	return websocket.TextMessage
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string {
+	return "msgpack"
+}
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+func (msgpackCodec) MessageType() websocket.MessageType {
+	return websocket.BinaryMessage
+}
+
+// protoCodec 只支持 proto.Message
+type protoCodec struct{}
+
+func (protoCodec) Name() string {
+	return "protobuf"
+}
+
+func (protoCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("protobuf codec: %T is not a proto.Message", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (protoCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("protobuf codec: %T is not a proto.Message", v)
+	}
+	return proto.Unmarshal(data, m)
+}
+
+func (protoCodec) MessageType() websocket.MessageType {
+	return websocket.BinaryMessage
+}
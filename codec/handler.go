@@ -0,0 +1,125 @@
+package codec
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+
+	"github.com/assembly-hub/websocket"
+)
+
+// 错误帧中的错误码
+const (
+	ErrCodeDecode = "decode_error"
+)
+
+// ErrNotSent 发送队列已满或连接已关闭，消息未发送
+var ErrNotSent = errors.New("codec: send queue full or connection closed")
+
+// ErrorFrame 发给客户端的错误帧
+type ErrorFrame struct {
+	Error ErrorBody `json:"error" msgpack:"error"`
+}
+
+type ErrorBody struct {
+	Code    string `json:"code" msgpack:"code"`
+	Message string `json:"message" msgpack:"message"`
+}
+
+// SendError 向连接发送错误帧，codec 无法编码或与连接的发送帧类型不一致时（如 protobuf）改用 JSON 编码，
+// 仍以连接的发送帧类型发送。不阻塞，发送队列已满或连接已关闭时丢弃
+func SendError(cli *websocket.Client, c Codec, code, msg string) {
+	frame := &ErrorFrame{Error: ErrorBody{Code: code, Message: msg}}
+	data, err := c.Marshal(frame)
+	if err != nil || c.MessageType() != cli.WriteType() {
+		data, _ = json.Marshal(frame)
+	}
+	cli.TrySend(data)
+}
+
+// Send 编码后只发送给 cli，不阻塞，发送队列已满或连接已关闭时返回 ErrNotSent
+func Send(cli *websocket.Client, c Codec, v interface{}) error {
+	data, err := c.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if !cli.TrySend(data) {
+		return ErrNotSent
+	}
+	return nil
+}
+
+// Broadcast 编码后发送到 cli 所在的组
+func Broadcast(ctx context.Context, cli *websocket.Client, c Codec, v interface{}) error {
+	data, err := c.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return cli.BroadcastCtx(ctx, data)
+}
+
+// GroupSender 可向组发送消息的管理器，simplesub、singlesub、multisub 的 Manage 均已实现
+type GroupSender interface {
+	SendMsgCtx(ctx context.Context, groupName string, msg string) error
+}
+
+// SendToGroup 编码后发送到组
+func SendToGroup(ctx context.Context, s GroupSender, c Codec, groupName string, v interface{}) error {
+	data, err := c.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.SendMsgCtx(ctx, groupName, string(data))
+}
+
+// Typed 将收到的消息解码为 T 后交给 Handle，解码失败时向客户端发送 decode_error 错误帧。
+// 连接建立时将发送帧类型设置为 Codec 对应的类型，并关闭文本消息的合并发送。Codec 为空时使用 JSON，
+// 嵌入后可覆盖 OnClose 等方法
+type Typed[T any] struct {
+	websocket.NopHandler
+	Codec  Codec
+	Handle func(ctx context.Context, cli *websocket.Client, msg T) error
+}
+
+// Handle 创建类型化的 Handler，c 为空时使用 JSON
+func Handle[T any](c Codec, f func(ctx context.Context, cli *websocket.Client, msg T) error) *Typed[T] {
+	if c == nil {
+		c = JSON
+	}
+	return &Typed[T]{Codec: c, Handle: f}
+}
+
+func (h *Typed[T]) codec() Codec {
+	if h.Codec == nil {
+		return JSON
+	}
+	return h.Codec
+}
+
+func (h *Typed[T]) OnConnect(ctx context.Context, cli *websocket.Client) error {
+	cli.SetWriteType(h.codec().MessageType())
+	cli.SetWriteBatch(false)
+	return nil
+}
+
+func (h *Typed[T]) OnMessage(ctx context.Context, cli *websocket.Client, mt websocket.MessageType, data []byte) error {
+	c := h.codec()
+	msg, err := decode[T](c, data)
+	if err != nil {
+		SendError(cli, c, ErrCodeDecode, err.Error())
+		return nil
+	}
+	return h.Handle(ctx, cli, msg)
+}
+
+// decode 解码为 T，T 为指针类型（如 protobuf 消息）时自动分配
+func decode[T any](c Codec, data []byte) (T, error) {
+	var msg T
+	rv := reflect.ValueOf(&msg).Elem()
+	if rv.Kind() == reflect.Pointer {
+		rv.Set(reflect.New(rv.Type().Elem()))
+		return msg, c.Unmarshal(data, msg)
+	}
+	return msg, c.Unmarshal(data, &msg)
+}
@@ -0,0 +1,120 @@
+package codec
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/assembly-hub/websocket"
+)
+
+type chat struct {
+	Text string `json:"text" msgpack:"text"`
+	N    int    `json:"n" msgpack:"n"`
+}
+
+func newTestClient() *websocket.Client {
+	return websocket.NewClient(context.Background(), nil, 4)
+}
+
+func readSent(t *testing.T, c *websocket.Client) []byte {
+	t.Helper()
+	select {
+	case data := <-c.Send:
+		return data
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing sent")
+		return nil
+	}
+}
+
+func TestTypedDecode(t *testing.T) {
+	for _, c := range []Codec{JSON, MsgPack, nil} {
+		got := make(chan chat, 1)
+		h := Handle(c, func(ctx context.Context, cli *websocket.Client, msg chat) error {
+			got <- msg
+			return nil
+		})
+		cli := newTestClient()
+		if err := h.OnConnect(context.Background(), cli); err != nil {
+			t.Fatal(err)
+		}
+		if cli.WriteType() != h.Codec.MessageType() {
+			t.Fatalf("%s: write type %v", h.Codec.Name(), cli.WriteType())
+		}
+		data, err := h.Codec.Marshal(&chat{Text: "hi", N: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := h.OnMessage(context.Background(), cli, h.Codec.MessageType(), data); err != nil {
+			t.Fatal(err)
+		}
+		if msg := <-got; msg.Text != "hi" || msg.N != 1 {
+			t.Fatalf("%s: got %+v", h.Codec.Name(), msg)
+		}
+	}
+}
+
+func TestTypedNilCodec(t *testing.T) {
+	h := &Typed[chat]{Handle: func(ctx context.Context, cli *websocket.Client, msg chat) error { return nil }}
+	cli := newTestClient()
+	if err := h.OnConnect(context.Background(), cli); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.OnMessage(context.Background(), cli, websocket.TextMessage, []byte(`{"text": "hi"}`)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTypedDecodeError(t *testing.T) {
+	called := false
+	h := Handle(JSON, func(ctx context.Context, cli *websocket.Client, msg chat) error {
+		called = true
+		return nil
+	})
+	cli := newTestClient()
+	_ = h.OnConnect(context.Background(), cli)
+	if err := h.OnMessage(context.Background(), cli, websocket.TextMessage, []byte(`{"n": "x"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if called {
+		t.Fatal("Handle called with invalid message")
+	}
+	var frame ErrorFrame
+	if err := json.Unmarshal(readSent(t, cli), &frame); err != nil {
+		t.Fatal(err)
+	}
+	if frame.Error.Code != ErrCodeDecode || frame.Error.Message == "" {
+		t.Fatalf("got %+v", frame)
+	}
+}
+
+func TestSendRoundTrip(t *testing.T) {
+	want := &chat{Text: "hello", N: 2}
+	for _, c := range []Codec{JSON, MsgPack} {
+		cli := newTestClient()
+		if err := Send(cli, c, want); err != nil {
+			t.Fatal(err)
+		}
+		var got chat
+		if err := c.Unmarshal(readSent(t, cli), &got); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(&got, want) {
+			t.Fatalf("%s: got %+v", c.Name(), got)
+		}
+	}
+}
+
+func TestSendClosed(t *testing.T) {
+	cli := newTestClient()
+	cli.CloseSend()
+	if err := Send(cli, JSON, &chat{}); !errors.Is(err, ErrNotSent) {
+		t.Fatalf("got %v", err)
+	}
+	// 不 panic
+	SendError(cli, JSON, ErrCodeDecode, "x")
+}
@@ -0,0 +1,150 @@
+package websocket
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Conflation 组的合并发送配置，见各管理器的 SetConflation。
+// 带 key 的消息不进入连接的 Send 队列，每个连接每个 key 只保留最新一条，
+// 连接发送积压时旧值被覆盖，不会因队列满被断开；合并的消息与普通消息之间不保证顺序
+type Conflation struct {
+	// Key 消息的合并 key，返回空时按普通消息发送，为 nil 时使用 EventKey
+	Key func(msg []byte) string
+	// Interval 每个 key 两次发送的最小间隔，期间的更新只保留最新一条，为 0 时不节流
+	Interval time.Duration
+}
+
+// KeyOf 消息的合并 key，cf 为 nil 时返回空
+func (cf *Conflation) KeyOf(msg []byte) string {
+	if cf == nil {
+		return ""
+	}
+	if cf.Key == nil {
+		return EventKey(msg)
+	}
+	return cf.Key(msg)
+}
+
+// EventKey 事件消息中的 key 字段，见 NewKeyedEventMessage
+func EventKey(msg []byte) string {
+	var em struct {
+		Key string `json:"key"`
+	}
+	if json.Unmarshal(msg, &em) != nil {
+		return ""
+	}
+	return em.Key
+}
+
+// NewKeyedEventMessage 编码带合并 key 的事件消息：{"event": "...", "key": "...", "data": ...}
+func NewKeyedEventMessage(event, key string, data interface{}) ([]byte, error) {
+	msg := EventMessage{Event: event, Key: key}
+	if data != nil {
+		raw, err := json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		msg.Data = raw
+	}
+	return json.Marshal(&msg)
+}
+
+// SendConflated 按 key 合并发送，发送积压时每个 key 只保留最新一条，
+// interval 大于 0 时每个 key 每个间隔最多发送一条
+func (c *Client) SendConflated(key string, msg []byte, interval time.Duration) {
+	c.conflateQueue().push(key, msg, interval)
+}
+
+// conflateQueue 连接的合并队列，由发送循环取出
+func (c *Client) conflateQueue() *conflateQueue {
+	c.conflateOnce.Do(func() {
+		c.conflated = &conflateQueue{
+			pending: map[string]conflated{},
+			ready:   map[string]time.Time{},
+			notify:  make(chan struct{}, 1),
+		}
+	})
+	return c.conflated
+}
+
+// conflatePrune 清理节流记录的最小间隔
+const conflatePrune = time.Second
+
+// conflated 等待发送的合并消息，interval 为推入时组的节流间隔
+type conflated struct {
+	msg      []byte
+	interval time.Duration
+}
+
+type conflateQueue struct {
+	mutex sync.Mutex
+	// 每个 key 最新的消息，order 为等待发送的 key，按首次到达的顺序
+	pending map[string]conflated
+	order   []string
+	// 节流中的 key 下次可发送的时间，连接加入多个组时各 key 按各自组的间隔节流
+	ready  map[string]time.Time
+	pruned time.Time
+	timer  *time.Timer
+
+	// 有消息可发送时可读
+	notify chan struct{}
+}
+
+func (q *conflateQueue) push(key string, msg []byte, interval time.Duration) {
+	q.mutex.Lock()
+	if _, ok := q.pending[key]; !ok {
+		q.order = append(q.order, key)
+	}
+	q.pending[key] = conflated{msg: msg, interval: interval}
+	q.mutex.Unlock()
+	q.signal()
+}
+
+func (q *conflateQueue) signal() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// next 取出下一条可发送的消息，没有时返回 nil，节流中的 key 到期后再次通知
+func (q *conflateQueue) next(now time.Time) []byte {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	var wait time.Duration
+	for i, key := range q.order {
+		p := q.pending[key]
+		if d := q.ready[key].Sub(now); d > 0 {
+			if wait == 0 || d < wait {
+				wait = d
+			}
+			continue
+		}
+		if p.interval > 0 {
+			q.ready[key] = now.Add(p.interval)
+		}
+		delete(q.pending, key)
+		q.order = append(q.order[:i], q.order[i+1:]...)
+		return p.msg
+	}
+
+	if wait > 0 {
+		if q.timer == nil {
+			q.timer = time.AfterFunc(wait, q.signal)
+		} else {
+			q.timer.Reset(wait)
+		}
+	}
+	// 清理已过节流间隔的 key
+	if len(q.ready) > 0 && now.Sub(q.pruned) >= conflatePrune {
+		for key, t := range q.ready {
+			if !now.Before(t) {
+				delete(q.ready, key)
+			}
+		}
+		q.pruned = now
+	}
+	return nil
+}
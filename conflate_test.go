@@ -0,0 +1,44 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConflateIntervalPerKey(t *testing.T) {
+	c := &Client{}
+	q := c.conflateQueue()
+	now := time.Now()
+
+	q.push("a", []byte("a1"), time.Hour)
+	if got := q.next(now); string(got) != "a1" {
+		t.Fatalf("got %q", got)
+	}
+	// 其他组不节流的 key 不会覆盖 a 的间隔
+	q.push("a", []byte("a2"), time.Hour)
+	q.push("b", []byte("b1"), 0)
+	now = now.Add(time.Millisecond)
+	if got := q.next(now); string(got) != "b1" {
+		t.Fatalf("got %q", got)
+	}
+	if got := q.next(now); got != nil {
+		t.Fatalf("throttled key sent: %q", got)
+	}
+	if got := q.next(now.Add(time.Hour)); string(got) != "a2" {
+		t.Fatalf("got %q", got)
+	}
+	q.timer.Stop()
+}
+
+func TestEventKey(t *testing.T) {
+	msg, err := NewKeyedEventMessage("quote", "AAPL", map[string]float64{"px": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key := EventKey(msg); key != "AAPL" {
+		t.Fatalf("got %q", key)
+	}
+	if key := EventKey([]byte("plain")); key != "" {
+		t.Fatalf("got %q", key)
+	}
+}
@@ -0,0 +1,69 @@
+// Package websocket
+package websocket
+
+import (
+	"context"
+	"time"
+)
+
+// detachedCtx 只保留父 context 中的值，不继承其取消信号和截止时间。
+// http 请求的 context 会在 handler 返回时取消，而 ws 连接的生命周期远长于此。
+type detachedCtx struct {
+	parent context.Context
+}
+
+func (detachedCtx) Deadline() (deadline time.Time, ok bool) {
+	return
+}
+
+func (detachedCtx) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedCtx) Err() error {
+	return nil
+}
+
+func (c detachedCtx) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
+
+func newClientContext(parent context.Context) (context.Context, context.CancelFunc) {
+	if parent == nil {
+		parent = context.Background()
+	}
+	return context.WithCancel(detachedCtx{parent: parent})
+}
+
+type userIDKey struct{}
+
+// WithUserID 在请求 context 中记录用户 id，加入组时会设置到 Client.UserID，用于 Kick、Ban
+func WithUserID(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, userIDKey{}, userID)
+}
+
+// UserIDFromContext 获取 WithUserID 记录的用户 id
+func UserIDFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	userID, _ := ctx.Value(userIDKey{}).(string)
+	return userID
+}
+
+type sessionIDKey struct{}
+
+// WithSessionID 在请求 context 中记录会话 id，加入组时会设置到 Client.SessionID。
+// 会话 id 应由服务端鉴权后确定，客户端重连时带上同一会话的凭证
+func WithSessionID(ctx context.Context, sessionID string) context.Context {
+	return context.WithValue(ctx, sessionIDKey{}, sessionID)
+}
+
+// SessionIDFromContext 获取 WithSessionID 记录的会话 id
+func SessionIDFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	sessionID, _ := ctx.Value(sessionIDKey{}).(string)
+	return sessionID
+}
@@ -0,0 +1,51 @@
+// Package envelope 跨节点消息的封装，在消息体前附带 header（如 trace 上下文）。
+// 没有 header 时消息保持原样，与未封装的旧消息兼容
+package envelope
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+)
+
+// magic 封装消息的前缀，普通文本消息不会以 0 字节开头
+var magic = []byte("\x00wse1")
+
+// Encode 封装消息，header 为空时直接返回 payload
+func Encode(header map[string]string, payload []byte) []byte {
+	if len(header) == 0 {
+		return payload
+	}
+
+	h, err := json.Marshal(header)
+	if err != nil {
+		return payload
+	}
+
+	buf := make([]byte, 0, len(magic)+binary.MaxVarintLen64+len(h)+len(payload))
+	buf = append(buf, magic...)
+	buf = binary.AppendUvarint(buf, uint64(len(h)))
+	buf = append(buf, h...)
+	buf = append(buf, payload...)
+	return buf
+}
+
+// Decode 解析消息，未封装的消息返回空 header 和原消息
+func Decode(data []byte) (header map[string]string, payload []byte) {
+	if !bytes.HasPrefix(data, magic) {
+		return nil, data
+	}
+
+	rest := data[len(magic):]
+	n, size := binary.Uvarint(rest)
+	if size <= 0 || uint64(len(rest)-size) < n {
+		return nil, data
+	}
+	rest = rest[size:]
+
+	header = map[string]string{}
+	if err := json.Unmarshal(rest[:n], &header); err != nil {
+		return nil, data
+	}
+	return header, rest[n:]
+}
@@ -0,0 +1,144 @@
+// Package websocket
+package websocket
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/assembly-hub/websocket/log"
+)
+
+// EventType 管理器的生命周期事件类型
+type EventType string
+
+const (
+	EventGroupCreated      EventType = "group_created"
+	EventGroupDestroyed    EventType = "group_destroyed"
+	EventClientJoined      EventType = "client_joined"
+	EventClientLeft        EventType = "client_left"
+	EventMessageDropped    EventType = "message_dropped"
+	EventRedisDisconnected EventType = "redis_disconnected"
+)
+
+// Event 管理器的生命周期事件
+type Event struct {
+	Type     EventType
+	Group    string
+	ClientID string
+	UserID   string
+	// Reason 事件说明，如连接离开、消息丢弃的原因
+	Reason string
+	Err    error
+	Time   time.Time
+}
+
+const defaultEventQueueLen = 1024
+
+// EventBus 异步分发事件，队列满时丢弃事件，监听者处理慢不会阻塞组的处理
+type EventBus struct {
+	mutex     sync.RWMutex
+	listeners map[uint64]func(Event)
+	nextID    uint64
+
+	queue   chan Event
+	once    sync.Once
+	dropped atomic.Int64
+	// 监听者 panic 时使用的日志，见 SetLogger
+	logger atomic.Pointer[log.Logger]
+}
+
+// NewEventBus 创建事件分发，size 为待分发队列长度
+func NewEventBus(size int) *EventBus {
+	if size <= 0 {
+		size = defaultEventQueueLen
+	}
+	return &EventBus{
+		listeners: map[uint64]func(Event){},
+		queue:     make(chan Event, size),
+	}
+}
+
+// Subscribe 订阅事件，返回取消订阅的函数
+func (b *EventBus) Subscribe(f func(Event)) (cancel func()) {
+	b.mutex.Lock()
+	b.nextID++
+	id := b.nextID
+	b.listeners[id] = f
+	b.mutex.Unlock()
+
+	b.once.Do(func() {
+		go b.dispatch()
+	})
+	return func() {
+		b.mutex.Lock()
+		delete(b.listeners, id)
+		b.mutex.Unlock()
+	}
+}
+
+// Emit 发布事件，不阻塞；没有监听者时直接丢弃
+func (b *EventBus) Emit(e Event) {
+	if b == nil {
+		return
+	}
+	b.mutex.RLock()
+	n := len(b.listeners)
+	b.mutex.RUnlock()
+	if n == 0 {
+		return
+	}
+
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	select {
+	case b.queue <- e:
+	default:
+		b.dropped.Add(1)
+	}
+}
+
+// SetLogger 设置记录监听者 panic 的日志，为 nil 时使用 log.Default，管理器的 SetLogger 会同时设置
+func (b *EventBus) SetLogger(l *log.Logger) {
+	b.logger.Store(l)
+}
+
+func (b *EventBus) getLogger() *log.Logger {
+	if l := b.logger.Load(); l != nil {
+		return l
+	}
+	return log.Default
+}
+
+// Dropped 因队列已满丢弃的事件数
+func (b *EventBus) Dropped() int64 {
+	return b.dropped.Load()
+}
+
+func (b *EventBus) dispatch() {
+	for e := range b.queue {
+		b.mutex.RLock()
+		listeners := make([]func(Event), 0, len(b.listeners))
+		for _, f := range b.listeners {
+			listeners = append(listeners, f)
+		}
+		b.mutex.RUnlock()
+
+		for _, f := range listeners {
+			b.call(f, e)
+		}
+	}
+}
+
+func (b *EventBus) call(f func(Event), e Event) {
+	defer func() {
+		if p := recover(); p != nil {
+			b.getLogger().Event(context.Background(), log.EventPanic, fmt.Sprintf("event listener panic: %v", p),
+				log.F("event_type", string(e.Type)), log.F("group", e.Group))
+		}
+	}()
+	f(e)
+}
@@ -0,0 +1,92 @@
+package websocket
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/assembly-hub/websocket/log"
+)
+
+func waitEvent(t *testing.T, ch <-chan Event) Event {
+	t.Helper()
+	select {
+	case e := <-ch:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("event not delivered")
+		return Event{}
+	}
+}
+
+func TestEventBusOrder(t *testing.T) {
+	b := NewEventBus(0)
+	got := make(chan Event, 8)
+	b.Subscribe(func(e Event) { got <- e })
+
+	groups := []string{"a", "b", "c"}
+	for _, g := range groups {
+		b.Emit(Event{Type: EventGroupCreated, Group: g})
+	}
+	for _, g := range groups {
+		if e := waitEvent(t, got); e.Group != g || e.Time.IsZero() {
+			t.Fatalf("got %+v, want group %s", e, g)
+		}
+	}
+}
+
+func TestEventBusUnsubscribe(t *testing.T) {
+	b := NewEventBus(0)
+	first, second := make(chan Event, 8), make(chan Event, 8)
+	cancel := b.Subscribe(func(e Event) { first <- e })
+	b.Subscribe(func(e Event) { second <- e })
+
+	b.Emit(Event{Type: EventClientJoined})
+	waitEvent(t, first)
+	waitEvent(t, second)
+
+	cancel()
+	b.Emit(Event{Type: EventClientLeft})
+	if e := waitEvent(t, second); e.Type != EventClientLeft {
+		t.Fatalf("got %+v", e)
+	}
+	select {
+	case e := <-first:
+		t.Fatalf("unsubscribed listener got %+v", e)
+	default:
+	}
+}
+
+// panicSink 记录日志消息
+type panicSink chan string
+
+func (s panicSink) Log(ctx context.Context, level log.Level, msg string, fields []log.Field) {
+	s <- msg
+}
+
+func TestEventBusPanic(t *testing.T) {
+	b := NewEventBus(0)
+	sink := make(panicSink, 8)
+	b.SetLogger(log.New(sink))
+	got := make(chan Event, 8)
+	b.Subscribe(func(e Event) { panic("boom") })
+	b.Subscribe(func(e Event) { got <- e })
+
+	// 一个监听者 panic 不影响其他监听者及后续事件
+	b.Emit(Event{Type: EventGroupCreated, Group: "a"})
+	b.Emit(Event{Type: EventGroupCreated, Group: "b"})
+	for _, g := range []string{"a", "b"} {
+		if e := waitEvent(t, got); e.Group != g {
+			t.Fatalf("got %+v, want group %s", e, g)
+		}
+	}
+	select {
+	case msg := <-sink:
+		if !strings.Contains(msg, "boom") {
+			t.Fatalf("logged %q", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("panic not logged to the bus logger")
+	}
+}
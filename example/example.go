@@ -2,6 +2,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"time"
@@ -112,6 +113,29 @@ func SingleWSTest() {
 	})
 }
 
+// HandlerGroupTest Demo
+func HandlerGroupTest() {
+	g := simplesub.NewManager()
+	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		err := g.AddGroupWithHandler("test", w, r, websocket.HandlerFuncs{
+			Message: func(ctx context.Context, c *websocket.Client, mt websocket.MessageType, msg []byte) error {
+				if string(msg) == "ping" {
+					// 只回复当前连接
+					c.SendMsg("pong")
+					return nil
+				}
+				return c.Broadcast(msg)
+			},
+			Close: func(ctx context.Context, c *websocket.Client, reason websocket.CloseReason) {
+				fmt.Println(reason.Code, reason)
+			},
+		})
+		if err != nil {
+			fmt.Println(err)
+		}
+	})
+}
+
 func RunExample() {
 	opts := redis.Options{}
 	opts.Addr = "127.0.0.1:6379"
@@ -128,6 +152,8 @@ func RunExample() {
 	// RedisGroupTest()
 	// 简单的ws链接
 	// SingleWSTest()
+	// 自定义事件处理
+	// HandlerGroupTest()
 
 	err := http.ListenAndServe(":8000", nil)
 	if err != nil {
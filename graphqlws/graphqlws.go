@@ -0,0 +1,393 @@
+// Package graphqlws graphql-transport-ws 子协议（Apollo、graphql-ws 客户端使用），
+// 每个 subscribe 通过管理器的 Subscribe 订阅一个组，组内消息作为 next 发给客户端
+//
+// 协议见 https://github.com/enisdenjo/graphql-ws/blob/master/PROTOCOL.md
+package graphqlws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/assembly-hub/websocket"
+)
+
+// Subprotocol Sec-WebSocket-Protocol 中的子协议名
+const Subprotocol = "graphql-transport-ws"
+
+// 消息类型
+const (
+	TypeConnectionInit = "connection_init"
+	TypeConnectionAck  = "connection_ack"
+	TypePing           = "ping"
+	TypePong           = "pong"
+	TypeSubscribe      = "subscribe"
+	TypeNext           = "next"
+	TypeError          = "error"
+	TypeComplete       = "complete"
+)
+
+// 协议定义的关闭码
+const (
+	CloseInternalError     = 4500
+	CloseBadRequest        = 4400
+	CloseUnauthorized      = 4401
+	CloseForbidden         = 4403
+	CloseInitTimeout       = 4408
+	CloseSubscriberExists  = 4409
+	CloseTooManyInitialise = 4429
+)
+
+// Message 协议消息
+type Message struct {
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// Operation subscribe 消息的 payload
+type Operation struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+	Extensions    map[string]interface{} `json:"extensions,omitempty"`
+}
+
+// Error GraphQL 错误，error 消息的 payload 为 []Error
+type Error struct {
+	Message    string                 `json:"message"`
+	Path       []interface{}          `json:"path,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+// Subscription Resolver 的解析结果
+type Subscription struct {
+	// Group 订阅的组，组内每条消息发送一次 next
+	Group string
+	// Map 将组内消息转换为 next 的 payload（ExecutionResult），
+	// 为 nil 时组内消息须为 ExecutionResult 的 JSON，原样发送
+	Map func(msg []byte) (interface{}, error)
+	// Result 不为 nil 时为 query、mutation 等单次结果，发送一次 next 后 complete，不订阅组
+	Result interface{}
+}
+
+// Resolver 解析 subscribe 请求，返回 error 时回复 error 消息，返回 []Error 时原样作为 payload
+type Resolver func(ctx context.Context, c *websocket.Client, op *Operation) (*Subscription, error)
+
+// Errors 多个 GraphQL 错误，Resolver 返回时原样作为 error 消息的 payload
+type Errors []Error
+
+func (e Errors) Error() string {
+	if len(e) == 0 {
+		return "graphql error"
+	}
+	return e[0].Message
+}
+
+// Server graphql-transport-ws 的 Handler，连接建立、关闭、出错交给嵌入的 Handler
+type Server struct {
+	websocket.Handler
+
+	// Subscriber 订阅组的管理器
+	Subscriber websocket.Subscriber
+	// Resolver 解析 subscribe 请求
+	Resolver Resolver
+	// OnInit 校验 connection_init 的 payload，返回 error 时以 4403 关闭连接，
+	// 返回值作为 connection_ack 的 payload，为 nil 时不校验
+	OnInit func(ctx context.Context, c *websocket.Client, payload json.RawMessage) (interface{}, error)
+	// InitTimeout 等待 connection_init 的时间，超时以 4408 关闭连接
+	InitTimeout time.Duration
+	// ReadLimit 接收消息的最大长度，超过时关闭连接，0 使用连接的默认值（512 字节）
+	ReadLimit int64
+
+	mutex sync.Mutex
+	conns map[*websocket.Client]*conn
+}
+
+type conn struct {
+	mutex    sync.Mutex
+	inited   bool
+	acked    bool
+	timer    *time.Timer
+	subs     map[string]*websocket.Client
+	finished bool
+}
+
+// NewServer 创建 graphql-transport-ws 服务，s 一般为 simplesub、singlesub、multisub 的 Manage
+func NewServer(s websocket.Subscriber, resolver Resolver) *Server {
+	return &Server{
+		Handler:     websocket.NopHandler{},
+		Subscriber:  s,
+		Resolver:    resolver,
+		InitTimeout: 3 * time.Second,
+		ReadLimit:   64 << 10,
+		conns:       map[*websocket.Client]*conn{},
+	}
+}
+
+func (s *Server) getConn(c *websocket.Client) *conn {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.conns[c]
+}
+
+func (s *Server) OnConnect(ctx context.Context, c *websocket.Client) error {
+	c.SetWriteBatch(false)
+	if s.ReadLimit > 0 {
+		c.SetReadLimit(s.ReadLimit)
+	}
+	cn := &conn{subs: map[string]*websocket.Client{}}
+	if s.InitTimeout > 0 {
+		cn.timer = time.AfterFunc(s.InitTimeout, func() {
+			cn.mutex.Lock()
+			acked := cn.acked
+			cn.mutex.Unlock()
+			if !acked {
+				_ = c.CloseWith(CloseInitTimeout, "Connection initialisation timeout")
+			}
+		})
+	}
+	s.mutex.Lock()
+	s.conns[c] = cn
+	s.mutex.Unlock()
+	return s.Handler.OnConnect(ctx, c)
+}
+
+func (s *Server) OnClose(ctx context.Context, c *websocket.Client, reason websocket.CloseReason) {
+	s.mutex.Lock()
+	cn := s.conns[c]
+	delete(s.conns, c)
+	s.mutex.Unlock()
+
+	if cn != nil {
+		cn.mutex.Lock()
+		cn.finished = true
+		subs := cn.subs
+		cn.subs = map[string]*websocket.Client{}
+		cn.mutex.Unlock()
+		if cn.timer != nil {
+			cn.timer.Stop()
+		}
+		for _, v := range subs {
+			if v != nil {
+				v.Close()
+			}
+		}
+	}
+	s.Handler.OnClose(ctx, c, reason)
+}
+
+func (s *Server) OnMessage(ctx context.Context, c *websocket.Client, mt websocket.MessageType, data []byte) error {
+	cn := s.getConn(c)
+	if cn == nil {
+		return nil
+	}
+
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		closeWith(c, CloseBadRequest, "Invalid message received")
+		return nil
+	}
+
+	switch msg.Type {
+	case TypeConnectionInit:
+		return s.init(ctx, c, cn, msg.Payload)
+	case TypePing:
+		send(c, &Message{Type: TypePong, Payload: msg.Payload})
+	case TypePong:
+	case TypeSubscribe:
+		return s.subscribe(ctx, c, cn, &msg)
+	case TypeComplete:
+		s.stop(cn, msg.ID)
+	default:
+		closeWith(c, CloseBadRequest, fmt.Sprintf("Invalid message type %q", msg.Type))
+	}
+	return nil
+}
+
+func (s *Server) init(ctx context.Context, c *websocket.Client, cn *conn, payload json.RawMessage) error {
+	cn.mutex.Lock()
+	if cn.inited {
+		cn.mutex.Unlock()
+		closeWith(c, CloseTooManyInitialise, "Too many initialisation requests")
+		return nil
+	}
+	cn.inited = true
+	cn.mutex.Unlock()
+
+	var ack interface{}
+	if s.OnInit != nil {
+		var err error
+		if ack, err = s.OnInit(ctx, c, payload); err != nil {
+			closeWith(c, CloseForbidden, "Forbidden")
+			return nil
+		}
+	}
+
+	cn.mutex.Lock()
+	cn.acked = true
+	cn.mutex.Unlock()
+	if cn.timer != nil {
+		cn.timer.Stop()
+	}
+
+	reply := &Message{Type: TypeConnectionAck}
+	if ack != nil {
+		raw, err := json.Marshal(ack)
+		if err != nil {
+			return err
+		}
+		reply.Payload = raw
+	}
+	send(c, reply)
+	return nil
+}
+
+func (s *Server) subscribe(ctx context.Context, c *websocket.Client, cn *conn, msg *Message) error {
+	var op Operation
+	if msg.ID == "" || json.Unmarshal(msg.Payload, &op) != nil {
+		closeWith(c, CloseBadRequest, "Invalid subscribe message")
+		return nil
+	}
+
+	cn.mutex.Lock()
+	if !cn.acked {
+		cn.mutex.Unlock()
+		closeWith(c, CloseUnauthorized, "Unauthorized")
+		return nil
+	}
+	if _, ok := cn.subs[msg.ID]; ok {
+		cn.mutex.Unlock()
+		closeWith(c, CloseSubscriberExists, fmt.Sprintf("Subscriber for %s already exists", msg.ID))
+		return nil
+	}
+	// 先占用 id，解析期间收到同 id 的 subscribe 视为重复
+	cn.subs[msg.ID] = nil
+	cn.mutex.Unlock()
+
+	id := msg.ID
+	sub, err := s.Resolver(ctx, c, &op)
+	if err == nil && sub == nil {
+		err = fmt.Errorf("no subscription for operation %q", op.OperationName)
+	}
+	if err != nil {
+		s.release(cn, id)
+		sendError(c, id, err)
+		return nil
+	}
+
+	if sub.Result != nil {
+		s.release(cn, id)
+		if err = sendNext(c, id, sub.Result); err != nil {
+			sendError(c, id, err)
+			return nil
+		}
+		send(c, &Message{Type: TypeComplete, ID: id})
+		return nil
+	}
+
+	deliver := func(data []byte) {
+		var payload interface{} = json.RawMessage(data)
+		var err error
+		if sub.Map != nil {
+			payload, err = sub.Map(data)
+		}
+		if err == nil {
+			err = sendNext(c, id, payload)
+		}
+		if errors.Is(err, websocket.ErrNotSent) {
+			// 发送队列已满或连接已关闭，结束订阅，尽量通知客户端
+			if s.stop(cn, id) {
+				send(c, &Message{Type: TypeComplete, ID: id})
+			}
+			return
+		}
+		// error 消息之后订阅即结束
+		if err != nil && s.stop(cn, id) {
+			sendError(c, id, err)
+		}
+	}
+	onEnd := websocket.HandlerFuncs{Close: func(ctx context.Context, v *websocket.Client, reason websocket.CloseReason) {
+		// 不是客户端 complete 时（如消费过慢被移出组），通知客户端订阅结束
+		if s.release(cn, id) {
+			send(c, &Message{Type: TypeComplete, ID: id})
+		}
+	}}
+	v, err := s.Subscriber.Subscribe(c, sub.Group, deliver, onEnd)
+	if err != nil {
+		s.release(cn, id)
+		sendError(c, id, err)
+		return nil
+	}
+
+	cn.mutex.Lock()
+	_, ok := cn.subs[id]
+	if ok && !cn.finished {
+		cn.subs[id] = v
+	}
+	cn.mutex.Unlock()
+	if !ok || cn.finished {
+		// 订阅期间客户端已 complete 或连接已关闭
+		v.Close()
+	}
+	return nil
+}
+
+// stop 移除并退订，返回 id 是否仍在订阅中
+func (s *Server) stop(cn *conn, id string) bool {
+	cn.mutex.Lock()
+	v, ok := cn.subs[id]
+	delete(cn.subs, id)
+	cn.mutex.Unlock()
+	if v != nil {
+		go v.Close()
+	}
+	return ok
+}
+
+// release 移除订阅 id，返回 id 是否仍在订阅中
+func (s *Server) release(cn *conn, id string) bool {
+	cn.mutex.Lock()
+	defer cn.mutex.Unlock()
+	_, ok := cn.subs[id]
+	delete(cn.subs, id)
+	return ok
+}
+
+// closeWith 在读循环中调用，不等待关闭握手完成
+func closeWith(c *websocket.Client, code int, text string) {
+	go c.CloseWith(code, text)
+}
+
+// send 不阻塞地发送，发送队列已满或连接已关闭时返回 false
+func send(c *websocket.Client, msg *Message) bool {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return false
+	}
+	return c.TrySend(data)
+}
+
+// sendNext 发送 next 消息，未能发送时返回 websocket.ErrNotSent
+func sendNext(c *websocket.Client, id string, payload interface{}) error {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if !send(c, &Message{Type: TypeNext, ID: id, Payload: raw}) {
+		return websocket.ErrNotSent
+	}
+	return nil
+}
+
+func sendError(c *websocket.Client, id string, err error) {
+	errs, ok := err.(Errors)
+	if !ok {
+		errs = Errors{{Message: err.Error()}}
+	}
+	raw, _ := json.Marshal(errs)
+	send(c, &Message{Type: TypeError, ID: id, Payload: raw})
+}
@@ -0,0 +1,107 @@
+package graphqlws
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	gorilla "github.com/gorilla/websocket"
+
+	"github.com/assembly-hub/websocket"
+)
+
+func TestLargeInitPayload(t *testing.T) {
+	s := NewServer(nil, nil)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&gorilla.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		c := websocket.NewClient(r.Context(), conn, 16)
+		c.SetHandler(s)
+		c.Run()
+	}))
+	defer srv.Close()
+
+	conn, _, err := gorilla.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// 超过连接默认的 512 字节
+	init := `{"type": "connection_init", "payload": {"token": "` + strings.Repeat("x", 4096) + `"}}`
+	if err := conn.WriteMessage(gorilla.TextMessage, []byte(init)); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, msg, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(msg), TypeConnectionAck) {
+		t.Fatalf("got %s", msg)
+	}
+}
+
+// captureSubscriber 记录订阅的 deliver
+type captureSubscriber struct {
+	deliver chan func([]byte)
+}
+
+func (s *captureSubscriber) Subscribe(parent *websocket.Client, group string, deliver func([]byte), h websocket.Handler) (*websocket.Client, error) {
+	s.deliver <- deliver
+	return websocket.NewVirtualClient(parent, 1, deliver), nil
+}
+
+func readMessage(t *testing.T, c *websocket.Client) Message {
+	t.Helper()
+	var msg Message
+	select {
+	case data := <-c.Send:
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing sent")
+	}
+	return msg
+}
+
+func TestDeliverAfterSendClosed(t *testing.T) {
+	sub := &captureSubscriber{deliver: make(chan func([]byte), 1)}
+	s := NewServer(sub, func(ctx context.Context, c *websocket.Client, op *Operation) (*Subscription, error) {
+		return &Subscription{Group: "g"}, nil
+	})
+	ctx := context.Background()
+	c := websocket.NewClient(ctx, nil, 4)
+	if err := s.OnConnect(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+	_ = s.OnMessage(ctx, c, websocket.TextMessage, []byte(`{"type": "connection_init"}`))
+	if msg := readMessage(t, c); msg.Type != TypeConnectionAck {
+		t.Fatalf("got %+v", msg)
+	}
+	_ = s.OnMessage(ctx, c, websocket.TextMessage, []byte(`{"type": "subscribe", "id": "1", "payload": {"query": "subscription { a }"}}`))
+	deliver := <-sub.deliver
+
+	deliver([]byte(`{"data": {"a": 1}}`))
+	if msg := readMessage(t, c); msg.Type != TypeNext || msg.ID != "1" {
+		t.Fatalf("got %+v", msg)
+	}
+
+	// 连接已离开组，发送失败时结束订阅，不 panic
+	c.CloseSend()
+	deliver([]byte(`{"data": {"a": 2}}`))
+	cn := s.getConn(c)
+	cn.mutex.Lock()
+	_, ok := cn.subs["1"]
+	cn.mutex.Unlock()
+	if ok {
+		t.Fatal("subscription not ended")
+	}
+}
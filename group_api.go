@@ -1,8 +1,29 @@
 // Package websocket
 package websocket
 
+import "context"
+
 type GroupAPI interface {
 	Register(cli *Client)
 	UnRegister(cli *Client)
 	SendMsg(msg []byte) error
 }
+
+// GroupCtxAPI 发送时可传递 context 的组，用于传播 trace 信息
+type GroupCtxAPI interface {
+	SendMsgCtx(ctx context.Context, msg []byte) error
+}
+
+// Subscriber 可以让已有连接以虚拟连接订阅组的管理器，simplesub、singlesub、multisub 的 Manage 均已实现
+type Subscriber interface {
+	// Subscribe parent 订阅组，组内消息交给 deliver，返回的虚拟连接 Close 即退订。
+	// 订阅结束（退订、parent 关闭、消费过慢被移出组）时调用 h 的 OnClose，h 可为 nil
+	Subscribe(parent *Client, groupName string, deliver func(msg []byte), h Handler) (*Client, error)
+}
+
+// StreamSubscriber 可以让其他传输（如 SSE）的连接加入组的管理器，simplesub、singlesub、multisub 的 Manage 均已实现
+type StreamSubscriber interface {
+	// SubscribeStream 以 NewStreamClient 创建的连接加入组，ctx 一般为 http 请求的 context，
+	// 组内消息交给 deliver，返回的连接 Close 即退出组，退出时调用 h 的 OnClose，h 为空时直接广播收到的消息
+	SubscribeStream(ctx context.Context, groupName string, deliver func(msg []byte), h Handler) (*Client, error)
+}
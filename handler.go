@@ -0,0 +1,134 @@
+// Package websocket
+package websocket
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/assembly-hub/websocket/log"
+)
+
+// MessageType ws 消息类型，取值与 gorilla/websocket 一致
+type MessageType int
+
+const (
+	TextMessage   MessageType = websocket.TextMessage
+	BinaryMessage MessageType = websocket.BinaryMessage
+)
+
+// Handler 连接的事件处理
+type Handler interface {
+	// OnConnect 连接开始读数据前调用，返回 error 时关闭连接
+	OnConnect(ctx context.Context, c *Client) error
+	// OnMessage 收到消息时调用，返回的 error 交给 OnError
+	OnMessage(ctx context.Context, c *Client, mt MessageType, msg []byte) error
+	// OnClose 连接关闭时调用，每个连接只调用一次
+	OnClose(ctx context.Context, c *Client, reason CloseReason)
+	// OnError 读写或消息处理出错时调用
+	OnError(ctx context.Context, c *Client, err error)
+}
+
+// NopHandler Handler 的空实现，嵌入后只需实现关心的方法
+type NopHandler struct{}
+
+func (NopHandler) OnConnect(ctx context.Context, c *Client) error {
+	return nil
+}
+
+func (NopHandler) OnMessage(ctx context.Context, c *Client, mt MessageType, msg []byte) error {
+	return nil
+}
+
+func (NopHandler) OnClose(ctx context.Context, c *Client, reason CloseReason) {}
+
+func (NopHandler) OnError(ctx context.Context, c *Client, err error) {}
+
+// HandlerFuncs 函数形式的 Handler，未设置的函数不做处理
+type HandlerFuncs struct {
+	Connect func(ctx context.Context, c *Client) error
+	Message func(ctx context.Context, c *Client, mt MessageType, msg []byte) error
+	Close   func(ctx context.Context, c *Client, reason CloseReason)
+	Error   func(ctx context.Context, c *Client, err error)
+}
+
+func (h HandlerFuncs) OnConnect(ctx context.Context, c *Client) error {
+	if h.Connect == nil {
+		return nil
+	}
+	return h.Connect(ctx, c)
+}
+
+func (h HandlerFuncs) OnMessage(ctx context.Context, c *Client, mt MessageType, msg []byte) error {
+	if h.Message == nil {
+		return nil
+	}
+	return h.Message(ctx, c, mt, msg)
+}
+
+func (h HandlerFuncs) OnClose(ctx context.Context, c *Client, reason CloseReason) {
+	if h.Close != nil {
+		h.Close(ctx, c, reason)
+	}
+}
+
+func (h HandlerFuncs) OnError(ctx context.Context, c *Client, err error) {
+	if h.Error != nil {
+		h.Error(ctx, c, err)
+	}
+}
+
+// extHandler 将 GroupExtData 的回调适配为 Handler：
+// 消息经 ReceiveMsg 处理后广播到组，连接关闭时调用 CloseCallback
+type extHandler struct {
+	ext *GroupExtData
+}
+
+// NewExtHandler 由旧的 GroupExtData 回调生成 Handler，ext 为 nil 时直接广播收到的消息
+func NewExtHandler(ext *GroupExtData) Handler {
+	h := extHandler{ext: &GroupExtData{}}
+	if ext != nil {
+		*h.ext = *ext
+	}
+	return h
+}
+
+func (h extHandler) OnConnect(ctx context.Context, c *Client) error {
+	return nil
+}
+
+func (h extHandler) OnMessage(ctx context.Context, c *Client, mt MessageType, msg []byte) error {
+	if h.ext.ReceiveMsgWithCtx != nil {
+		msg = h.ext.ReceiveMsgWithCtx(ctx, msg)
+	} else if h.ext.ReceiveMsg != nil {
+		msg = h.ext.ReceiveMsg(msg)
+	}
+	if msg == nil {
+		return nil
+	}
+	return c.BroadcastCtx(ctx, msg)
+}
+
+func (h extHandler) OnClose(ctx context.Context, c *Client, reason CloseReason) {
+	var f func()
+	if h.ext.CloseCallbackWithReason != nil {
+		f = func() { h.ext.CloseCallbackWithReason(ctx, h.ext.CloseSendData, reason) }
+	} else if h.ext.CloseCallbackWithCtx != nil {
+		f = func() { h.ext.CloseCallbackWithCtx(ctx, h.ext.CloseSendData) }
+	} else if h.ext.CloseCallback != nil {
+		f = func() { h.ext.CloseCallback(h.ext.CloseSendData) }
+	}
+	if f != nil {
+		go c.protect("CloseCallback", f)
+	}
+}
+
+func (h extHandler) OnError(ctx context.Context, c *Client, err error) {
+	var ce *websocket.CloseError
+	if errors.As(err, &ce) {
+		c.Logger().Event(ctx, log.EventReadError, "connection closed unexpectedly", log.Err(err))
+		return
+	}
+	c.Logger().Event(ctx, log.EventHandlerError, "handle connection failed", log.Err(err))
+}
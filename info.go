@@ -0,0 +1,55 @@
+// Package websocket
+package websocket
+
+import (
+	"time"
+)
+
+// ClientInfo 连接的运行状态
+type ClientInfo struct {
+	ID           string    `json:"id"`
+	UserID       string    `json:"user_id"`
+	Group        string    `json:"group"`
+	Transport    Transport `json:"transport"`
+	Subprotocol  string    `json:"subprotocol,omitempty"`
+	RemoteAddr   string    `json:"remote_addr"`
+	ConnectedAt  time.Time `json:"connected_at"`
+	LastActivity time.Time `json:"last_activity"`
+	BytesIn      int64     `json:"bytes_in"`
+	BytesOut     int64     `json:"bytes_out"`
+	// QueueLen 发送队列中待发送的消息数
+	QueueLen int `json:"queue_len"`
+	QueueCap int `json:"queue_cap"`
+}
+
+// GroupInfo 组的运行状态
+type GroupInfo struct {
+	Name    string `json:"name"`
+	Members int    `json:"members"`
+}
+
+// Info 连接当前的运行状态
+func (c *Client) Info() ClientInfo {
+	info := ClientInfo{
+		ID:          c.ID,
+		UserID:      c.UserID,
+		Group:       c.GroupName,
+		Transport:   c.Transport(),
+		Subprotocol: c.Subprotocol(),
+		RemoteAddr:  c.RemoteAddr(),
+		ConnectedAt: c.connectedAt,
+		BytesIn:     c.bytesIn.Load(),
+		BytesOut:    c.bytesOut.Load(),
+		QueueLen:    len(c.Send),
+		QueueCap:    cap(c.Send),
+	}
+	if last := c.lastActivity.Load(); last > 0 {
+		info.LastActivity = time.Unix(0, last)
+	}
+	return info
+}
+
+// touch 记录最近一次收发数据的时间
+func (c *Client) touch() {
+	c.lastActivity.Store(time.Now().UnixNano())
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/assembly-hub/log/empty"
 )
 
+// Log 全局日志，未设置 Logger 的组件通过 Default 输出到这里
 var Log = empty.NoLog
 
 func SetDefLog(log log.Log) {
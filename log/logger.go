@@ -0,0 +1,186 @@
+package log
+
+import (
+	"context"
+	"sync"
+)
+
+// Level 日志级别
+type Level int
+
+const (
+	LevelDebug Level = iota - 1
+	LevelInfo
+	LevelWarn
+	LevelError
+	// LevelOff 不输出
+	LevelOff Level = 100
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	case LevelOff:
+		return "off"
+	}
+	return "unknown"
+}
+
+// Field 结构化字段
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// F 创建字段
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Err 错误字段
+func Err(err error) Field {
+	return Field{Key: "error", Value: err}
+}
+
+// Sink 日志的实际输出
+type Sink interface {
+	Log(ctx context.Context, level Level, msg string, fields []Field)
+}
+
+// Event 日志事件类型，每种事件可单独设置级别
+type Event string
+
+const (
+	EventReadError      Event = "read_error"
+	EventWriteError     Event = "write_error"
+	EventCloseError     Event = "close_error"
+	EventHandlerError   Event = "handler_error"
+	EventPanic          Event = "panic"
+	EventPublishError   Event = "publish_error"
+	EventSubscribeError Event = "subscribe_error"
+	EventControlError   Event = "control_error"
+	EventMailboxError   Event = "mailbox_error"
+)
+
+// eventLevels 各事件的级别，由同一个 Logger 派生出的 Logger 共享
+type eventLevels struct {
+	mutex  sync.RWMutex
+	levels map[Event]Level
+}
+
+func (e *eventLevels) get(ev Event) Level {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	if l, ok := e.levels[ev]; ok {
+		return l
+	}
+	return LevelError
+}
+
+func (e *eventLevels) set(ev Event, l Level) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.levels[ev] = l
+}
+
+// Logger 结构化日志，With 派生的 Logger 附带固定字段
+type Logger struct {
+	sink   Sink
+	fields []Field
+	levels *eventLevels
+}
+
+// New 创建 Logger，事件默认级别为 error，对端异常断开等读写错误为 warn
+func New(sink Sink) *Logger {
+	if sink == nil {
+		sink = globalSink{}
+	}
+	return &Logger{
+		sink: sink,
+		levels: &eventLevels{levels: map[Event]Level{
+			EventReadError:  LevelWarn,
+			EventWriteError: LevelWarn,
+			EventCloseError: LevelDebug,
+		}},
+	}
+}
+
+// With 派生附带 fields 的 Logger
+func (l *Logger) With(fields ...Field) *Logger {
+	if len(fields) == 0 {
+		return l
+	}
+	all := make([]Field, 0, len(l.fields)+len(fields))
+	all = append(all, l.fields...)
+	all = append(all, fields...)
+	return &Logger{sink: l.sink, fields: all, levels: l.levels}
+}
+
+// SetEventLevel 设置事件的日志级别，LevelOff 关闭该事件的日志
+func (l *Logger) SetEventLevel(ev Event, level Level) {
+	l.levels.set(ev, level)
+}
+
+// EventLevel 事件的日志级别
+func (l *Logger) EventLevel(ev Event) Level {
+	return l.levels.get(ev)
+}
+
+func (l *Logger) Log(ctx context.Context, level Level, msg string, fields ...Field) {
+	if level >= LevelOff {
+		return
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	all := fields
+	if len(l.fields) > 0 {
+		all = make([]Field, 0, len(l.fields)+len(fields))
+		all = append(all, l.fields...)
+		all = append(all, fields...)
+	}
+	l.sink.Log(ctx, level, msg, all)
+}
+
+// Event 按事件类型对应的级别输出
+func (l *Logger) Event(ctx context.Context, ev Event, msg string, fields ...Field) {
+	level := l.levels.get(ev)
+	if level >= LevelOff {
+		return
+	}
+	l.Log(ctx, level, msg, append([]Field{F("event", string(ev))}, fields...)...)
+}
+
+func (l *Logger) Debug(ctx context.Context, msg string, fields ...Field) {
+	l.Log(ctx, LevelDebug, msg, fields...)
+}
+
+func (l *Logger) Info(ctx context.Context, msg string, fields ...Field) {
+	l.Log(ctx, LevelInfo, msg, fields...)
+}
+
+func (l *Logger) Warn(ctx context.Context, msg string, fields ...Field) {
+	l.Log(ctx, LevelWarn, msg, fields...)
+}
+
+func (l *Logger) Error(ctx context.Context, msg string, fields ...Field) {
+	l.Log(ctx, LevelError, msg, fields...)
+}
+
+// Default 默认的结构化日志，输出到全局的 Log
+var Default = New(globalSink{})
+
+func SetDefLogger(l *Logger) {
+	if l == nil {
+		Default = New(globalSink{})
+	} else {
+		Default = l
+	}
+}
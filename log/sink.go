@@ -0,0 +1,65 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/assembly-hub/log"
+)
+
+// logSink 输出到 github.com/assembly-hub/log，字段以 key=value 的形式追加在消息后
+type logSink struct {
+	l log.Log
+}
+
+// NewLogSink 由 github.com/assembly-hub/log 的实现创建 Sink
+func NewLogSink(l log.Log) Sink {
+	return logSink{l: l}
+}
+
+func (s logSink) Log(ctx context.Context, level Level, msg string, fields []Field) {
+	writeLog(ctx, s.l, level, msg, fields)
+}
+
+// globalSink 始终输出到当前的全局 Log，SetDefLog 后立即生效
+type globalSink struct{}
+
+func (globalSink) Log(ctx context.Context, level Level, msg string, fields []Field) {
+	writeLog(ctx, Log, level, msg, fields)
+}
+
+func writeLog(ctx context.Context, l log.Log, level Level, msg string, fields []Field) {
+	line := formatLine(msg, fields)
+	switch level {
+	case LevelDebug:
+		l.Debug(ctx, line)
+	case LevelInfo:
+		l.Info(ctx, line)
+	case LevelWarn:
+		l.Warn(ctx, line)
+	default:
+		l.Error(ctx, line)
+	}
+}
+
+func formatLine(msg string, fields []Field) string {
+	if len(fields) == 0 {
+		return msg
+	}
+
+	var b strings.Builder
+	b.WriteString(msg)
+	for _, f := range fields {
+		b.WriteByte(' ')
+		b.WriteString(f.Key)
+		b.WriteByte('=')
+		v := fmt.Sprint(f.Value)
+		if v == "" || strings.ContainsAny(v, " =\"\n") {
+			v = strconv.Quote(v)
+		}
+		b.WriteString(v)
+	}
+	return b.String()
+}
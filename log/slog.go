@@ -0,0 +1,50 @@
+//go:build go1.21
+
+package log
+
+import (
+	"context"
+	"log/slog"
+)
+
+// slogSink 输出到 log/slog
+type slogSink struct {
+	l *slog.Logger
+}
+
+// NewSlogSink 由 *slog.Logger 创建 Sink，l 为空时使用 slog.Default()
+func NewSlogSink(l *slog.Logger) Sink {
+	if l == nil {
+		l = slog.Default()
+	}
+	return slogSink{l: l}
+}
+
+func (s slogSink) Log(ctx context.Context, level Level, msg string, fields []Field) {
+	lv := slogLevel(level)
+	if !s.l.Enabled(ctx, lv) {
+		return
+	}
+
+	attrs := make([]slog.Attr, 0, len(fields))
+	for _, f := range fields {
+		if err, ok := f.Value.(error); ok {
+			attrs = append(attrs, slog.String(f.Key, err.Error()))
+			continue
+		}
+		attrs = append(attrs, slog.Any(f.Key, f.Value))
+	}
+	s.l.LogAttrs(ctx, lv, msg, attrs...)
+}
+
+func slogLevel(level Level) slog.Level {
+	switch level {
+	case LevelDebug:
+		return slog.LevelDebug
+	case LevelInfo:
+		return slog.LevelInfo
+	case LevelWarn:
+		return slog.LevelWarn
+	}
+	return slog.LevelError
+}
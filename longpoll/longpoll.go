@@ -0,0 +1,154 @@
+// Package longpoll HTTP 长轮询传输，用于 WebSocket 和 SSE 都不可用的环境。
+// 每个会话是一个 transport.Session，以 websocket.NewStreamClient 创建的连接加入组，
+// 两次轮询之间的组内消息缓存在会话中（超过 BufferSize 时丢弃最早的消息），
+// 超过 ResumeTTL 没有轮询的会话退出组。
+//
+// 协议：
+//
+//	GET  ?session=&cursor=  不带 session 时创建会话并立即返回；带 session 时确认 cursor 及之前的消息，
+//	                        等待 cursor 之后的消息，超过 PollTimeout 返回空列表
+//	POST ?session=          请求体作为一条消息交给 Handler 的 OnMessage，响应 204
+//
+// GET 的响应为 Response，会话不存在或已过期时响应 404，客户端应重新创建会话
+package longpoll
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/assembly-hub/websocket"
+	"github.com/assembly-hub/websocket/transport"
+)
+
+// HeaderSessionID 请求中携带会话 id 的 header，也可以使用 session 参数
+const HeaderSessionID = "X-Session-ID"
+
+// Response GET 的响应
+type Response struct {
+	Session string `json:"session"`
+	// Cursor 最后一条消息的序号，下次轮询时带上
+	Cursor uint64 `json:"cursor"`
+	// Messages 组内消息，须为 UTF-8 文本
+	Messages []string `json:"messages"`
+	// Lost 超出缓存被丢弃的消息数
+	Lost uint64 `json:"lost,omitempty"`
+}
+
+// Server 长轮询传输，会话的缓存、保留时间、Handler 等配置见 transport.Sessions
+type Server struct {
+	*transport.Sessions
+
+	// Group 从请求中获取组名，返回 error 时响应 400
+	Group func(r *http.Request) (string, error)
+	// PollTimeout 一次轮询等待消息的最长时间，应小于代理的超时时间
+	PollTimeout time.Duration
+}
+
+// NewServer 创建长轮询传输，sub 一般为 simplesub、singlesub、multisub 的 Manage
+func NewServer(sub websocket.StreamSubscriber, group func(r *http.Request) (string, error)) *Server {
+	return &Server{
+		Sessions:    transport.NewSessions(sub, websocket.TransportLongPoll),
+		Group:       group,
+		PollTimeout: 25 * time.Second,
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.Poll(w, r)
+	case http.MethodPost:
+		s.Publish(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func sessionID(r *http.Request) string {
+	if id := r.Header.Get(HeaderSessionID); id != "" {
+		return id
+	}
+	return r.URL.Query().Get("session")
+}
+
+// Poll 创建会话或等待会话的消息
+func (s *Server) Poll(w http.ResponseWriter, r *http.Request) {
+	id := sessionID(r)
+	if id == "" {
+		group, err := s.Group(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ss, err := s.Open(r, group)
+		if err != nil {
+			http.Error(w, err.Error(), transport.OpenError(err))
+			return
+		}
+		// 等待第一次轮询
+		s.Detach(ss, s.Attach(ss))
+		writeJSON(w, &Response{Session: ss.ID(), Messages: []string{}})
+		return
+	}
+
+	ss := s.Get(r, id)
+	if ss == nil {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return
+	}
+	cursor, _ := strconv.ParseUint(r.URL.Query().Get("cursor"), 10, 64)
+	ss.Ack(cursor)
+
+	attached := s.Attach(ss)
+	defer s.Detach(ss, attached)
+
+	timer := time.NewTimer(s.PollTimeout)
+	defer timer.Stop()
+	for {
+		msgs, lost := ss.Since(cursor)
+		if len(msgs) > 0 {
+			resp := &Response{Session: ss.ID(), Cursor: msgs[len(msgs)-1].Seq, Messages: make([]string, len(msgs)), Lost: lost}
+			for i, msg := range msgs {
+				resp.Messages[i] = string(msg.Data)
+			}
+			writeJSON(w, resp)
+			return
+		}
+
+		select {
+		case <-ss.Notify():
+		case <-ss.Done():
+			http.Error(w, "session closed", http.StatusGone)
+			return
+		case <-attached:
+			// 同一个会话的新轮询接替
+			writeJSON(w, &Response{Session: ss.ID(), Cursor: cursor, Messages: []string{}})
+			return
+		case <-timer.C:
+			writeJSON(w, &Response{Session: ss.ID(), Cursor: cursor, Messages: []string{}})
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// Publish 客户端发送消息
+func (s *Server) Publish(w http.ResponseWriter, r *http.Request) {
+	ss := s.Get(r, sessionID(r))
+	if ss == nil {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return
+	}
+	s.Receive(w, r, ss)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	h := w.Header()
+	h.Set("Content-Type", "application/json")
+	h.Set("Cache-Control", "no-cache")
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,140 @@
+// Package mailbox 离线消息：组内没有在线连接时（如个人组的用户离线）保存发往该组的消息，
+// 下次有连接加入该组时按顺序补发
+package mailbox
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Mailbox 离线消息存储，key 为组名
+type Mailbox interface {
+	// Push 保存一条消息，超出上限时丢弃最早的消息
+	Push(ctx context.Context, key string, msg []byte) error
+	// Drain 取出并删除所有未过期的消息，按保存顺序返回
+	Drain(ctx context.Context, key string) ([][]byte, error)
+	// Peek 查看所有未过期的消息，不删除
+	Peek(ctx context.Context, key string) ([][]byte, error)
+	// Len 消息数，可能包含已过期未清理的消息
+	Len(ctx context.Context, key string) (int, error)
+	// Purge 删除所有消息
+	Purge(ctx context.Context, key string) error
+}
+
+type entry struct {
+	expireAt time.Time
+	data     []byte
+}
+
+// Memory 内存实现，每个 key 最多保存 maxLen 条消息，消息保存 ttl 后过期
+type Memory struct {
+	maxLen int
+	ttl    time.Duration
+
+	mutex sync.Mutex
+	boxes map[string][]entry
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewMemory 创建内存离线消息，maxLen <= 0 时为 100，ttl <= 0 时为 24 小时
+func NewMemory(maxLen int, ttl time.Duration) *Memory {
+	if maxLen <= 0 {
+		maxLen = 100
+	}
+	if ttl <= 0 {
+		ttl = 24 * time.Hour
+	}
+	m := &Memory{
+		maxLen: maxLen,
+		ttl:    ttl,
+		boxes:  map[string][]entry{},
+		done:   make(chan struct{}),
+	}
+	go m.gc()
+	return m
+}
+
+// Close 停止定期清理过期消息，之后仍可读写，过期消息在读取时过滤。可重复调用
+func (m *Memory) Close() error {
+	m.closeOnce.Do(func() { close(m.done) })
+	return nil
+}
+
+func (m *Memory) Push(ctx context.Context, key string, msg []byte) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	box := append(m.boxes[key], entry{expireAt: time.Now().Add(m.ttl), data: msg})
+	if len(box) > m.maxLen {
+		box = append([]entry(nil), box[len(box)-m.maxLen:]...)
+	}
+	m.boxes[key] = box
+	return nil
+}
+
+func (m *Memory) Drain(ctx context.Context, key string) ([][]byte, error) {
+	m.mutex.Lock()
+	box := m.boxes[key]
+	delete(m.boxes, key)
+	m.mutex.Unlock()
+	return alive(box, time.Now()), nil
+}
+
+func (m *Memory) Peek(ctx context.Context, key string) ([][]byte, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return alive(m.boxes[key], time.Now()), nil
+}
+
+func (m *Memory) Len(ctx context.Context, key string) (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return len(m.boxes[key]), nil
+}
+
+func (m *Memory) Purge(ctx context.Context, key string) error {
+	m.mutex.Lock()
+	delete(m.boxes, key)
+	m.mutex.Unlock()
+	return nil
+}
+
+// gc 定期清理过期消息
+func (m *Memory) gc() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		var now time.Time
+		select {
+		case now = <-ticker.C:
+		case <-m.done:
+			return
+		}
+		m.mutex.Lock()
+		for k, box := range m.boxes {
+			i := 0
+			for i < len(box) && !box[i].expireAt.After(now) {
+				i++
+			}
+			if i == len(box) {
+				delete(m.boxes, k)
+			} else if i > 0 {
+				m.boxes[k] = append([]entry(nil), box[i:]...)
+			}
+		}
+		m.mutex.Unlock()
+	}
+}
+
+func alive(box []entry, now time.Time) [][]byte {
+	msgs := make([][]byte, 0, len(box))
+	for _, e := range box {
+		if e.expireAt.After(now) {
+			msgs = append(msgs, e.data)
+		}
+	}
+	return msgs
+}
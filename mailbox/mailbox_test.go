@@ -0,0 +1,29 @@
+package mailbox
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryClose(t *testing.T) {
+	m := NewMemory(2, time.Minute)
+	ctx := context.Background()
+	for _, msg := range []string{"1", "2", "3"} {
+		_ = m.Push(ctx, "g", []byte(msg))
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// 可重复调用，关闭后仍可读写
+	_ = m.Close()
+	msgs, err := m.Drain(ctx, "g")
+	if err != nil || len(msgs) != 2 || string(msgs[0]) != "2" || string(msgs[1]) != "3" {
+		t.Fatalf("got %q, %v", msgs, err)
+	}
+	select {
+	case <-m.done:
+	default:
+		t.Fatal("gc not stopped")
+	}
+}
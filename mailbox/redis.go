@@ -0,0 +1,195 @@
+package mailbox
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const defaultRedisPrefix = "ws_mailbox_"
+
+// Redis 基于 redis list 的离线消息，多个节点共享。
+// 每条消息前 8 字节记录过期时间，key 在最后一次 Push 后 ttl 过期
+type Redis struct {
+	r      *redis.Client
+	prefix string
+	maxLen int
+	ttl    time.Duration
+}
+
+// NewRedis 创建 redis 离线消息，prefix 为空时使用默认前缀，maxLen、ttl 的默认值同 NewMemory
+func NewRedis(r *redis.Client, prefix string, maxLen int, ttl time.Duration) *Redis {
+	if prefix == "" {
+		prefix = defaultRedisPrefix
+	}
+	if maxLen <= 0 {
+		maxLen = 100
+	}
+	if ttl <= 0 {
+		ttl = 24 * time.Hour
+	}
+	return &Redis{r: r, prefix: prefix, maxLen: maxLen, ttl: ttl}
+}
+
+func (m *Redis) Push(ctx context.Context, key string, msg []byte) error {
+	data := make([]byte, 8+len(msg))
+	binary.BigEndian.PutUint64(data, uint64(time.Now().Add(m.ttl).UnixNano()))
+	copy(data[8:], msg)
+
+	k := m.prefix + key
+	_, err := m.r.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.RPush(ctx, k, data)
+		p.LTrim(ctx, k, int64(-m.maxLen), -1)
+		p.Expire(ctx, k, m.ttl)
+		return nil
+	})
+	return err
+}
+
+func (m *Redis) Drain(ctx context.Context, key string) ([][]byte, error) {
+	k := m.prefix + key
+	var cmd *redis.StringSliceCmd
+	_, err := m.r.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		cmd = p.LRange(ctx, k, 0, -1)
+		p.Del(ctx, k)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return decode(cmd.Val(), time.Now()), nil
+}
+
+func (m *Redis) Peek(ctx context.Context, key string) ([][]byte, error) {
+	vals, err := m.r.LRange(ctx, m.prefix+key, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	return decode(vals, time.Now()), nil
+}
+
+func (m *Redis) Len(ctx context.Context, key string) (int, error) {
+	n, err := m.r.LLen(ctx, m.prefix+key).Result()
+	return int(n), err
+}
+
+func (m *Redis) Purge(ctx context.Context, key string) error {
+	return m.r.Del(ctx, m.prefix+key).Err()
+}
+
+func decode(vals []string, now time.Time) [][]byte {
+	msgs := make([][]byte, 0, len(vals))
+	for _, v := range vals {
+		if len(v) < 8 {
+			continue
+		}
+		expireAt := int64(binary.BigEndian.Uint64([]byte(v[:8])))
+		if expireAt <= now.UnixNano() {
+			continue
+		}
+		msgs = append(msgs, []byte(v[8:]))
+	}
+	return msgs
+}
+
+// Presence 记录各节点上有连接的组，用于 redis 管理器判断组在集群中是否在线。
+// 每个组一个 zset，成员为节点 id，分数为过期时间，节点定期续期，节点异常退出时 ttl 后自动下线
+type Presence struct {
+	r      *redis.Client
+	prefix string
+	node   string
+	ttl    time.Duration
+
+	mutex  sync.Mutex
+	groups map[string]struct{}
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewPresence 创建在线状态记录，node 为当前节点 id，需在集群内唯一，为空时随机生成
+func NewPresence(r *redis.Client, prefix, node string) *Presence {
+	if node == "" {
+		b := make([]byte, 8)
+		_, _ = rand.Read(b)
+		node = hex.EncodeToString(b)
+	}
+	p := &Presence{
+		r:      r,
+		prefix: prefix + "presence_",
+		node:   node,
+		ttl:    time.Minute,
+		groups: map[string]struct{}{},
+		done:   make(chan struct{}),
+	}
+	go p.refresh()
+	return p
+}
+
+// Close 停止定期续期，本节点的在线记录在 ttl 后过期。可重复调用
+func (p *Presence) Close() error {
+	p.closeOnce.Do(func() { close(p.done) })
+	return nil
+}
+
+// Join 当前节点上组有了连接
+func (p *Presence) Join(ctx context.Context, group string) error {
+	p.mutex.Lock()
+	p.groups[group] = struct{}{}
+	p.mutex.Unlock()
+	return p.add(ctx, group)
+}
+
+// Leave 当前节点上组已没有连接
+func (p *Presence) Leave(ctx context.Context, group string) error {
+	p.mutex.Lock()
+	delete(p.groups, group)
+	p.mutex.Unlock()
+	return p.r.ZRem(ctx, p.prefix+group, p.node).Err()
+}
+
+// Online 组在任一节点上是否有连接
+func (p *Presence) Online(ctx context.Context, group string) (bool, error) {
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	n, err := p.r.ZCount(ctx, p.prefix+group, now, "+inf").Result()
+	return n > 0, err
+}
+
+func (p *Presence) add(ctx context.Context, group string) error {
+	k := p.prefix + group
+	_, err := p.r.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, k, &redis.Z{Score: float64(time.Now().Add(p.ttl).Unix()), Member: p.node})
+		pipe.Expire(ctx, k, p.ttl)
+		return nil
+	})
+	return err
+}
+
+func (p *Presence) refresh() {
+	ticker := time.NewTicker(p.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-p.done:
+			return
+		}
+		p.mutex.Lock()
+		groups := make([]string, 0, len(p.groups))
+		for g := range p.groups {
+			groups = append(groups, g)
+		}
+		p.mutex.Unlock()
+
+		ctx := context.Background()
+		for _, g := range groups {
+			_ = p.add(ctx, g)
+		}
+	}
+}
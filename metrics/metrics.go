@@ -0,0 +1,85 @@
+// Package metrics
+package metrics
+
+import "sync/atomic"
+
+// Metrics 连接、组及消息流转的监控指标，实现需并发安全
+type Metrics interface {
+	// ConnOpened 连接建立
+	ConnOpened(group string)
+	// ConnClosed 连接关闭，reason 为关闭原因分类
+	ConnClosed(group string, reason string)
+	// GroupCreated 本节点创建组
+	GroupCreated(group string)
+	// GroupDestroyed 本节点删除组
+	GroupDestroyed(group string)
+	// GroupSize 组内连接数变化
+	GroupSize(group string, n int)
+	// MsgIn 收到客户端消息
+	MsgIn(group string, bytes int)
+	// MsgOut 向客户端写出消息
+	MsgOut(group string, bytes int)
+	// MsgDropped 消息未能投递到连接
+	MsgDropped(group string)
+	// SlowConsumer 连接因发送队列已满被断开
+	SlowConsumer(group string)
+	// PublishError 向 redis 发布消息失败
+	PublishError(group string)
+	// PubSubReconnect redis 订阅断开后重连，manager 为管理器类型（singlesub、multisub），
+	// 不使用频道名，multisub 每个组一个频道
+	PubSubReconnect(manager string)
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) ConnOpened(group string)                {}
+func (nopMetrics) ConnClosed(group string, reason string) {}
+func (nopMetrics) GroupCreated(group string)              {}
+func (nopMetrics) GroupDestroyed(group string)            {}
+func (nopMetrics) GroupSize(group string, n int)          {}
+func (nopMetrics) MsgIn(group string, bytes int)          {}
+func (nopMetrics) MsgOut(group string, bytes int)         {}
+func (nopMetrics) MsgDropped(group string)                {}
+func (nopMetrics) SlowConsumer(group string)              {}
+func (nopMetrics) PublishError(group string)              {}
+func (nopMetrics) PubSubReconnect(manager string)         {}
+
+// NoMetrics 不做任何统计
+var NoMetrics Metrics = nopMetrics{}
+
+// Default 全局指标，转发给 SetDefMetrics 设置的实现，可在运行中并发替换
+var Default Metrics = def
+
+var def = &defMetrics{}
+
+// defMetrics 以原子指针保存当前实现，组、连接的 goroutine 读取时不与 SetDefMetrics 竞争
+type defMetrics struct {
+	m atomic.Pointer[Metrics]
+}
+
+func (d *defMetrics) get() Metrics {
+	if m := d.m.Load(); m != nil {
+		return *m
+	}
+	return NoMetrics
+}
+
+func (d *defMetrics) ConnOpened(group string)                { d.get().ConnOpened(group) }
+func (d *defMetrics) ConnClosed(group string, reason string) { d.get().ConnClosed(group, reason) }
+func (d *defMetrics) GroupCreated(group string)              { d.get().GroupCreated(group) }
+func (d *defMetrics) GroupDestroyed(group string)            { d.get().GroupDestroyed(group) }
+func (d *defMetrics) GroupSize(group string, n int)          { d.get().GroupSize(group, n) }
+func (d *defMetrics) MsgIn(group string, bytes int)          { d.get().MsgIn(group, bytes) }
+func (d *defMetrics) MsgOut(group string, bytes int)         { d.get().MsgOut(group, bytes) }
+func (d *defMetrics) MsgDropped(group string)                { d.get().MsgDropped(group) }
+func (d *defMetrics) SlowConsumer(group string)              { d.get().SlowConsumer(group) }
+func (d *defMetrics) PublishError(group string)              { d.get().PublishError(group) }
+func (d *defMetrics) PubSubReconnect(manager string)         { d.get().PubSubReconnect(manager) }
+
+// SetDefMetrics 设置全局指标，m 为 nil 时不统计，可并发调用
+func SetDefMetrics(m Metrics) {
+	if m == nil {
+		m = NoMetrics
+	}
+	def.m.Store(&m)
+}
@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+type countMetrics struct {
+	Metrics
+	n *atomic.Int64
+}
+
+func (m countMetrics) MsgIn(group string, bytes int) {
+	m.n.Add(1)
+}
+
+func TestSetDefMetricsConcurrent(t *testing.T) {
+	defer SetDefMetrics(nil)
+	var n atomic.Int64
+	rec := countMetrics{Metrics: NoMetrics, n: &n}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			Default.MsgIn("g", 1)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			SetDefMetrics(rec)
+			SetDefMetrics(nil)
+		}
+	}()
+	wg.Wait()
+
+	SetDefMetrics(rec)
+	before := n.Load()
+	Default.MsgIn("g", 1)
+	if n.Load() != before+1 {
+		t.Fatal("Default does not forward to the current metrics")
+	}
+}
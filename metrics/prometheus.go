@@ -0,0 +1,149 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// promMetrics Metrics 的 prometheus 实现。
+// 组名可能很多，只有组内连接数按组打标签，组删除时清理
+type promMetrics struct {
+	connections      prometheus.Gauge
+	connClosed       *prometheus.CounterVec
+	groups           prometheus.Gauge
+	groupMembers     *prometheus.GaugeVec
+	messages         *prometheus.CounterVec
+	bytes            *prometheus.CounterVec
+	dropped          prometheus.Counter
+	slowConsumers    prometheus.Counter
+	publishErrors    prometheus.Counter
+	pubSubReconnects *prometheus.CounterVec
+}
+
+// NewPrometheus 创建 prometheus 指标并注册到 reg，reg 为空时使用 prometheus.DefaultRegisterer
+func NewPrometheus(namespace string, reg prometheus.Registerer) (Metrics, error) {
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+
+	m := &promMetrics{
+		connections: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "websocket",
+			Name:      "connections",
+			Help:      "Number of open websocket connections.",
+		}),
+		connClosed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "websocket",
+			Name:      "connections_closed_total",
+			Help:      "Number of closed websocket connections by close reason.",
+		}, []string{"reason"}),
+		groups: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "websocket",
+			Name:      "groups",
+			Help:      "Number of groups on this node.",
+		}),
+		groupMembers: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "websocket",
+			Name:      "group_members",
+			Help:      "Number of connections in each group on this node.",
+		}, []string{"group"}),
+		messages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "websocket",
+			Name:      "messages_total",
+			Help:      "Number of websocket messages by direction.",
+		}, []string{"direction"}),
+		bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "websocket",
+			Name:      "message_bytes_total",
+			Help:      "Bytes of websocket messages by direction.",
+		}, []string{"direction"}),
+		dropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "websocket",
+			Name:      "messages_dropped_total",
+			Help:      "Number of messages that could not be delivered to a connection.",
+		}),
+		slowConsumers: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "websocket",
+			Name:      "slow_consumer_disconnects_total",
+			Help:      "Number of connections closed because their send queue was full.",
+		}),
+		publishErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "websocket",
+			Name:      "redis_publish_errors_total",
+			Help:      "Number of failed redis publishes.",
+		}),
+		pubSubReconnects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "websocket",
+			Name:      "redis_pubsub_reconnects_total",
+			Help:      "Number of redis pub/sub resubscriptions.",
+		}, []string{"manager"}),
+	}
+
+	collectors := []prometheus.Collector{
+		m.connections, m.connClosed, m.groups, m.groupMembers, m.messages,
+		m.bytes, m.dropped, m.slowConsumers, m.publishErrors, m.pubSubReconnects,
+	}
+	for _, c := range collectors {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (m *promMetrics) ConnOpened(group string) {
+	m.connections.Inc()
+}
+
+func (m *promMetrics) ConnClosed(group string, reason string) {
+	m.connections.Dec()
+	m.connClosed.WithLabelValues(reason).Inc()
+}
+
+func (m *promMetrics) GroupCreated(group string) {
+	m.groups.Inc()
+}
+
+func (m *promMetrics) GroupDestroyed(group string) {
+	m.groups.Dec()
+	m.groupMembers.DeleteLabelValues(group)
+}
+
+func (m *promMetrics) GroupSize(group string, n int) {
+	m.groupMembers.WithLabelValues(group).Set(float64(n))
+}
+
+func (m *promMetrics) MsgIn(group string, bytes int) {
+	m.messages.WithLabelValues("in").Inc()
+	m.bytes.WithLabelValues("in").Add(float64(bytes))
+}
+
+func (m *promMetrics) MsgOut(group string, bytes int) {
+	m.messages.WithLabelValues("out").Inc()
+	m.bytes.WithLabelValues("out").Add(float64(bytes))
+}
+
+func (m *promMetrics) MsgDropped(group string) {
+	m.dropped.Inc()
+}
+
+func (m *promMetrics) SlowConsumer(group string) {
+	m.slowConsumers.Inc()
+}
+
+func (m *promMetrics) PublishError(group string) {
+	m.publishErrors.Inc()
+}
+
+func (m *promMetrics) PubSubReconnect(manager string) {
+	m.pubSubReconnects.WithLabelValues(manager).Inc()
+}
@@ -0,0 +1,80 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func TestPrometheusScrape(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := NewPrometheus("test", reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.ConnOpened("g")
+	m.ConnOpened("g")
+	m.ConnClosed("g", "peer")
+	m.GroupCreated("g")
+	m.GroupSize("g", 1)
+	m.GroupCreated("h")
+	m.GroupSize("h", 3)
+	m.GroupDestroyed("h")
+	m.MsgIn("g", 10)
+	m.MsgOut("g", 20)
+	m.MsgOut("g", 5)
+	m.MsgDropped("g")
+	m.SlowConsumer("g")
+	m.PublishError("g")
+	m.PubSubReconnect("multisub")
+
+	srv := httptest.NewServer(promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	defer srv.Close()
+	resp, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := string(body)
+
+	for _, line := range []string{
+		"test_websocket_connections 1",
+		`test_websocket_connections_closed_total{reason="peer"} 1`,
+		"test_websocket_groups 1",
+		`test_websocket_group_members{group="g"} 1`,
+		`test_websocket_messages_total{direction="in"} 1`,
+		`test_websocket_messages_total{direction="out"} 2`,
+		`test_websocket_message_bytes_total{direction="out"} 25`,
+		"test_websocket_messages_dropped_total 1",
+		"test_websocket_slow_consumer_disconnects_total 1",
+		"test_websocket_redis_publish_errors_total 1",
+		`test_websocket_redis_pubsub_reconnects_total{manager="multisub"} 1`,
+	} {
+		if !strings.Contains(text, line+"\n") {
+			t.Errorf("scrape missing %q", line)
+		}
+	}
+	// 删除的组不再有标签
+	if strings.Contains(text, `group="h"`) {
+		t.Error("destroyed group still exported")
+	}
+}
+
+func TestPrometheusRegisterTwice(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	if _, err := NewPrometheus("test", reg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewPrometheus("test", reg); err == nil {
+		t.Fatal("duplicate registration should fail")
+	}
+}
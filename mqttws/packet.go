@@ -0,0 +1,244 @@
+// Package mqttws MQTT 3.1.1 over WebSocket 的 broker，topic 映射为管理器的组，
+// 支持 +/# 通配符订阅、QoS 0/1（QoS 2 的发布流程也可完成，投递时降为 1）、保留消息和遗嘱消息
+//
+// 协议见 http://docs.oasis-open.org/mqtt/mqtt/v3.1.1/os/mqtt-v3.1.1-os.html
+package mqttws
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Subprotocol Sec-WebSocket-Protocol 中的子协议名
+const Subprotocol = "mqtt"
+
+// 控制报文类型
+const (
+	CONNECT     byte = 1
+	CONNACK     byte = 2
+	PUBLISH     byte = 3
+	PUBACK      byte = 4
+	PUBREC      byte = 5
+	PUBREL      byte = 6
+	PUBCOMP     byte = 7
+	SUBSCRIBE   byte = 8
+	SUBACK      byte = 9
+	UNSUBSCRIBE byte = 10
+	UNSUBACK    byte = 11
+	PINGREQ     byte = 12
+	PINGRESP    byte = 13
+	DISCONNECT  byte = 14
+)
+
+// CONNACK 返回码
+const (
+	Accepted                   byte = 0
+	RefusedProtocolVersion     byte = 1
+	RefusedIdentifierRejected  byte = 2
+	RefusedServerUnavailable   byte = 3
+	RefusedBadUsernamePassword byte = 4
+	RefusedNotAuthorized       byte = 5
+)
+
+const (
+	subackFailure      byte = 0x80
+	maxRemainingLength      = 268435455
+)
+
+var (
+	ErrMalformed = errors.New("mqtt: malformed packet")
+	ErrTooLarge  = errors.New("mqtt: packet too large")
+)
+
+type packet struct {
+	typ   byte
+	flags byte
+	body  []byte
+}
+
+// readPacket 从 buf 中读取一个完整的报文，数据不完整时返回 n == 0
+func readPacket(buf []byte) (p *packet, n int, err error) {
+	if len(buf) < 2 {
+		return nil, 0, nil
+	}
+	length, mul := 0, 1
+	i := 1
+	for {
+		if i >= len(buf) {
+			return nil, 0, nil
+		}
+		if i > 4 {
+			return nil, 0, ErrMalformed
+		}
+		b := buf[i]
+		length += int(b&0x7f) * mul
+		mul *= 128
+		i++
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	if length > maxRemainingLength {
+		return nil, 0, ErrTooLarge
+	}
+	if len(buf) < i+length {
+		return nil, 0, nil
+	}
+	return &packet{typ: buf[0] >> 4, flags: buf[0] & 0x0f, body: buf[i : i+length]}, i + length, nil
+}
+
+// encode 编码报文
+func encode(typ, flags byte, body []byte) []byte {
+	out := make([]byte, 0, len(body)+5)
+	out = append(out, typ<<4|flags)
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		out = append(out, b)
+		if n == 0 {
+			break
+		}
+	}
+	return append(out, body...)
+}
+
+// reader 按 MQTT 编码读取报文内容
+type reader struct {
+	buf []byte
+	err error
+}
+
+func (r *reader) byte() byte {
+	if r.err != nil || len(r.buf) < 1 {
+		r.err = ErrMalformed
+		return 0
+	}
+	b := r.buf[0]
+	r.buf = r.buf[1:]
+	return b
+}
+
+func (r *reader) uint16() uint16 {
+	if r.err != nil || len(r.buf) < 2 {
+		r.err = ErrMalformed
+		return 0
+	}
+	v := binary.BigEndian.Uint16(r.buf)
+	r.buf = r.buf[2:]
+	return v
+}
+
+func (r *reader) bytes() []byte {
+	n := int(r.uint16())
+	if r.err != nil || len(r.buf) < n {
+		r.err = ErrMalformed
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *reader) string() string {
+	return string(r.bytes())
+}
+
+func appendString(out []byte, s string) []byte {
+	out = binary.BigEndian.AppendUint16(out, uint16(len(s)))
+	return append(out, s...)
+}
+
+// connectPacket CONNECT 报文内容
+type connectPacket struct {
+	protocol     string
+	level        byte
+	cleanSession bool
+	keepAlive    uint16
+	clientID     string
+	will         *Message
+	username     string
+	password     []byte
+}
+
+func parseConnect(body []byte) (*connectPacket, error) {
+	r := &reader{buf: body}
+	cp := &connectPacket{}
+	cp.protocol = r.string()
+	cp.level = r.byte()
+	flags := r.byte()
+	cp.keepAlive = r.uint16()
+	cp.clientID = r.string()
+	if r.err != nil || flags&0x01 != 0 {
+		return nil, ErrMalformed
+	}
+	cp.cleanSession = flags&0x02 != 0
+	if flags&0x04 != 0 {
+		topic := r.string()
+		payload := r.bytes()
+		cp.will = &Message{
+			Topic:   topic,
+			Payload: append([]byte(nil), payload...),
+			QoS:     (flags >> 3) & 0x03,
+			Retain:  flags&0x20 != 0,
+		}
+	}
+	if flags&0x80 != 0 {
+		cp.username = r.string()
+	}
+	if flags&0x40 != 0 {
+		cp.password = append([]byte(nil), r.bytes()...)
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return cp, nil
+}
+
+// Message 发布的消息
+type Message struct {
+	Topic   string
+	Payload []byte
+	QoS     byte
+	Retain  bool
+}
+
+func parsePublish(p *packet) (msg *Message, id uint16, err error) {
+	r := &reader{buf: p.body}
+	msg = &Message{
+		Topic:  r.string(),
+		QoS:    (p.flags >> 1) & 0x03,
+		Retain: p.flags&0x01 != 0,
+	}
+	if msg.QoS > 0 {
+		id = r.uint16()
+	}
+	if r.err != nil || msg.QoS > 2 {
+		return nil, 0, ErrMalformed
+	}
+	msg.Payload = append([]byte(nil), r.buf...)
+	return msg, id, nil
+}
+
+func encodePublish(msg *Message, id uint16, dup bool) []byte {
+	var flags byte
+	if dup {
+		flags |= 0x08
+	}
+	flags |= msg.QoS << 1
+	if msg.Retain {
+		flags |= 0x01
+	}
+	body := appendString(make([]byte, 0, len(msg.Topic)+len(msg.Payload)+4), msg.Topic)
+	if msg.QoS > 0 {
+		body = binary.BigEndian.AppendUint16(body, id)
+	}
+	return encode(PUBLISH, flags, append(body, msg.Payload...))
+}
+
+func encodeID(typ, flags byte, id uint16) []byte {
+	return encode(typ, flags, binary.BigEndian.AppendUint16(nil, id))
+}
@@ -0,0 +1,102 @@
+package mqttws
+
+import (
+	"context"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// RetainStore 保留消息存储，每个 topic 只保留最后一条
+type RetainStore interface {
+	// Set 保存保留消息，payload 为空时删除
+	Set(ctx context.Context, msg *Message) error
+	// Match 获取匹配 filter 的保留消息
+	Match(ctx context.Context, filter string) ([]*Message, error)
+}
+
+// MemoryRetain 内存保留消息，只在当前节点有效
+type MemoryRetain struct {
+	mutex sync.RWMutex
+	msgs  map[string]*Message
+}
+
+// NewMemoryRetain 创建内存保留消息
+func NewMemoryRetain() *MemoryRetain {
+	return &MemoryRetain{msgs: map[string]*Message{}}
+}
+
+func (s *MemoryRetain) Set(ctx context.Context, msg *Message) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(msg.Payload) == 0 {
+		delete(s.msgs, msg.Topic)
+		return nil
+	}
+	s.msgs[msg.Topic] = msg
+	return nil
+}
+
+func (s *MemoryRetain) Match(ctx context.Context, filter string) ([]*Message, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	var msgs []*Message
+	for topic, msg := range s.msgs {
+		if Match(filter, topic) {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs, nil
+}
+
+// RedisRetain 基于 redis hash 的保留消息，多个节点共享
+type RedisRetain struct {
+	r   *redis.Client
+	key string
+}
+
+// NewRedisRetain 创建 redis 保留消息，key 为空时使用默认值
+func NewRedisRetain(r *redis.Client, key string) *RedisRetain {
+	if key == "" {
+		key = "ws_mqtt_retain"
+	}
+	return &RedisRetain{r: r, key: key}
+}
+
+func (s *RedisRetain) Set(ctx context.Context, msg *Message) error {
+	if len(msg.Payload) == 0 {
+		return s.r.HDel(ctx, s.key, msg.Topic).Err()
+	}
+	return s.r.HSet(ctx, s.key, msg.Topic, encodeMsg(msg)).Err()
+}
+
+func (s *RedisRetain) Match(ctx context.Context, filter string) ([]*Message, error) {
+	if !IsWildcard(filter) {
+		v, err := s.r.HGet(ctx, s.key, filter).Result()
+		if err == redis.Nil {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if msg := decodeMsg([]byte(v)); msg != nil {
+			return []*Message{msg}, nil
+		}
+		return nil, nil
+	}
+
+	all, err := s.r.HGetAll(ctx, s.key).Result()
+	if err != nil {
+		return nil, err
+	}
+	var msgs []*Message
+	for topic, v := range all {
+		if !Match(filter, topic) {
+			continue
+		}
+		if msg := decodeMsg([]byte(v)); msg != nil {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs, nil
+}
@@ -0,0 +1,627 @@
+package mqttws
+
+import (
+	"context"
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/assembly-hub/websocket"
+)
+
+const (
+	defaultGroupPrefix = "mqtt:"
+	// allTopics 所有消息都会发布到该组，供通配符订阅在本地匹配
+	allTopics = "#"
+	// msgMagic 组内消息的首字节
+	msgMagic = 'M'
+)
+
+// Publisher 可向组发送消息的管理器，simplesub、singlesub、multisub 的 Manage 均已实现
+type Publisher interface {
+	SendMsgCtx(ctx context.Context, groupName string, msg string) error
+}
+
+// Server MQTT 3.1.1 broker 的 Handler，连接建立、关闭、出错交给嵌入的 Handler。
+// 每个 topic 对应一个组（GroupPrefix + topic），另有一个所有消息都会发布的组供通配符订阅使用
+type Server struct {
+	websocket.Handler
+
+	// Subscriber 订阅组的管理器
+	Subscriber websocket.Subscriber
+	// Publisher 发布到组的管理器
+	Publisher Publisher
+	// Retain 保留消息存储，集群部署时使用 NewRedisRetain
+	Retain RetainStore
+	// GroupPrefix topic 对应的组名前缀
+	GroupPrefix string
+	// MaxPacketSize 单个报文的最大长度，超过时断开连接
+	MaxPacketSize int
+	// Authenticate 校验 CONNECT，返回 CONNACK 返回码，Accepted 表示通过，为 nil 时不校验
+	Authenticate func(ctx context.Context, c *websocket.Client, clientID, username string, password []byte) byte
+	// Authorize 校验发布（publish 为 true）或订阅的 topic，为 nil 时不校验
+	Authorize func(c *websocket.Client, topic string, publish bool) bool
+
+	mutex    sync.Mutex
+	sessions map[*websocket.Client]*session
+	// clientID 对应的连接，同一个 clientID 再次连接时断开旧连接
+	clients map[string]*websocket.Client
+}
+
+type session struct {
+	mutex     sync.Mutex
+	buf       []byte
+	connected bool
+	clientID  string
+	will      *Message
+	// 收到 DISCONNECT 后正常关闭，不发布遗嘱
+	graceful bool
+	lastRecv atomic.Int64
+	// filter -> 授予的 QoS
+	subs  map[string]byte
+	exact map[string]*websocket.Client
+	all   *websocket.Client
+	// 发给客户端、等待 PUBACK 的报文 id
+	nextID   uint16
+	inflight map[uint16]struct{}
+	// 客户端 QoS 2 发布、等待 PUBREL 的报文 id
+	qos2     map[uint16]struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func (ss *session) close() {
+	ss.stopOnce.Do(func() { close(ss.stop) })
+}
+
+// NewServer 创建 MQTT broker，m 一般为 simplesub、singlesub、multisub 的 Manage
+func NewServer(m interface {
+	websocket.Subscriber
+	Publisher
+}) *Server {
+	return &Server{
+		Handler:       websocket.NopHandler{},
+		Subscriber:    m,
+		Publisher:     m,
+		Retain:        NewMemoryRetain(),
+		GroupPrefix:   defaultGroupPrefix,
+		MaxPacketSize: 1 << 20,
+		sessions:      map[*websocket.Client]*session{},
+		clients:       map[string]*websocket.Client{},
+	}
+}
+
+func (s *Server) getSession(c *websocket.Client) *session {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.sessions[c]
+}
+
+func (s *Server) OnConnect(ctx context.Context, c *websocket.Client) error {
+	c.SetWriteType(websocket.BinaryMessage)
+	if s.MaxPacketSize > 0 {
+		c.SetReadLimit(int64(s.MaxPacketSize))
+	}
+	ss := &session{
+		subs:     map[string]byte{},
+		exact:    map[string]*websocket.Client{},
+		inflight: map[uint16]struct{}{},
+		qos2:     map[uint16]struct{}{},
+		stop:     make(chan struct{}),
+	}
+	ss.lastRecv.Store(time.Now().UnixNano())
+	s.mutex.Lock()
+	s.sessions[c] = ss
+	s.mutex.Unlock()
+	return s.Handler.OnConnect(ctx, c)
+}
+
+func (s *Server) OnClose(ctx context.Context, c *websocket.Client, reason websocket.CloseReason) {
+	s.mutex.Lock()
+	ss := s.sessions[c]
+	delete(s.sessions, c)
+	if ss != nil && s.clients[ss.clientID] == c {
+		delete(s.clients, ss.clientID)
+	}
+	s.mutex.Unlock()
+
+	if ss != nil {
+		ss.close()
+		ss.mutex.Lock()
+		will := ss.will
+		if ss.graceful {
+			will = nil
+		}
+		virtuals := make([]*websocket.Client, 0, len(ss.exact)+1)
+		for _, v := range ss.exact {
+			virtuals = append(virtuals, v)
+		}
+		if ss.all != nil {
+			virtuals = append(virtuals, ss.all)
+		}
+		ss.exact, ss.all = map[string]*websocket.Client{}, nil
+		ss.mutex.Unlock()
+
+		for _, v := range virtuals {
+			v.Close()
+		}
+		if will != nil {
+			if err := s.publish(context.Background(), will); err != nil {
+				s.Handler.OnError(ctx, c, err)
+			}
+		}
+	}
+	s.Handler.OnClose(ctx, c, reason)
+}
+
+func (s *Server) OnMessage(ctx context.Context, c *websocket.Client, mt websocket.MessageType, data []byte) error {
+	ss := s.getSession(c)
+	if ss == nil {
+		return nil
+	}
+	ss.lastRecv.Store(time.Now().UnixNano())
+
+	// 一个 ws 消息可能包含多个报文，也可能只包含报文的一部分
+	ss.buf = append(ss.buf, data...)
+	for {
+		p, n, err := readPacket(ss.buf)
+		if err != nil {
+			s.abort(c)
+			return err
+		}
+		if n == 0 {
+			if len(ss.buf) > s.MaxPacketSize {
+				s.abort(c)
+				return ErrTooLarge
+			}
+			return nil
+		}
+		ss.buf = ss.buf[n:]
+		if len(ss.buf) == 0 {
+			ss.buf = nil
+		}
+		if err = s.handle(ctx, c, ss, p); err != nil {
+			s.abort(c)
+			return err
+		}
+		if p.typ == DISCONNECT {
+			return nil
+		}
+	}
+}
+
+// abort 协议错误，MQTT 没有错误报文，直接断开连接
+func (s *Server) abort(c *websocket.Client) {
+	go c.CloseWith(1002, "mqtt protocol error")
+}
+
+func (s *Server) handle(ctx context.Context, c *websocket.Client, ss *session, p *packet) error {
+	if p.typ == CONNECT {
+		if ss.connected {
+			return ErrMalformed
+		}
+		return s.connect(ctx, c, ss, p)
+	}
+	if !ss.connected {
+		return ErrMalformed
+	}
+
+	switch p.typ {
+	case PUBLISH:
+		msg, id, err := parsePublish(p)
+		if err != nil {
+			return err
+		}
+		if !ValidTopic(msg.Topic) {
+			return ErrMalformed
+		}
+		// 无权限时按协议不能拒绝，只丢弃消息
+		allowed := s.Authorize == nil || s.Authorize(c, msg.Topic, true)
+		switch msg.QoS {
+		case 0:
+			if allowed {
+				return s.publish(ctx, msg)
+			}
+		case 1:
+			if allowed {
+				if err = s.publish(ctx, msg); err != nil {
+					return err
+				}
+			}
+			send(c, encodeID(PUBACK, 0, id))
+		case 2:
+			ss.mutex.Lock()
+			_, dup := ss.qos2[id]
+			ss.qos2[id] = struct{}{}
+			ss.mutex.Unlock()
+			if allowed && !dup {
+				if err = s.publish(ctx, msg); err != nil {
+					return err
+				}
+			}
+			send(c, encodeID(PUBREC, 0, id))
+		}
+	case PUBACK:
+		id, err := readID(p)
+		if err != nil {
+			return err
+		}
+		ss.mutex.Lock()
+		delete(ss.inflight, id)
+		ss.mutex.Unlock()
+	case PUBREL:
+		id, err := readID(p)
+		if err != nil || p.flags != 0x02 {
+			return ErrMalformed
+		}
+		ss.mutex.Lock()
+		delete(ss.qos2, id)
+		ss.mutex.Unlock()
+		send(c, encodeID(PUBCOMP, 0, id))
+	case PUBREC, PUBCOMP:
+		// 投递时 QoS 最高为 1，不会收到
+	case SUBSCRIBE:
+		return s.subscribe(ctx, c, ss, p)
+	case UNSUBSCRIBE:
+		return s.unsubscribe(c, ss, p)
+	case PINGREQ:
+		send(c, encode(PINGRESP, 0, nil))
+	case DISCONNECT:
+		ss.mutex.Lock()
+		ss.graceful = true
+		ss.mutex.Unlock()
+		go c.CloseWith(1000, "")
+	default:
+		return ErrMalformed
+	}
+	return nil
+}
+
+func (s *Server) connect(ctx context.Context, c *websocket.Client, ss *session, p *packet) error {
+	cp, err := parseConnect(p.body)
+	if err != nil {
+		return err
+	}
+	if cp.protocol != "MQTT" || cp.level != 4 {
+		send(c, encode(CONNACK, 0, []byte{0, RefusedProtocolVersion}))
+		return ErrMalformed
+	}
+	if cp.clientID == "" {
+		if !cp.cleanSession {
+			send(c, encode(CONNACK, 0, []byte{0, RefusedIdentifierRejected}))
+			return ErrMalformed
+		}
+		cp.clientID = c.ID
+	}
+	if cp.will != nil && (!ValidTopic(cp.will.Topic) || cp.will.QoS > 2) {
+		return ErrMalformed
+	}
+	if s.Authenticate != nil {
+		if rc := s.Authenticate(ctx, c, cp.clientID, cp.username, cp.password); rc != Accepted {
+			send(c, encode(CONNACK, 0, []byte{0, rc}))
+			return ErrMalformed
+		}
+	}
+
+	// 同一个 clientID 再次连接时断开旧连接
+	s.mutex.Lock()
+	old := s.clients[cp.clientID]
+	s.clients[cp.clientID] = c
+	s.mutex.Unlock()
+	if old != nil && old != c {
+		go old.CloseWithReason(websocket.NewCloseReason(websocket.CloseKicked, "mqtt client id taken over"))
+	}
+
+	ss.mutex.Lock()
+	ss.connected = true
+	ss.clientID = cp.clientID
+	ss.will = cp.will
+	ss.mutex.Unlock()
+
+	// 不保存会话，session present 始终为 0
+	send(c, encode(CONNACK, 0, []byte{0, Accepted}))
+
+	if cp.keepAlive > 0 {
+		go s.keepAlive(c, ss, time.Duration(cp.keepAlive)*time.Second)
+	}
+	return nil
+}
+
+// keepAlive 1.5 倍 keep alive 时间内没有收到报文时断开连接
+func (s *Server) keepAlive(c *websocket.Client, ss *session, d time.Duration) {
+	ticker := time.NewTicker(d / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			if now.Sub(time.Unix(0, ss.lastRecv.Load())) > d*3/2 {
+				c.Terminate(websocket.NewCloseReason(websocket.CloseTimeout, "mqtt keep alive timeout"))
+				return
+			}
+		case <-ss.stop:
+			return
+		}
+	}
+}
+
+func (s *Server) group(topic string) string {
+	return s.GroupPrefix + topic
+}
+
+// publish 发布到 topic 对应的组及通配符组，retain 的消息同时保存
+func (s *Server) publish(ctx context.Context, msg *Message) error {
+	if msg.Retain {
+		if err := s.Retain.Set(ctx, msg); err != nil {
+			return err
+		}
+	}
+	// 转发给已有订阅者时 retain 为 0
+	data := string(encodeMsg(&Message{Topic: msg.Topic, Payload: msg.Payload, QoS: msg.QoS}))
+	if err := s.Publisher.SendMsgCtx(ctx, s.group(msg.Topic), data); err != nil {
+		return err
+	}
+	return s.Publisher.SendMsgCtx(ctx, s.group(allTopics), data)
+}
+
+func (s *Server) subscribe(ctx context.Context, c *websocket.Client, ss *session, p *packet) error {
+	if p.flags != 0x02 {
+		return ErrMalformed
+	}
+	r := &reader{buf: p.body}
+	id := r.uint16()
+	var filters []string
+	var qos []byte
+	for r.err == nil && len(r.buf) > 0 {
+		filters = append(filters, r.string())
+		qos = append(qos, r.byte())
+	}
+	if r.err != nil || len(filters) == 0 {
+		return ErrMalformed
+	}
+
+	codes := make([]byte, len(filters))
+	for i, f := range filters {
+		if qos[i] > 2 {
+			return ErrMalformed
+		}
+		if !ValidFilter(f) || (s.Authorize != nil && !s.Authorize(c, f, false)) {
+			codes[i] = subackFailure
+			continue
+		}
+		granted := qos[i]
+		if granted > 1 {
+			granted = 1
+		}
+		if err := s.addSub(c, ss, f, granted); err != nil {
+			codes[i] = subackFailure
+			continue
+		}
+		codes[i] = granted
+	}
+	send(c, encode(SUBACK, 0, append(binary.BigEndian.AppendUint16(nil, id), codes...)))
+
+	// 发送匹配的保留消息
+	for i, f := range filters {
+		if codes[i] == subackFailure {
+			continue
+		}
+		msgs, err := s.Retain.Match(ctx, f)
+		if err != nil {
+			s.Handler.OnError(ctx, c, err)
+			continue
+		}
+		for _, msg := range msgs {
+			s.deliver(c, ss, &Message{Topic: msg.Topic, Payload: msg.Payload, QoS: minQoS(msg.QoS, codes[i]), Retain: true})
+		}
+	}
+	return nil
+}
+
+// addSub 记录订阅，需要时订阅 topic 对应的组或通配符组
+func (s *Server) addSub(c *websocket.Client, ss *session, filter string, qos byte) error {
+	wildcard := IsWildcard(filter)
+	ss.mutex.Lock()
+	need := (wildcard && ss.all == nil) || (!wildcard && ss.exact[filter] == nil)
+	ss.mutex.Unlock()
+
+	if need {
+		var v *websocket.Client
+		var err error
+		if wildcard {
+			v, err = s.Subscriber.Subscribe(c, s.group(allTopics), func(data []byte) { s.onAll(c, ss, data) }, nil)
+		} else {
+			v, err = s.Subscriber.Subscribe(c, s.group(filter), func(data []byte) { s.onExact(c, ss, data) }, nil)
+		}
+		if err != nil {
+			return err
+		}
+		ss.mutex.Lock()
+		if wildcard {
+			ss.all = v
+		} else {
+			ss.exact[filter] = v
+		}
+		ss.mutex.Unlock()
+	}
+
+	ss.mutex.Lock()
+	ss.subs[filter] = qos
+	ss.mutex.Unlock()
+	return nil
+}
+
+func (s *Server) unsubscribe(c *websocket.Client, ss *session, p *packet) error {
+	if p.flags != 0x02 {
+		return ErrMalformed
+	}
+	r := &reader{buf: p.body}
+	id := r.uint16()
+	var filters []string
+	for r.err == nil && len(r.buf) > 0 {
+		filters = append(filters, r.string())
+	}
+	if r.err != nil || len(filters) == 0 {
+		return ErrMalformed
+	}
+
+	var closing []*websocket.Client
+	ss.mutex.Lock()
+	for _, f := range filters {
+		delete(ss.subs, f)
+		if v := ss.exact[f]; v != nil {
+			closing = append(closing, v)
+			delete(ss.exact, f)
+		}
+	}
+	if ss.all != nil {
+		wildcard := false
+		for f := range ss.subs {
+			if IsWildcard(f) {
+				wildcard = true
+				break
+			}
+		}
+		if !wildcard {
+			closing = append(closing, ss.all)
+			ss.all = nil
+		}
+	}
+	ss.mutex.Unlock()
+
+	for _, v := range closing {
+		v.Close()
+	}
+	send(c, encodeID(UNSUBACK, 0, id))
+	return nil
+}
+
+// onExact topic 组的消息，有通配符订阅匹配时由 onAll 投递，避免重复
+func (s *Server) onExact(c *websocket.Client, ss *session, data []byte) {
+	msg := decodeMsg(data)
+	if msg == nil {
+		return
+	}
+	ss.mutex.Lock()
+	qos, ok := ss.subs[msg.Topic]
+	covered := false
+	if ss.all != nil {
+		for f := range ss.subs {
+			if IsWildcard(f) && Match(f, msg.Topic) {
+				covered = true
+				break
+			}
+		}
+	}
+	ss.mutex.Unlock()
+	if !ok || covered {
+		return
+	}
+	msg.QoS = minQoS(msg.QoS, qos)
+	s.deliver(c, ss, msg)
+}
+
+// onAll 通配符组的消息，有通配符订阅匹配时按所有匹配订阅中最大的 QoS 投递
+func (s *Server) onAll(c *websocket.Client, ss *session, data []byte) {
+	msg := decodeMsg(data)
+	if msg == nil {
+		return
+	}
+	ss.mutex.Lock()
+	matched, wildcard := false, false
+	var qos byte
+	for f, q := range ss.subs {
+		if !Match(f, msg.Topic) {
+			continue
+		}
+		matched = true
+		if IsWildcard(f) {
+			wildcard = true
+		}
+		if q > qos {
+			qos = q
+		}
+	}
+	ss.mutex.Unlock()
+	if !matched || !wildcard {
+		return
+	}
+	msg.QoS = minQoS(msg.QoS, qos)
+	s.deliver(c, ss, msg)
+}
+
+func (s *Server) deliver(c *websocket.Client, ss *session, msg *Message) {
+	var id uint16
+	if msg.QoS > 0 {
+		ss.mutex.Lock()
+		if len(ss.inflight) >= 0xffff {
+			// 报文 id 已用完，客户端一直不确认时降为 QoS 0
+			ss.mutex.Unlock()
+			msg.QoS = 0
+			send(c, encodePublish(msg, 0, false))
+			return
+		}
+		for {
+			ss.nextID++
+			if ss.nextID == 0 {
+				continue
+			}
+			if _, used := ss.inflight[ss.nextID]; !used {
+				break
+			}
+		}
+		id = ss.nextID
+		ss.inflight[id] = struct{}{}
+		ss.mutex.Unlock()
+	}
+	if !send(c, encodePublish(msg, id, false)) && id != 0 {
+		ss.mutex.Lock()
+		delete(ss.inflight, id)
+		ss.mutex.Unlock()
+	}
+}
+
+// send 不阻塞地发送，发送队列已满时按慢消费者断开连接，连接已关闭时返回 false
+func send(c *websocket.Client, data []byte) bool {
+	if c.TrySend(data) {
+		return true
+	}
+	c.Terminate(websocket.NewCloseReason(websocket.CloseSlowConsumer, ""))
+	return false
+}
+
+func readID(p *packet) (uint16, error) {
+	r := &reader{buf: p.body}
+	id := r.uint16()
+	return id, r.err
+}
+
+func minQoS(a, b byte) byte {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// encodeMsg 组内消息格式：'M'、QoS、topic 长度（uvarint）、topic、payload
+func encodeMsg(msg *Message) []byte {
+	out := make([]byte, 0, 2+binary.MaxVarintLen64+len(msg.Topic)+len(msg.Payload))
+	out = append(out, msgMagic, msg.QoS)
+	out = binary.AppendUvarint(out, uint64(len(msg.Topic)))
+	out = append(out, msg.Topic...)
+	return append(out, msg.Payload...)
+}
+
+func decodeMsg(data []byte) *Message {
+	if len(data) < 2 || data[0] != msgMagic {
+		return nil
+	}
+	qos := data[1]
+	n, l := binary.Uvarint(data[2:])
+	if l <= 0 || uint64(len(data)-2-l) < n {
+		return nil
+	}
+	start := 2 + l
+	topic := string(data[start : start+int(n)])
+	return &Message{Topic: topic, QoS: qos, Payload: append([]byte(nil), data[start+int(n):]...)}
+}
@@ -0,0 +1,94 @@
+package mqttws
+
+import (
+	"context"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	gorilla "github.com/gorilla/websocket"
+
+	"github.com/assembly-hub/websocket"
+	"github.com/assembly-hub/websocket/simplesub"
+)
+
+func dialBroker(t *testing.T, s *Server, m *simplesub.Manage) *gorilla.Conn {
+	t.Helper()
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = m.AddGroupWithHandler("mqtt", w, r, s)
+	}))
+	t.Cleanup(hs.Close)
+	conn, _, err := gorilla.DefaultDialer.Dial("ws"+strings.TrimPrefix(hs.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func readType(t *testing.T, conn *gorilla.Conn, typ byte) *packet {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, n, err := readPacket(data)
+	if err != nil || n == 0 || p.typ != typ {
+		t.Fatalf("got %v (%v), want type %d", p, err, typ)
+	}
+	return p
+}
+
+func write(t *testing.T, conn *gorilla.Conn, data []byte) {
+	t.Helper()
+	if err := conn.WriteMessage(gorilla.BinaryMessage, data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLargePublish(t *testing.T) {
+	m := simplesub.NewManager()
+	conn := dialBroker(t, NewServer(m), m)
+
+	body := appendString(nil, "MQTT")
+	body = append(body, 4, 0x02, 0, 0)
+	write(t, conn, encode(CONNECT, 0, appendString(body, "c1")))
+	if p := readType(t, conn, CONNACK); p.body[1] != Accepted {
+		t.Fatalf("connack %v", p.body)
+	}
+
+	sub := binary.BigEndian.AppendUint16(nil, 1)
+	write(t, conn, encode(SUBSCRIBE, 0x02, append(appendString(sub, "a"), 0)))
+	readType(t, conn, SUBACK)
+
+	// 超过连接默认的 512 字节
+	payload := []byte(strings.Repeat("x", 4096))
+	write(t, conn, encodePublish(&Message{Topic: "a", Payload: payload}, 0, false))
+	msg, _, err := parsePublish(readType(t, conn, PUBLISH))
+	if err != nil || msg.Topic != "a" || len(msg.Payload) != len(payload) {
+		t.Fatalf("got %+v, %v", msg, err)
+	}
+}
+
+func TestDeliverAfterSendClosed(t *testing.T) {
+	s := NewServer(simplesub.NewManager())
+	c := websocket.NewClient(context.Background(), nil, 1)
+	ss := &session{inflight: map[uint16]struct{}{}}
+
+	s.deliver(c, ss, &Message{Topic: "a", QoS: 1, Payload: []byte("1")})
+	if len(ss.inflight) != 1 {
+		t.Fatalf("inflight %v", ss.inflight)
+	}
+	// 发送队列已满，不阻塞，报文 id 不再等待 PUBACK
+	s.deliver(c, ss, &Message{Topic: "a", QoS: 1, Payload: []byte("2")})
+	if len(ss.inflight) != 1 {
+		t.Fatalf("inflight %v", ss.inflight)
+	}
+	// 连接已关闭
+	c.CloseSend()
+	s.deliver(c, ss, &Message{Topic: "a", QoS: 0, Payload: []byte("3")})
+}
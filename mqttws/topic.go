@@ -0,0 +1,53 @@
+package mqttws
+
+import (
+	"strings"
+)
+
+// ValidTopic 发布的 topic 不能为空、不能包含通配符
+func ValidTopic(topic string) bool {
+	return topic != "" && !strings.ContainsAny(topic, "+#\x00")
+}
+
+// ValidFilter 订阅的 topic filter：+ 必须占满一级，# 只能是最后一级
+func ValidFilter(filter string) bool {
+	if filter == "" || strings.ContainsRune(filter, 0) {
+		return false
+	}
+	levels := strings.Split(filter, "/")
+	for i, l := range levels {
+		if strings.Contains(l, "#") && (l != "#" || i != len(levels)-1) {
+			return false
+		}
+		if strings.Contains(l, "+") && l != "+" {
+			return false
+		}
+	}
+	return true
+}
+
+// IsWildcard filter 是否包含通配符
+func IsWildcard(filter string) bool {
+	return strings.ContainsAny(filter, "+#")
+}
+
+// Match topic 是否匹配 filter，以 $ 开头的 topic 不匹配以通配符开头的 filter
+func Match(filter, topic string) bool {
+	if strings.HasPrefix(topic, "$") && (strings.HasPrefix(filter, "+") || strings.HasPrefix(filter, "#")) {
+		return false
+	}
+	fl := strings.Split(filter, "/")
+	tl := strings.Split(topic, "/")
+	for i, f := range fl {
+		if f == "#" {
+			return true
+		}
+		if i >= len(tl) {
+			return false
+		}
+		if f != "+" && f != tl[i] {
+			return false
+		}
+	}
+	return len(fl) == len(tl)
+}
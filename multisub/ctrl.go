@@ -0,0 +1,151 @@
+package multisub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	inner "github.com/assembly-hub/websocket"
+	"github.com/assembly-hub/websocket/log"
+)
+
+const (
+	ctrlOpKick       = "kick"
+	ctrlOpCloseGroup = "close_group"
+)
+
+// ctrlMsg 通过 redis 在集群内广播的控制消息
+type ctrlMsg struct {
+	Op     string `json:"op"`
+	ID     string `json:"id"`
+	Group  string `json:"group,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+func (m *Manage) publishCtrl(msg *ctrlMsg) error {
+	if m.r == nil {
+		return fmt.Errorf("redis is nil")
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	r, ctx := m.r, m.r.Context()
+	return r.Publish(ctx, m.ctrlChannel, data).Err()
+}
+
+// ctrlSub 订阅集群控制消息
+func (m *Manage) ctrlSub() error {
+	r, ctx := m.r, m.r.Context()
+	pubSub := r.Subscribe(ctx, m.ctrlChannel)
+	_, err := pubSub.Receive(ctx)
+	if err != nil {
+		return err
+	}
+
+	ch := pubSub.Channel()
+	for msg := range ch {
+		m.handleCtrl(msg.Payload)
+	}
+	return nil
+}
+
+func (m *Manage) handleCtrl(payload string) {
+	msg := &ctrlMsg{}
+	err := json.Unmarshal([]byte(payload), msg)
+	if err != nil {
+		m.getLogger().Event(context.Background(), log.EventControlError, "decode control message failed", log.Err(err))
+		return
+	}
+
+	switch msg.Op {
+	case ctrlOpKick:
+		m.kick(msg.ID, msg.Group, msg.Reason)
+	case ctrlOpCloseGroup:
+		m.closeGroup(msg.Group, msg.Reason)
+	}
+}
+
+func (m *Manage) kick(id, groupName, reason string) {
+	r := inner.NewCloseReason(inner.CloseKicked, reason)
+	if groupName != "" {
+		if g := m.groups()[groupName]; g != nil {
+			g.kick(id, r)
+		}
+		return
+	}
+	for _, g := range m.groups() {
+		g.kick(id, r)
+	}
+}
+
+// Kick 在整个集群内踢出连接 id 或用户 id 等于 id 的连接，reason 作为关闭说明发给对端
+func (m *Manage) Kick(id string, reason string) error {
+	if id == "" {
+		return fmt.Errorf("kick id is empty")
+	}
+
+	return m.publishCtrl(&ctrlMsg{Op: ctrlOpKick, ID: id, Reason: reason})
+}
+
+// CloseGroup 在整个集群内关闭组内所有连接
+func (m *Manage) CloseGroup(groupName, reason string) error {
+	if groupName == "" {
+		return fmt.Errorf("group name is empty")
+	}
+
+	return m.publishCtrl(&ctrlMsg{Op: ctrlOpCloseGroup, Group: groupName, Reason: reason})
+}
+
+func (m *Manage) banKey(userID, groupName string) string {
+	return m.pubSubKeyPrefix + "ban:" + groupName + ":" + userID
+}
+
+// Ban 在 d 时间内禁止用户加入组，groupName 为空时禁止加入所有组，集群内已在组内的连接会被踢出。
+// d 必须大于 0，不支持永久禁入，需要长期禁入时传入足够长的时间
+func (m *Manage) Ban(userID, groupName string, d time.Duration) error {
+	if userID == "" {
+		return fmt.Errorf("user id is empty")
+	}
+	if d <= 0 {
+		return fmt.Errorf("ban duration must be positive")
+	}
+	if m.r == nil {
+		return fmt.Errorf("redis is nil")
+	}
+
+	r, ctx := m.r, m.r.Context()
+	err := r.Set(ctx, m.banKey(userID, groupName), 1, d).Err()
+	if err != nil {
+		return err
+	}
+	return m.publishCtrl(&ctrlMsg{Op: ctrlOpKick, ID: userID, Group: groupName, Reason: "banned"})
+}
+
+// Unban 解除 Ban
+func (m *Manage) Unban(userID, groupName string) error {
+	if userID == "" {
+		return fmt.Errorf("user id is empty")
+	}
+	if m.r == nil {
+		return fmt.Errorf("redis is nil")
+	}
+
+	r, ctx := m.r, m.r.Context()
+	return r.Del(ctx, m.banKey(userID, groupName)).Err()
+}
+
+func (m *Manage) isBanned(userID, groupName string) (bool, error) {
+	if userID == "" || m.r == nil {
+		return false, nil
+	}
+
+	r, ctx := m.r, m.r.Context()
+	n, err := r.Exists(ctx, m.banKey(userID, groupName), m.banKey(userID, "")).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
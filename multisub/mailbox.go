@@ -0,0 +1,106 @@
+package multisub
+
+import (
+	"context"
+	"io"
+
+	inner "github.com/assembly-hub/websocket"
+	"github.com/assembly-hub/websocket/log"
+	"github.com/assembly-hub/websocket/mailbox"
+)
+
+// SetMailbox 设置离线消息，match 为 true 的组（如以用户 id 为组名的个人组）在集群内没有连接时，
+// SendMsg 的消息保存到 mb，下次有连接加入该组时补发。match 为 nil 时所有组都保存，
+// 一般使用 mailbox.NewRedis。需在加入组之前设置
+func (m *Manage) SetMailbox(mb mailbox.Mailbox, match func(groupName string) bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.mailbox = mb
+	m.mailboxMatch = match
+	if mb != nil && m.presence == nil {
+		m.presence = mailbox.NewPresence(m.r, m.pubSubKeyPrefix, "")
+		for groupName := range m.groupMap {
+			_ = m.presence.Join(context.Background(), groupName)
+		}
+	}
+}
+
+// Mailbox 当前的离线消息，可用于查看、清空某个组的离线消息
+func (m *Manage) Mailbox() mailbox.Mailbox {
+	return m.mailbox
+}
+
+// keep 组在集群内不在线时保存消息，返回是否已保存
+func (m *Manage) keep(ctx context.Context, groupName string, msg string) bool {
+	mb := m.mailbox
+	if mb == nil || m.presence == nil || (m.mailboxMatch != nil && !m.mailboxMatch(groupName)) {
+		return false
+	}
+	online, err := m.presence.Online(ctx, groupName)
+	if err != nil {
+		m.getLogger().Event(ctx, log.EventMailboxError, "check group presence failed", log.F("group", groupName), log.Err(err))
+		return false
+	}
+	if online {
+		return false
+	}
+	if err = mb.Push(ctx, groupName, []byte(msg)); err != nil {
+		m.getLogger().Event(ctx, log.EventMailboxError, "save offline message failed", log.F("group", groupName), log.Err(err))
+		return false
+	}
+	return true
+}
+
+func (m *Manage) joinPresence(groupName string) {
+	if m.presence == nil {
+		return
+	}
+	if err := m.presence.Join(context.Background(), groupName); err != nil {
+		m.getLogger().Event(context.Background(), log.EventMailboxError, "join group presence failed", log.F("group", groupName), log.Err(err))
+	}
+}
+
+func (m *Manage) leavePresence(groupName string) {
+	if m.presence == nil {
+		return
+	}
+	if err := m.presence.Leave(context.Background(), groupName); err != nil {
+		m.getLogger().Event(context.Background(), log.EventMailboxError, "leave group presence failed", log.F("group", groupName), log.Err(err))
+	}
+}
+
+// closeMailbox 服务关闭时停止在线状态的续期及离线消息的后台任务
+func (m *Manage) closeMailbox() {
+	m.mutex.Lock()
+	p, mb := m.presence, m.mailbox
+	m.mutex.Unlock()
+	if p != nil {
+		_ = p.Close()
+	}
+	if c, ok := mb.(io.Closer); ok {
+		_ = c.Close()
+	}
+}
+
+// drainMailbox 补发离线消息，需在连接加入组之前调用，保证离线消息先于组内的新消息发送。
+// 发送队列放不下的消息放回，下次有连接加入该组时补发
+func (m *Manage) drainMailbox(c *inner.Client) {
+	mb := m.mailbox
+	if mb == nil {
+		return
+	}
+	ctx := c.Context()
+	msgs, err := mb.Drain(ctx, c.GroupName)
+	if err != nil {
+		m.getLogger().Event(ctx, log.EventMailboxError, "drain offline messages failed", log.F("group", c.GroupName), log.Err(err))
+		return
+	}
+	for i, msg := range msgs {
+		if !c.TrySend(msg) {
+			for _, rest := range msgs[i:] {
+				_ = mb.Push(context.Background(), c.GroupName, rest)
+			}
+			return
+		}
+	}
+}
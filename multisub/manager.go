@@ -2,16 +2,24 @@ package multisub
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/gorilla/websocket"
 
 	inner "github.com/assembly-hub/websocket"
 	"github.com/assembly-hub/websocket/config"
+	"github.com/assembly-hub/websocket/envelope"
 	"github.com/assembly-hub/websocket/log"
+	"github.com/assembly-hub/websocket/mailbox"
+	"github.com/assembly-hub/websocket/metrics"
+	"github.com/assembly-hub/websocket/tracing"
 )
 
 const (
@@ -25,15 +33,41 @@ type Manage struct {
 	mutex           sync.Mutex
 	groupMsgMaxLen  int
 	upgrade         *websocket.Upgrader
+	// 子协议的 Handler，见 HandleProtocol
+	protocols inner.Protocols
+	// 各组的合并发送配置，见 SetConflation
+	conflations   map[string]*inner.Conflation
+	conflateMutex sync.RWMutex
+	logger        *log.Logger
+	events        *inner.EventBus
+	// 是否按分层主题处理组名，见 SetTopicWildcards
+	topics bool
+	// 集群控制消息的通道，如 Kick
+	ctrlChannel string
+	// 离线消息及判断组是否在线的记录，见 SetMailbox
+	mailbox      mailbox.Mailbox
+	mailboxMatch func(groupName string) bool
+	presence     *mailbox.Presence
 }
 
-func (m *Manage) addGroup(groupName string, conn *websocket.Conn, ext *inner.GroupExtData) {
+// groups 组的快照，groupMap 写时复制，取得后可在锁外读取、遍历
+func (m *Manage) groups() map[string]*redisGroup {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.groupMap
+}
+
+// loadGroup 获取组，不存在时创建
+func (m *Manage) loadGroup(groupName string) *redisGroup {
 	var group *redisGroup
-	if gp, ok := m.groupMap[groupName]; !ok {
+	if gp, ok := m.groups()[groupName]; !ok {
 		m.mutex.Lock()
 		defer m.mutex.Unlock()
 		if gp, ok = m.groupMap[groupName]; !ok {
 			group = newRedisGroup(m.r, groupName, m.pubSubKeyPrefix, m)
+			metrics.Default.GroupCreated(groupName)
+			m.events.Emit(inner.Event{Type: inner.EventGroupCreated, Group: groupName})
+			m.joinPresence(groupName)
 			newMap := map[string]*redisGroup{
 				groupName: group,
 			}
@@ -47,37 +81,54 @@ func (m *Manage) addGroup(groupName string, conn *websocket.Conn, ext *inner.Gro
 	} else {
 		group = gp
 	}
+	return group
+}
 
-	c := &inner.Client{
-		GroupName: groupName,
-		Group:     group,
-		Conn:      conn,
-		Send:      make(chan []byte, m.groupMsgMaxLen*3),
-	}
+func (m *Manage) addGroup(ctx context.Context, groupName string, conn *websocket.Conn, h inner.Handler) {
+	group := m.loadGroup(groupName)
 
-	if ext != nil {
-		c.SetDealMsg(ext.ReceiveMsg)
-		c.SetData(ext.CloseSendData)
-		c.SetCloseCallback(ext.CloseCallback)
-	}
+	c := inner.NewClient(ctx, conn, m.groupMsgMaxLen*3)
+	c.GroupName = groupName
+	c.Group = group
+	c.SetHandler(h)
+	c.SetLogger(m.getLogger())
 
+	m.drainMailbox(c)
 	group.Register(c)
 
 	c.Run()
 }
 
-func (m *Manage) sendMsg(groupName string, msg string) {
-	group := m.groupMap[groupName]
-	if group != nil {
-		err := group.SendMsg([]byte(msg))
-		if err != nil {
-			log.Log.Error(context.Background(), err.Error())
-		}
+func (m *Manage) sendMsg(ctx context.Context, groupName string, msg string) {
+	hierarchical := m.topics && strings.Contains(groupName, inner.TopicSep)
+	if m.keep(ctx, groupName, msg) && !hierarchical {
+		return
+	}
+	var err error
+	if group := m.groups()[groupName]; group != nil {
+		err = group.SendMsgCtx(ctx, []byte(msg))
+	} else if m.presence != nil || hierarchical {
+		// 本节点没有该组，组可能在其他节点上在线，分层主题可能有通配组订阅
+		err = m.publish(ctx, groupName, []byte(msg))
+	}
+	if err != nil {
+		m.getLogger().Event(ctx, log.EventPublishError, "send message failed", log.F("group", groupName), log.Err(err))
+	}
+}
+
+func (m *Manage) publish(ctx context.Context, groupName string, msg []byte) error {
+	ctx, span := tracing.StartPublish(ctx, groupName, len(msg))
+	data := envelope.Encode(tracing.Inject(ctx), msg)
+	err := m.r.Publish(ctx, m.pubSubKeyPrefix+groupName, data).Err()
+	if err != nil {
+		metrics.Default.PublishError(groupName)
 	}
+	tracing.End(span, err)
+	return err
 }
 
 func (m *Manage) delGroup(groupName string) {
-	if _, ok := m.groupMap[groupName]; ok {
+	if _, ok := m.groups()[groupName]; ok {
 		m.mutex.Lock()
 		defer m.mutex.Unlock()
 		if _, ok = m.groupMap[groupName]; ok {
@@ -89,25 +140,106 @@ func (m *Manage) delGroup(groupName string) {
 				newMap[k] = v
 			}
 			m.groupMap = newMap
+			metrics.Default.GroupDestroyed(groupName)
+			m.events.Emit(inner.Event{Type: inner.EventGroupDestroyed, Group: groupName})
+			m.leavePresence(groupName)
 		}
 	}
 }
 
 // AddGroupWithExt 每个组一个redis订阅
 func (m *Manage) AddGroupWithExt(groupName string, w http.ResponseWriter, r *http.Request, ext *inner.GroupExtData) error {
+	return m.AddGroupWithHandler(groupName, w, r, inner.NewExtHandler(ext))
+}
+
+// AddGroupWithHandler 加入组，连接事件交给 h 处理，h 为空时直接广播收到的消息
+func (m *Manage) AddGroupWithHandler(groupName string, w http.ResponseWriter, r *http.Request, h inner.Handler) error {
 	if groupName == "" {
 		return fmt.Errorf("group name is empty")
 	}
+	banned, err := m.isBanned(inner.UserIDFromContext(r.Context()), groupName)
+	if err != nil {
+		return err
+	}
+	if banned {
+		return inner.ErrBanned
+	}
 
-	conn, err := m.upgrade.Upgrade(w, r, nil)
+	_, span := tracing.StartUpgrade(r.Context(), groupName)
+	conn, err := m.protocols.Upgrader(m.upgrade).Upgrade(w, r, w.Header())
+	tracing.End(span, err)
 	if err != nil {
 		return err
 	}
 
-	m.addGroup(groupName, conn, ext)
+	h = m.protocols.Handler(conn.Subprotocol(), h)
+	if h == nil {
+		h = inner.NewExtHandler(nil)
+	}
+	m.addGroup(r.Context(), groupName, conn, h)
 	return nil
 }
 
+// Subscribe 已有连接 parent 以虚拟连接订阅组，组内消息交给 deliver，用于一个连接订阅多个组的协议。
+// 返回的虚拟连接 Close 即退订，parent 关闭时自动退订
+func (m *Manage) Subscribe(parent *inner.Client, groupName string, deliver func(msg []byte), h inner.Handler) (*inner.Client, error) {
+	if groupName == "" {
+		return nil, fmt.Errorf("group name is empty")
+	}
+	banned, err := m.isBanned(parent.UserID, groupName)
+	if err != nil {
+		return nil, err
+	}
+	if banned {
+		return nil, inner.ErrBanned
+	}
+
+	group := m.loadGroup(groupName)
+	v := inner.NewVirtualClient(parent, m.groupMsgMaxLen*3, deliver)
+	v.GroupName = groupName
+	v.Group = group
+	if h != nil {
+		v.SetHandler(h)
+	}
+
+	m.drainMailbox(v)
+	group.Register(v)
+
+	v.Run()
+	return v, nil
+}
+
+// SubscribeStream 其他传输（如 SSE）的连接加入组，ctx 一般为 http 请求的 context，组内消息交给 deliver。
+// 返回的连接 Close 即退出组，h 为空时直接广播收到的消息
+func (m *Manage) SubscribeStream(ctx context.Context, groupName string, deliver func(msg []byte), h inner.Handler) (*inner.Client, error) {
+	if groupName == "" {
+		return nil, fmt.Errorf("group name is empty")
+	}
+	banned, err := m.isBanned(inner.UserIDFromContext(ctx), groupName)
+	if err != nil {
+		return nil, err
+	}
+	if banned {
+		return nil, inner.ErrBanned
+	}
+
+	if h == nil {
+		h = inner.NewExtHandler(nil)
+	}
+	group := m.loadGroup(groupName)
+	c := inner.NewStreamClient(ctx, m.groupMsgMaxLen*3, deliver)
+	c.GroupName = groupName
+	c.Group = group
+	c.SetHandler(h)
+	c.SetLogger(m.getLogger())
+
+	m.drainMailbox(c)
+	group.Register(c)
+
+	c.Run()
+	return c, nil
+}
+
 func (m *Manage) AddGroup(groupName string, w http.ResponseWriter, r *http.Request) error {
 	return m.AddGroupWithExt(groupName, w, r, nil)
 }
@@ -117,18 +249,140 @@ func (m *Manage) SendMsg(groupName string, msg string) error {
 		return fmt.Errorf("group name is empty")
 	}
 
-	m.sendMsg(groupName, msg)
+	m.sendMsg(context.Background(), groupName, msg)
 	return nil
 }
 
+// SendMsgCtx 同 SendMsg，ctx 中的 trace 信息会随消息传递到其他节点
+func (m *Manage) SendMsgCtx(ctx context.Context, groupName string, msg string) error {
+	if groupName == "" {
+		return fmt.Errorf("group name is empty")
+	}
+
+	m.sendMsg(ctx, groupName, msg)
+	return nil
+}
+
+// SendJSON 将 v 编码为 JSON 后发送到组
+func (m *Manage) SendJSON(groupName string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return m.SendMsg(groupName, string(data))
+}
+
+// Emit 向组发送事件消息，格式与 websocket.Router 一致
+func (m *Manage) Emit(groupName string, event string, data interface{}) error {
+	msg, err := inner.NewEventMessage(event, data)
+	if err != nil {
+		return err
+	}
+	return m.SendMsg(groupName, string(msg))
+}
+
+// Shutdown 服务关闭时调用，以 CloseServerShutdown 关闭所有连接，并停止离线消息的后台任务
+func (m *Manage) Shutdown() {
+	reason := inner.NewCloseReason(inner.CloseServerShutdown, "")
+	for _, g := range m.groups() {
+		g.closeAll(reason)
+	}
+	m.closeMailbox()
+}
+
+// Groups 本节点的组及组内连接数
+func (m *Manage) Groups() []inner.GroupInfo {
+	groupMap := m.groups()
+	list := make([]inner.GroupInfo, 0, len(groupMap))
+	for name, g := range groupMap {
+		list = append(list, inner.GroupInfo{Name: name, Members: g.size()})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// Clients 本节点组内连接的状态，groupName 为空时返回所有组
+func (m *Manage) Clients(groupName string) []inner.ClientInfo {
+	var list []inner.ClientInfo
+	for name, g := range m.groups() {
+		if groupName != "" && name != groupName {
+			continue
+		}
+		for _, c := range g.clientList() {
+			list = append(list, c.Info())
+		}
+	}
+	return list
+}
+
+func (m *Manage) closeGroup(groupName, reason string) {
+	if g := m.groups()[groupName]; g != nil {
+		g.closeAll(inner.NewCloseReason(inner.CloseLocal, reason))
+	}
+}
+
 func (m *Manage) SetMaxMsgLength(n int) {
 	m.groupMsgMaxLen = n
 }
 
+// OnEvent 订阅组创建/删除、连接加入/离开、消息丢弃、redis 断开等事件，返回取消订阅的函数。
+// 事件异步分发，处理过慢时事件会被丢弃
+func (m *Manage) OnEvent(f func(e inner.Event)) (cancel func()) {
+	return m.events.Subscribe(f)
+}
+
+// SetLogger 设置管理器及其连接的日志，为空时使用 log.Default
+func (m *Manage) SetLogger(l *log.Logger) {
+	m.logger = l
+	m.events.SetLogger(l)
+}
+
+func (m *Manage) getLogger() *log.Logger {
+	if m.logger == nil {
+		return log.Default
+	}
+	return m.logger
+}
+
 func (m *Manage) SetUpgrade(up *websocket.Upgrader) {
 	m.upgrade = up
 }
 
+// SetTopicWildcards 开启分层主题，组名中的 * 和 > 作为通配符（见 websocket.IsTopicPattern），
+// 通配组接收所有匹配主题的消息。默认关闭，组名按原样处理，需在加入组之前设置
+func (m *Manage) SetTopicWildcards(enable bool) {
+	m.topics = enable
+}
+
+// SetConflation 设置组的合并发送，cf 为 nil 时取消。带 key 的消息每个连接每个 key 只保留最新一条，
+// 适合行情等高频更新的组，配置只对本节点生效
+func (m *Manage) SetConflation(groupName string, cf *inner.Conflation) {
+	m.conflateMutex.Lock()
+	defer m.conflateMutex.Unlock()
+	if cf == nil {
+		delete(m.conflations, groupName)
+		return
+	}
+	if m.conflations == nil {
+		m.conflations = map[string]*inner.Conflation{}
+	}
+	m.conflations[groupName] = cf
+}
+
+func (m *Manage) conflation(groupName string) *inner.Conflation {
+	m.conflateMutex.RLock()
+	defer m.conflateMutex.RUnlock()
+	return m.conflations[groupName]
+}
+
+// HandleProtocol 注册子协议的 Handler，升级时与客户端的 Sec-WebSocket-Protocol 协商，
+// 协商出该子协议的连接使用 h，优先于 AddGroupWithHandler 传入的 Handler
+func (m *Manage) HandleProtocol(subprotocol string, h inner.Handler) {
+	m.protocols.Handle(subprotocol, h)
+}
+
 func NewManager(r *redis.Client, label string) *Manage {
 	if label == "" {
 		label = defaultRedisPubSubKeyPrefix
@@ -137,10 +391,26 @@ func NewManager(r *redis.Client, label string) *Manage {
 	m := &Manage{
 		groupMap:        map[string]*redisGroup{},
 		pubSubKeyPrefix: label,
+		ctrlChannel:     "ctrl:" + label,
 		r:               r,
 		mutex:           sync.Mutex{},
 		groupMsgMaxLen:  1000,
 		upgrade:         &config.WSDefaultUpdate,
+		events:          inner.NewEventBus(0),
+	}
+	if r != nil {
+		go func() {
+			for {
+				err := m.ctrlSub()
+				if err == nil {
+					break
+				}
+				m.getLogger().Event(context.Background(), log.EventSubscribeError, "subscribe control channel failed", log.Err(err))
+				m.events.Emit(inner.Event{Type: inner.EventRedisDisconnected, Err: err})
+				metrics.Default.PubSubReconnect("multisub")
+				time.Sleep(time.Millisecond * 100)
+			}
+		}()
 	}
 	return m
 }
@@ -0,0 +1,112 @@
+// Package websocket
+package websocket
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/assembly-hub/websocket/log"
+)
+
+// PanicHook 用户回调 panic 时的上报函数，stack 为 panic 时的调用栈
+type PanicHook func(ctx context.Context, c *Client, p interface{}, stack []byte)
+
+var (
+	panicHook    PanicHook
+	closeOnPanic = true
+)
+
+// SetPanicHook 设置用户回调 panic 时的上报函数，如上报 sentry
+func SetPanicHook(f PanicHook) {
+	panicHook = f
+}
+
+// SetCloseOnPanic 用户回调 panic 后是否以 1011 关闭该连接，默认关闭
+func SetCloseOnPanic(b bool) {
+	closeOnPanic = b
+}
+
+// PanicError 用户回调 panic 时交给 OnError 的错误
+type PanicError struct {
+	// Callback 发生 panic 的回调名
+	Callback string
+	Value    interface{}
+	Stack    []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("websocket callback %s panic: %v", e.Callback, e.Value)
+}
+
+// protect 调用用户回调，捕获 panic 后记录日志、上报，并按配置关闭连接
+func (c *Client) protect(name string, f func()) (perr *PanicError) {
+	defer func() {
+		if p := recover(); p != nil {
+			perr = &PanicError{Callback: name, Value: p, Stack: debug.Stack()}
+			c.reportPanic(perr)
+		}
+	}()
+
+	f()
+	return nil
+}
+
+// Go 在新的 goroutine 中执行 f，panic 的处理与用户回调一致
+func (c *Client) Go(name string, f func()) {
+	go c.protect(name, f)
+}
+
+func (c *Client) reportPanic(e *PanicError) {
+	ctx := c.Context()
+	remote := ""
+	if c.Conn != nil {
+		remote = c.Conn.RemoteAddr().String()
+	}
+	c.Logger().Event(ctx, log.EventPanic, e.Error(), log.F("remote", remote), log.F("stack", string(e.Stack)))
+
+	if hook := panicHook; hook != nil {
+		func() {
+			defer func() {
+				if p := recover(); p != nil {
+					c.Logger().Event(ctx, log.EventPanic, "websocket panic hook panic", log.F("panic", p))
+				}
+			}()
+			hook(ctx, c, e.Value, e.Stack)
+		}()
+	}
+
+	if closeOnPanic && c.Conn != nil {
+		go c.CloseWithReason(NewCloseReason(CloseInternalError, "internal error"))
+	}
+}
+
+func (c *Client) callConnect(h Handler) (err error) {
+	if perr := c.protect("OnConnect", func() {
+		err = h.OnConnect(c.ctx, c)
+	}); perr != nil {
+		return perr
+	}
+	return err
+}
+
+func (c *Client) callMessage(ctx context.Context, h Handler, mt MessageType, msg []byte) (err error) {
+	if perr := c.protect("OnMessage", func() {
+		err = h.OnMessage(ctx, c, mt, msg)
+	}); perr != nil {
+		return perr
+	}
+	return err
+}
+
+func (c *Client) callClose(h Handler, reason CloseReason) {
+	c.protect("OnClose", func() {
+		h.OnClose(c.ctx, c, reason)
+	})
+}
+
+func (c *Client) callError(h Handler, err error) {
+	c.protect("OnError", func() {
+		h.OnError(c.ctx, c, err)
+	})
+}
@@ -0,0 +1,74 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestCallbackPanicDoesNotKillServer(t *testing.T) {
+	hooked := make(chan interface{}, 1)
+	SetPanicHook(func(ctx context.Context, c *Client, p interface{}, stack []byte) {
+		hooked <- p
+	})
+	defer SetPanicHook(nil)
+
+	reasons := make(chan CloseReason, 2)
+	errs := make(chan error, 2)
+	url := startServer(t, HandlerFuncs{
+		Message: func(ctx context.Context, c *Client, mt MessageType, msg []byte) error {
+			if string(msg) == "panic" {
+				panic("boom")
+			}
+			c.SendBytes(msg)
+			return nil
+		},
+		Error: func(ctx context.Context, c *Client, err error) {
+			errs <- err
+		},
+		Close: func(ctx context.Context, c *Client, reason CloseReason) {
+			reasons <- reason
+		},
+	})
+
+	bad := dial(t, url)
+	if err := bad.WriteMessage(websocket.TextMessage, []byte("panic")); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			if _, _, err := bad.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	select {
+	case p := <-hooked:
+		if p != "boom" {
+			t.Fatalf("hook got %v", p)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("panic hook not called")
+	}
+	var perr *PanicError
+	if err := <-errs; !errors.As(err, &perr) || perr.Callback != "OnMessage" {
+		t.Fatalf("OnError got %v", err)
+	}
+	if r := waitReason(t, reasons); r.Kind != CloseInternalError || r.Code != websocket.CloseInternalServerErr {
+		t.Fatalf("got %+v", r)
+	}
+
+	// 其他连接不受影响
+	good := dial(t, url)
+	if err := good.WriteMessage(websocket.TextMessage, []byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	_ = good.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, msg, err := good.ReadMessage(); err != nil || string(msg) != "ping" {
+		t.Fatalf("echo got %q, %v", msg, err)
+	}
+}
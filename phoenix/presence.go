@@ -0,0 +1,133 @@
+package phoenix
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Meta 一个连接的在线信息，其中 phx_ref 区分同一个 key 的多个连接
+type Meta map[string]interface{}
+
+// PresenceEntry presence_state、presence_diff 中一个 key 的信息
+type PresenceEntry struct {
+	Metas []Meta `json:"metas"`
+}
+
+// PresenceDiff presence_diff 的 payload
+type PresenceDiff struct {
+	Joins  map[string]*PresenceEntry `json:"joins"`
+	Leaves map[string]*PresenceEntry `json:"leaves"`
+}
+
+// Presence 记录 topic 的在线信息，集群部署时需要各节点共享
+type Presence interface {
+	// Track 记录 key 的一个连接，ref 在 topic 内唯一
+	Track(ctx context.Context, topic, key, ref string, meta Meta) error
+	// Untrack 删除 Track 的记录
+	Untrack(ctx context.Context, topic, ref string) error
+	// State topic 当前的在线信息
+	State(ctx context.Context, topic string) (map[string]*PresenceEntry, error)
+}
+
+type presenceItem struct {
+	Key  string `json:"key"`
+	Meta Meta   `json:"meta"`
+}
+
+func stateOf(items map[string]presenceItem) map[string]*PresenceEntry {
+	state := map[string]*PresenceEntry{}
+	for _, it := range items {
+		e := state[it.Key]
+		if e == nil {
+			e = &PresenceEntry{}
+			state[it.Key] = e
+		}
+		e.Metas = append(e.Metas, it.Meta)
+	}
+	return state
+}
+
+// MemoryPresence 内存在线信息，只在当前节点有效
+type MemoryPresence struct {
+	mutex  sync.Mutex
+	topics map[string]map[string]presenceItem
+}
+
+// NewMemoryPresence 创建内存在线信息
+func NewMemoryPresence() *MemoryPresence {
+	return &MemoryPresence{topics: map[string]map[string]presenceItem{}}
+}
+
+func (p *MemoryPresence) Track(ctx context.Context, topic, key, ref string, meta Meta) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	items := p.topics[topic]
+	if items == nil {
+		items = map[string]presenceItem{}
+		p.topics[topic] = items
+	}
+	items[ref] = presenceItem{Key: key, Meta: meta}
+	return nil
+}
+
+func (p *MemoryPresence) Untrack(ctx context.Context, topic, ref string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if items := p.topics[topic]; items != nil {
+		delete(items, ref)
+		if len(items) == 0 {
+			delete(p.topics, topic)
+		}
+	}
+	return nil
+}
+
+func (p *MemoryPresence) State(ctx context.Context, topic string) (map[string]*PresenceEntry, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return stateOf(p.topics[topic]), nil
+}
+
+// RedisPresence 基于 redis hash 的在线信息，多个节点共享，节点异常退出时记录不会自动清理
+type RedisPresence struct {
+	r      *redis.Client
+	prefix string
+}
+
+// NewRedisPresence 创建 redis 在线信息，prefix 为空时使用默认值
+func NewRedisPresence(r *redis.Client, prefix string) *RedisPresence {
+	if prefix == "" {
+		prefix = "ws_phx_presence_"
+	}
+	return &RedisPresence{r: r, prefix: prefix}
+}
+
+func (p *RedisPresence) Track(ctx context.Context, topic, key, ref string, meta Meta) error {
+	data, err := json.Marshal(&presenceItem{Key: key, Meta: meta})
+	if err != nil {
+		return err
+	}
+	return p.r.HSet(ctx, p.prefix+topic, ref, data).Err()
+}
+
+func (p *RedisPresence) Untrack(ctx context.Context, topic, ref string) error {
+	return p.r.HDel(ctx, p.prefix+topic, ref).Err()
+}
+
+func (p *RedisPresence) State(ctx context.Context, topic string) (map[string]*PresenceEntry, error) {
+	all, err := p.r.HGetAll(ctx, p.prefix+topic).Result()
+	if err != nil {
+		return nil, err
+	}
+	items := make(map[string]presenceItem, len(all))
+	for ref, v := range all {
+		var it presenceItem
+		if json.Unmarshal([]byte(v), &it) == nil {
+			items[ref] = it
+		}
+	}
+	return stateOf(items), nil
+}
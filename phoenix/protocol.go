@@ -0,0 +1,110 @@
+// Package phoenix Phoenix Channels 协议（phoenix.js 客户端），topic 映射为管理器的组，
+// 支持 join/leave/push/reply/heartbeat 及 Presence 的 presence_state、presence_diff
+//
+// 组内消息使用 websocket.Router 的事件格式 {"event": "...", "data": ...}，
+// 管理器的 Emit(group, event, data) 可直接推送给 Phoenix 客户端
+package phoenix
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+)
+
+// 协议事件
+const (
+	EventJoin      = "phx_join"
+	EventLeave     = "phx_leave"
+	EventReply     = "phx_reply"
+	EventError     = "phx_error"
+	EventClose     = "phx_close"
+	EventHeartbeat = "heartbeat"
+
+	EventPresenceState = "presence_state"
+	EventPresenceDiff  = "presence_diff"
+
+	// TopicPhoenix 心跳使用的 topic
+	TopicPhoenix = "phoenix"
+)
+
+// 回复状态
+const (
+	StatusOK    = "ok"
+	StatusError = "error"
+)
+
+var errInvalidMessage = errors.New("phoenix: invalid message")
+
+// Message 协议消息，JoinRef、Ref 为空时编码为 null
+type Message struct {
+	JoinRef string
+	Ref     string
+	Topic   string
+	Event   string
+	Payload json.RawMessage
+}
+
+// v1Message 1.0 序列化格式
+type v1Message struct {
+	JoinRef *string         `json:"join_ref"`
+	Ref     *string         `json:"ref"`
+	Topic   string          `json:"topic"`
+	Event   string          `json:"event"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// Reply phx_reply 的 payload
+type Reply struct {
+	Status   string      `json:"status"`
+	Response interface{} `json:"response"`
+}
+
+func nullable(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// decode 解析消息，v2 表示是否为 2.0 的数组格式
+func decode(data []byte) (msg *Message, v2 bool, err error) {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		var arr []json.RawMessage
+		if err = json.Unmarshal(data, &arr); err != nil || len(arr) != 5 {
+			return nil, true, errInvalidMessage
+		}
+		var joinRef, ref *string
+		msg = &Message{Payload: arr[4]}
+		if json.Unmarshal(arr[0], &joinRef) != nil || json.Unmarshal(arr[1], &ref) != nil ||
+			json.Unmarshal(arr[2], &msg.Topic) != nil || json.Unmarshal(arr[3], &msg.Event) != nil {
+			return nil, true, errInvalidMessage
+		}
+		msg.JoinRef, msg.Ref = deref(joinRef), deref(ref)
+		return msg, true, nil
+	}
+
+	var m v1Message
+	if err = json.Unmarshal(data, &m); err != nil || m.Topic == "" || m.Event == "" {
+		return nil, false, errInvalidMessage
+	}
+	return &Message{JoinRef: deref(m.JoinRef), Ref: deref(m.Ref), Topic: m.Topic, Event: m.Event, Payload: m.Payload}, false, nil
+}
+
+func encode(msg *Message, v2 bool) ([]byte, error) {
+	payload := msg.Payload
+	if len(payload) == 0 {
+		payload = json.RawMessage("{}")
+	}
+	if v2 {
+		return json.Marshal([]interface{}{nullable(msg.JoinRef), nullable(msg.Ref), msg.Topic, msg.Event, payload})
+	}
+	return json.Marshal(&v1Message{JoinRef: nullable(msg.JoinRef), Ref: nullable(msg.Ref), Topic: msg.Topic, Event: msg.Event, Payload: payload})
+}
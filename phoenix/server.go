@@ -0,0 +1,404 @@
+package phoenix
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/assembly-hub/websocket"
+)
+
+const defaultGroupPrefix = "phx:"
+
+// ErrNoReply EventFunc 返回该错误时不回复 phx_reply
+var ErrNoReply = errors.New("phoenix: no reply")
+
+// Publisher 可向组发送消息的管理器，simplesub、singlesub、multisub 的 Manage 均已实现
+type Publisher interface {
+	SendMsgCtx(ctx context.Context, groupName string, msg string) error
+}
+
+// JoinFunc 校验 phx_join，返回值作为回复的 response，返回 error 时回复 error 状态
+type JoinFunc func(ctx context.Context, c *websocket.Client, topic string, payload json.RawMessage) (interface{}, error)
+
+// EventFunc 处理客户端推送的事件，返回值作为回复的 response，返回 error 时回复 error 状态
+type EventFunc func(ctx context.Context, c *websocket.Client, topic string, payload json.RawMessage) (interface{}, error)
+
+// Server Phoenix Channels 的 Handler，连接建立、关闭、出错交给嵌入的 Handler。
+// 每个 topic 对应一个组（GroupPrefix + topic），未注册处理函数的事件广播到组内
+type Server struct {
+	websocket.Handler
+
+	// Subscriber 订阅组的管理器
+	Subscriber websocket.Subscriber
+	// Publisher 发布到组的管理器
+	Publisher Publisher
+	// Presence 在线信息存储，集群部署时使用 NewRedisPresence
+	Presence Presence
+	// GroupPrefix topic 对应的组名前缀
+	GroupPrefix string
+	// Join 校验 phx_join，为 nil 时不校验
+	Join JoinFunc
+	// Track 返回连接在 topic 中的 presence key 和 meta，key 为空时不跟踪，为 nil 时不使用 Presence
+	Track func(c *websocket.Client, topic string) (key string, meta Meta)
+	// ReadLimit 接收消息的最大长度，超过时关闭连接，0 使用连接的默认值（512 字节）
+	ReadLimit int64
+
+	handlerMutex sync.RWMutex
+	handlers     map[string]EventFunc
+
+	mutex sync.Mutex
+	conns map[*websocket.Client]*conn
+}
+
+type conn struct {
+	mutex    sync.Mutex
+	v2       bool
+	channels map[string]*channel
+	finished bool
+}
+
+type channel struct {
+	joinRef string
+	v       *websocket.Client
+	// presence 信息，ref 为空表示未跟踪
+	key  string
+	ref  string
+	meta Meta
+}
+
+// NewServer 创建 Phoenix Channels 服务，m 一般为 simplesub、singlesub、multisub 的 Manage
+func NewServer(m interface {
+	websocket.Subscriber
+	Publisher
+}) *Server {
+	return &Server{
+		Handler:     websocket.NopHandler{},
+		Subscriber:  m,
+		Publisher:   m,
+		Presence:    NewMemoryPresence(),
+		GroupPrefix: defaultGroupPrefix,
+		ReadLimit:   64 << 10,
+		handlers:    map[string]EventFunc{},
+		conns:       map[*websocket.Client]*conn{},
+	}
+}
+
+// On 注册客户端推送事件的处理函数
+func (s *Server) On(event string, f EventFunc) *Server {
+	s.handlerMutex.Lock()
+	defer s.handlerMutex.Unlock()
+	s.handlers[event] = f
+	return s
+}
+
+// Group topic 对应的组名
+func (s *Server) Group(topic string) string {
+	return s.GroupPrefix + topic
+}
+
+// Broadcast 向 topic 的所有连接推送事件
+func (s *Server) Broadcast(ctx context.Context, topic, event string, payload interface{}) error {
+	msg, err := websocket.NewEventMessage(event, payload)
+	if err != nil {
+		return err
+	}
+	return s.Publisher.SendMsgCtx(ctx, s.Group(topic), string(msg))
+}
+
+func (s *Server) getConn(c *websocket.Client) *conn {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.conns[c]
+}
+
+func (s *Server) OnConnect(ctx context.Context, c *websocket.Client) error {
+	c.SetWriteBatch(false)
+	if s.ReadLimit > 0 {
+		c.SetReadLimit(s.ReadLimit)
+	}
+	// 格式（vsn 1.x 为对象，2.x 为数组）按客户端发来的消息确定，客户端总是先发送 join 或 heartbeat
+	cn := &conn{v2: true, channels: map[string]*channel{}}
+	s.mutex.Lock()
+	s.conns[c] = cn
+	s.mutex.Unlock()
+	return s.Handler.OnConnect(ctx, c)
+}
+
+func (s *Server) OnClose(ctx context.Context, c *websocket.Client, reason websocket.CloseReason) {
+	s.mutex.Lock()
+	cn := s.conns[c]
+	delete(s.conns, c)
+	s.mutex.Unlock()
+
+	if cn != nil {
+		cn.mutex.Lock()
+		cn.finished = true
+		channels := cn.channels
+		cn.channels = map[string]*channel{}
+		cn.mutex.Unlock()
+		for topic, ch := range channels {
+			s.leave(ctx, cn, topic, ch)
+		}
+	}
+	s.Handler.OnClose(ctx, c, reason)
+}
+
+func (s *Server) OnMessage(ctx context.Context, c *websocket.Client, mt websocket.MessageType, data []byte) error {
+	cn := s.getConn(c)
+	if cn == nil {
+		return nil
+	}
+
+	msg, v2, err := decode(data)
+	if err != nil {
+		return err
+	}
+	cn.mutex.Lock()
+	cn.v2 = v2
+	cn.mutex.Unlock()
+
+	switch {
+	case msg.Topic == TopicPhoenix && msg.Event == EventHeartbeat:
+		s.reply(c, cn, msg, StatusOK, nil)
+	case msg.Event == EventJoin:
+		s.join(ctx, c, cn, msg)
+	case msg.Event == EventLeave:
+		if ch := cn.remove(msg.Topic, ""); ch != nil {
+			s.leave(ctx, cn, msg.Topic, ch)
+		}
+		s.reply(c, cn, msg, StatusOK, nil)
+		s.push(c, cn, &Message{JoinRef: msg.JoinRef, Topic: msg.Topic, Event: EventClose})
+	default:
+		s.handle(ctx, c, cn, msg)
+	}
+	return nil
+}
+
+func (s *Server) join(ctx context.Context, c *websocket.Client, cn *conn, msg *Message) {
+	var resp interface{}
+	if s.Join != nil {
+		var err error
+		if resp, err = s.Join(ctx, c, msg.Topic, msg.Payload); err != nil {
+			s.reply(c, cn, msg, StatusError, reason(err))
+			return
+		}
+	}
+
+	// 同一个 topic 重复 join 时关闭旧的 channel
+	if old := cn.remove(msg.Topic, ""); old != nil {
+		s.leave(ctx, cn, msg.Topic, old)
+		s.push(c, cn, &Message{JoinRef: old.joinRef, Topic: msg.Topic, Event: EventClose})
+	}
+
+	topic := msg.Topic
+	ch := &channel{joinRef: msg.JoinRef}
+	if ch.joinRef == "" {
+		ch.joinRef = msg.Ref
+	}
+	cn.mutex.Lock()
+	if cn.finished {
+		cn.mutex.Unlock()
+		return
+	}
+	cn.channels[topic] = ch
+	cn.mutex.Unlock()
+
+	deliver := func(data []byte) {
+		s.push(c, cn, toMessage(ch.joinRef, topic, data))
+	}
+	onEnd := websocket.HandlerFuncs{Close: func(ctx context.Context, v *websocket.Client, r websocket.CloseReason) {
+		// 不是客户端 leave 时（如消费过慢被移出组），phx_error 让客户端重新 join
+		if cn.remove(topic, ch.joinRef) == ch {
+			s.untrack(ctx, cn, topic, ch)
+			s.push(c, cn, &Message{JoinRef: ch.joinRef, Topic: topic, Event: EventError})
+		}
+	}}
+	v, err := s.Subscriber.Subscribe(c, s.Group(topic), deliver, onEnd)
+	if err != nil {
+		cn.remove(topic, ch.joinRef)
+		s.reply(c, cn, msg, StatusError, reason(err))
+		return
+	}
+
+	cn.mutex.Lock()
+	current := cn.channels[topic] == ch && !cn.finished
+	if current {
+		ch.v = v
+	}
+	cn.mutex.Unlock()
+	if !current {
+		// 订阅期间连接已关闭
+		v.Close()
+		return
+	}
+
+	s.reply(c, cn, msg, StatusOK, resp)
+	s.track(ctx, c, cn, topic, ch)
+}
+
+// track 记录 presence，向新连接发送 presence_state，向组内广播 presence_diff
+func (s *Server) track(ctx context.Context, c *websocket.Client, cn *conn, topic string, ch *channel) {
+	if s.Track == nil || s.Presence == nil {
+		return
+	}
+	key, meta := s.Track(c, topic)
+	if key == "" {
+		return
+	}
+	m := Meta{}
+	for k, v := range meta {
+		m[k] = v
+	}
+	ref := newRef()
+	m["phx_ref"] = ref
+	// 先记录到 channel，Track 期间 leave 时也能删除
+	cn.mutex.Lock()
+	ch.key, ch.ref, ch.meta = key, ref, m
+	cn.mutex.Unlock()
+	if err := s.Presence.Track(ctx, topic, key, ref, m); err != nil {
+		s.Handler.OnError(ctx, c, err)
+		return
+	}
+
+	state, err := s.Presence.State(ctx, topic)
+	if err != nil {
+		s.Handler.OnError(ctx, c, err)
+	} else if raw, err := json.Marshal(state); err == nil {
+		s.push(c, cn, &Message{JoinRef: ch.joinRef, Topic: topic, Event: EventPresenceState, Payload: raw})
+	}
+	_ = s.Broadcast(ctx, topic, EventPresenceDiff, &PresenceDiff{
+		Joins:  map[string]*PresenceEntry{key: {Metas: []Meta{m}}},
+		Leaves: map[string]*PresenceEntry{},
+	})
+}
+
+// untrack 删除 presence 记录并广播 presence_diff
+func (s *Server) untrack(ctx context.Context, cn *conn, topic string, ch *channel) {
+	cn.mutex.Lock()
+	key, ref, meta := ch.key, ch.ref, ch.meta
+	cn.mutex.Unlock()
+	if ref == "" || s.Presence == nil {
+		return
+	}
+	if err := s.Presence.Untrack(ctx, topic, ref); err != nil {
+		return
+	}
+	_ = s.Broadcast(ctx, topic, EventPresenceDiff, &PresenceDiff{
+		Joins:  map[string]*PresenceEntry{},
+		Leaves: map[string]*PresenceEntry{key: {Metas: []Meta{meta}}},
+	})
+}
+
+// leave 退订并删除 presence
+func (s *Server) leave(ctx context.Context, cn *conn, topic string, ch *channel) {
+	cn.mutex.Lock()
+	v := ch.v
+	cn.mutex.Unlock()
+	if v != nil {
+		v.Close()
+	}
+	s.untrack(ctx, cn, topic, ch)
+}
+
+func (s *Server) handle(ctx context.Context, c *websocket.Client, cn *conn, msg *Message) {
+	cn.mutex.Lock()
+	_, joined := cn.channels[msg.Topic]
+	cn.mutex.Unlock()
+	if !joined {
+		s.reply(c, cn, msg, StatusError, map[string]string{"reason": "unmatched topic"})
+		return
+	}
+
+	s.handlerMutex.RLock()
+	f := s.handlers[msg.Event]
+	s.handlerMutex.RUnlock()
+
+	var resp interface{}
+	var err error
+	if f != nil {
+		resp, err = f(ctx, c, msg.Topic, msg.Payload)
+	} else if strings.HasPrefix(msg.Event, "phx_") || strings.HasPrefix(msg.Event, "presence_") {
+		err = errors.New("reserved event")
+	} else {
+		err = s.Publisher.SendMsgCtx(ctx, s.Group(msg.Topic), string(mustEvent(msg.Event, msg.Payload)))
+	}
+
+	switch {
+	case err == ErrNoReply:
+	case err != nil:
+		s.reply(c, cn, msg, StatusError, reason(err))
+	default:
+		s.reply(c, cn, msg, StatusOK, resp)
+	}
+}
+
+// remove 移除 topic 的 channel，joinRef 不为空时只移除匹配的 channel
+func (cn *conn) remove(topic, joinRef string) *channel {
+	cn.mutex.Lock()
+	defer cn.mutex.Unlock()
+	ch := cn.channels[topic]
+	if ch == nil || (joinRef != "" && ch.joinRef != joinRef) {
+		return nil
+	}
+	delete(cn.channels, topic)
+	return ch
+}
+
+func (s *Server) reply(c *websocket.Client, cn *conn, msg *Message, status string, resp interface{}) {
+	if resp == nil {
+		resp = struct{}{}
+	}
+	raw, err := json.Marshal(&Reply{Status: status, Response: resp})
+	if err != nil {
+		raw, _ = json.Marshal(&Reply{Status: StatusError, Response: reason(err)})
+	}
+	s.push(c, cn, &Message{JoinRef: msg.JoinRef, Ref: msg.Ref, Topic: msg.Topic, Event: EventReply, Payload: raw})
+}
+
+func (s *Server) push(c *websocket.Client, cn *conn, msg *Message) {
+	cn.mutex.Lock()
+	v2 := cn.v2
+	cn.mutex.Unlock()
+	data, err := encode(msg, v2)
+	if err != nil {
+		return
+	}
+	// 不阻塞，发送队列已满时按慢消费者断开连接，连接已关闭时丢弃
+	if !c.TrySend(data) {
+		c.Terminate(websocket.NewCloseReason(websocket.CloseSlowConsumer, ""))
+	}
+}
+
+// toMessage 将组内消息转换为推送，事件格式的消息按事件推送，其他消息作为 message 事件，
+// 非 JSON 的消息作为 {"body": "..."}
+func toMessage(joinRef, topic string, data []byte) *Message {
+	var em websocket.EventMessage
+	if json.Unmarshal(data, &em) == nil && em.Event != "" {
+		return &Message{JoinRef: joinRef, Topic: topic, Event: em.Event, Payload: em.Data}
+	}
+	payload := json.RawMessage(data)
+	if !json.Valid(data) {
+		payload, _ = json.Marshal(map[string]string{"body": string(data)})
+	}
+	return &Message{JoinRef: joinRef, Topic: topic, Event: "message", Payload: payload}
+}
+
+func mustEvent(event string, payload json.RawMessage) []byte {
+	data, _ := json.Marshal(&websocket.EventMessage{Event: event, Data: payload})
+	return data
+}
+
+func reason(err error) map[string]string {
+	return map[string]string{"reason": err.Error()}
+}
+
+func newRef() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,67 @@
+package phoenix
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	gorilla "github.com/gorilla/websocket"
+
+	"github.com/assembly-hub/websocket"
+	"github.com/assembly-hub/websocket/simplesub"
+)
+
+func TestLargeJoinPayload(t *testing.T) {
+	m := simplesub.NewManager()
+	s := NewServer(m)
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = m.AddGroupWithHandler("phoenix", w, r, s)
+	}))
+	defer hs.Close()
+	conn, _, err := gorilla.DefaultDialer.Dial("ws"+strings.TrimPrefix(hs.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// 超过连接默认的 512 字节
+	join := `["1", "1", "room:lobby", "phx_join", {"token": "` + strings.Repeat("x", 4096) + `"}]`
+	if err := conn.WriteMessage(gorilla.TextMessage, []byte(join)); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, msg, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(msg), `"phx_reply"`) || !strings.Contains(string(msg), `"ok"`) {
+		t.Fatalf("got %s", msg)
+	}
+}
+
+func TestPushWithoutBlocking(t *testing.T) {
+	s := NewServer(simplesub.NewManager())
+	c := websocket.NewClient(context.Background(), nil, 1)
+	cn := &conn{channels: map[string]*channel{}}
+
+	s.push(c, cn, &Message{Topic: "room:lobby", Event: "a"})
+	// 发送队列已满，不阻塞
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.push(c, cn, &Message{Topic: "room:lobby", Event: "b"})
+		c.CloseSend()
+		s.push(c, cn, &Message{Topic: "room:lobby", Event: "c"})
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("push blocked")
+	}
+	if n := len(c.Send); n != 1 {
+		t.Fatalf("sent %d", n)
+	}
+}
@@ -0,0 +1,70 @@
+// Package websocket
+package websocket
+
+import (
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// Protocols 子协议与 Handler 的对应关系，管理器升级连接时按客户端 Sec-WebSocket-Protocol 协商子协议，
+// 并使用该子协议的 Handler，一个入口可同时服务多种协议（如 JSON 与二进制协议）的客户端
+type Protocols struct {
+	mutex    sync.RWMutex
+	names    []string
+	handlers map[string]Handler
+}
+
+// Handle 注册子协议的 Handler，重复注册时覆盖，协商时按注册顺序优先
+func (p *Protocols) Handle(subprotocol string, h Handler) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.handlers == nil {
+		p.handlers = map[string]Handler{}
+	}
+	if _, ok := p.handlers[subprotocol]; !ok {
+		p.names = append(p.names, subprotocol)
+	}
+	p.handlers[subprotocol] = h
+}
+
+// Upgrader 在 up 的子协议前加入已注册的子协议，没有注册时返回 up
+func (p *Protocols) Upgrader(up *websocket.Upgrader) *websocket.Upgrader {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	if len(p.names) == 0 {
+		return up
+	}
+	u := *up
+	u.Subprotocols = append([]string(nil), p.names...)
+	for _, name := range up.Subprotocols {
+		if _, ok := p.handlers[name]; !ok {
+			u.Subprotocols = append(u.Subprotocols, name)
+		}
+	}
+	return &u
+}
+
+// Handler 协商出的子协议对应的 Handler，未注册时返回 def
+func (p *Protocols) Handler(subprotocol string, def Handler) Handler {
+	if subprotocol == "" {
+		return def
+	}
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	if h, ok := p.handlers[subprotocol]; ok {
+		return h
+	}
+	return def
+}
+
+// Subprotocol 握手协商出的子协议，未协商时为空，虚拟连接返回所属连接的子协议
+func (c *Client) Subprotocol() string {
+	if c.parent != nil {
+		return c.parent.Subprotocol()
+	}
+	if c.Conn == nil {
+		return ""
+	}
+	return c.Conn.Subprotocol()
+}
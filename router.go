@@ -0,0 +1,170 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// EventError 路由出错时发给客户端的事件名
+const EventError = "error"
+
+// 路由错误码
+const (
+	ErrCodeInvalidMessage = "invalid_message"
+	ErrCodeUnknownEvent   = "unknown_event"
+)
+
+// EventMessage 事件消息格式：{"event": "...", "data": ...}
+type EventMessage struct {
+	Event string `json:"event"`
+	// Key 合并发送的 key，见 Conflation
+	Key  string          `json:"key,omitempty"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// EventErrorData EventError 事件的 data
+type EventErrorData struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// NewEventMessage 编码事件消息，data 为 nil 时不带 data 字段
+func NewEventMessage(event string, data interface{}) ([]byte, error) {
+	msg := EventMessage{Event: event}
+	if data != nil {
+		raw, err := json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		msg.Data = raw
+	}
+	return json.Marshal(&msg)
+}
+
+// EventContext 事件处理的上下文
+type EventContext struct {
+	context.Context
+	Client *Client
+	Event  string
+	Data   json.RawMessage
+}
+
+// Bind 将 data 解码到 v
+func (c *EventContext) Bind(v interface{}) error {
+	if len(c.Data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(c.Data, v)
+}
+
+// Emit 向当前连接发送事件
+func (c *EventContext) Emit(event string, data interface{}) error {
+	return c.Client.Emit(event, data)
+}
+
+// Broadcast 向当前连接所在的组发送事件
+func (c *EventContext) Broadcast(event string, data interface{}) error {
+	msg, err := NewEventMessage(event, data)
+	if err != nil {
+		return err
+	}
+	return c.Client.BroadcastCtx(c.Context, msg)
+}
+
+// EventHandlerFunc 事件处理函数，返回的 error 交给 Handler.OnError
+type EventHandlerFunc func(c *EventContext) error
+
+// Middleware 事件处理中间件
+type Middleware func(next EventHandlerFunc) EventHandlerFunc
+
+// Router 按事件名分发消息的 Handler，OnConnect、OnClose、OnError 交给嵌入的 Handler。
+// 未注册的事件交给 NotFound，默认向客户端发送 error 事件
+type Router struct {
+	Handler
+
+	mutex      sync.RWMutex
+	handlers   map[string]EventHandlerFunc
+	middleware []Middleware
+	notFound   EventHandlerFunc
+}
+
+// NewRouter 创建事件路由
+func NewRouter() *Router {
+	return &Router{
+		Handler:  NopHandler{},
+		handlers: map[string]EventHandlerFunc{},
+	}
+}
+
+// On 注册事件处理，重复注册时覆盖
+func (r *Router) On(event string, h EventHandlerFunc) *Router {
+	r.mutex.Lock()
+	r.handlers[event] = h
+	r.mutex.Unlock()
+	return r
+}
+
+// Use 添加中间件，按添加顺序由外到内执行，对 NotFound 同样生效
+func (r *Router) Use(mw ...Middleware) *Router {
+	r.mutex.Lock()
+	r.middleware = append(r.middleware, mw...)
+	r.mutex.Unlock()
+	return r
+}
+
+// NotFound 设置未注册事件的处理
+func (r *Router) NotFound(h EventHandlerFunc) *Router {
+	r.mutex.Lock()
+	r.notFound = h
+	r.mutex.Unlock()
+	return r
+}
+
+func (r *Router) route(event string) EventHandlerFunc {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	h, ok := r.handlers[event]
+	if !ok {
+		h = r.notFound
+		if h == nil {
+			h = defaultNotFound
+		}
+	}
+	for i := len(r.middleware) - 1; i >= 0; i-- {
+		h = r.middleware[i](h)
+	}
+	return h
+}
+
+// OnConnect 关闭文本消息的合并发送，每个事件单独一帧，再交给嵌入的 Handler
+func (r *Router) OnConnect(ctx context.Context, c *Client) error {
+	c.SetWriteBatch(false)
+	return r.Handler.OnConnect(ctx, c)
+}
+
+func (r *Router) OnMessage(ctx context.Context, c *Client, mt MessageType, msg []byte) error {
+	var em EventMessage
+	if err := json.Unmarshal(msg, &em); err != nil || em.Event == "" {
+		return c.Emit(EventError, &EventErrorData{Code: ErrCodeInvalidMessage, Message: "message must be {\"event\": \"...\", \"data\": ...}"})
+	}
+	return r.route(em.Event)(&EventContext{Context: ctx, Client: c, Event: em.Event, Data: em.Data})
+}
+
+func defaultNotFound(c *EventContext) error {
+	return c.Emit(EventError, &EventErrorData{Code: ErrCodeUnknownEvent, Message: fmt.Sprintf("unknown event: %s", c.Event)})
+}
+
+// Emit 向连接发送事件，不阻塞，发送队列已满或连接已关闭时返回 ErrNotSent
+func (c *Client) Emit(event string, data interface{}) error {
+	msg, err := NewEventMessage(event, data)
+	if err != nil {
+		return err
+	}
+	if !c.TrySend(msg) {
+		return ErrNotSent
+	}
+	return nil
+}
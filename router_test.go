@@ -0,0 +1,81 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestRouterFramePerEvent(t *testing.T) {
+	r := NewRouter()
+	r.On("ping", func(c *EventContext) error {
+		for i := 0; i < 3; i++ {
+			if err := c.Emit("pong", i); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	conn := dial(t, startServer(t, r))
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"event":"ping"}`)); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for i := 0; i < 3; i++ {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 每帧是一条完整的 JSON 消息
+		var em EventMessage
+		if err := json.Unmarshal(msg, &em); err != nil || em.Event != "pong" {
+			t.Fatalf("frame %d: %q", i, msg)
+		}
+	}
+}
+
+func TestEmitAfterSendClosed(t *testing.T) {
+	c := NewClient(context.Background(), nil, 1)
+	if err := c.Emit("a", 1); err != nil {
+		t.Fatal(err)
+	}
+	// 队列已满
+	if err := c.Emit("b", 2); !errors.Is(err, ErrNotSent) {
+		t.Fatalf("got %v", err)
+	}
+	<-c.Send
+	c.CloseSend()
+	if err := c.Emit("c", 3); !errors.Is(err, ErrNotSent) {
+		t.Fatalf("got %v", err)
+	}
+}
+
+// recordSubscriber 记录订阅请求
+type recordSubscriber struct {
+	groups []string
+}
+
+func (s *recordSubscriber) Subscribe(parent *Client, group string, deliver func(msg []byte), h Handler) (*Client, error) {
+	s.groups = append(s.groups, group)
+	return NewVirtualClient(parent, 1, deliver), nil
+}
+
+func TestHandleSubscribeNilAllow(t *testing.T) {
+	s := &recordSubscriber{}
+	r := NewRouter().HandleSubscribe(s, nil)
+	c := NewClient(context.Background(), nil, 4)
+	if err := r.OnMessage(context.Background(), c, TextMessage, []byte(`{"event": "subscribe", "data": {"group": "other"}}`)); err != nil {
+		t.Fatal(err)
+	}
+	var em EventMessage
+	if err := json.Unmarshal(<-c.Send, &em); err != nil || em.Event != EventError {
+		t.Fatalf("got %+v, %v", em, err)
+	}
+	if len(s.groups) != 0 {
+		t.Fatalf("subscribed %v", s.groups)
+	}
+}
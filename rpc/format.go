@@ -0,0 +1,261 @@
+// Package rpc 基于 websocket 连接的请求/响应调用，支持 JSON-RPC 2.0
+package rpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrNotRPC 消息不是当前格式的 rpc 消息，交给 Server 嵌入的 Handler 处理
+var ErrNotRPC = errors.New("rpc: not a rpc message")
+
+// JSON-RPC 2.0 定义的错误码
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+)
+
+// Error rpc 调用错误，方法返回 *Error 时原样发给对端
+type Error struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+// NewError 创建调用错误
+func NewError(code int, msg string) *Error {
+	return &Error{Code: code, Message: msg}
+}
+
+// Message 各格式统一的消息，Method 不为空时是请求，ID 为空的请求是通知
+type Message struct {
+	ID     json.RawMessage
+	Method string
+	Params json.RawMessage
+	Result json.RawMessage
+	Error  *Error
+	// Invalid 不是合法的请求或响应，Server 以该错误回复
+	Invalid *Error
+}
+
+// IsRequest 是否为请求或通知
+func (m *Message) IsRequest() bool {
+	return m.Method != "" && m.Invalid == nil
+}
+
+// IsNotify 是否为不需要响应的通知
+func (m *Message) IsNotify() bool {
+	return m.Method != "" && len(m.ID) == 0
+}
+
+// Format 消息的编码格式
+type Format interface {
+	Name() string
+	// Decode 解析一帧数据，batch 表示是否为批量消息，不是该格式时返回 ErrNotRPC，
+	// 整帧无法处理时返回 *Error，Server 以该错误回复
+	Decode(data []byte) (msgs []*Message, batch bool, err error)
+	// Encode 编码消息，batch 为 true 时编码为批量消息
+	Encode(msgs []*Message, batch bool) ([]byte, error)
+}
+
+var (
+	// JSONRPC JSON-RPC 2.0，支持批量请求
+	JSONRPC Format = jsonRPC{}
+	// Native {"type": "call|reply|notify", "id": 1, "method": "...", "data": ..., "error": {...}}
+	Native Format = native{}
+)
+
+type jsonRPCMessage struct {
+	Version string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+type jsonRPC struct{}
+
+func (jsonRPC) Name() string {
+	return "jsonrpc"
+}
+
+// Decode 以 { 或 [ 开头但不是合法 JSON 时返回 CodeParseError，空的批量请求返回 CodeInvalidRequest；
+// 单条消息没有 jsonrpc 字段时返回 ErrNotRPC，批量中不合法的消息以 Invalid 单独回复
+func (jsonRPC) Decode(data []byte) ([]*Message, bool, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || (data[0] != '{' && data[0] != '[') {
+		return nil, false, ErrNotRPC
+	}
+	if !json.Valid(data) {
+		return nil, false, NewError(CodeParseError, "parse error")
+	}
+
+	if data[0] == '{' {
+		var probe struct {
+			Version *json.RawMessage `json:"jsonrpc"`
+		}
+		if json.Unmarshal(data, &probe) != nil || probe.Version == nil {
+			return nil, false, ErrNotRPC
+		}
+		return []*Message{decodeJSONRPC(data)}, false, nil
+	}
+
+	var elems []json.RawMessage
+	if err := json.Unmarshal(data, &elems); err != nil {
+		return nil, false, NewError(CodeParseError, "parse error")
+	}
+	if len(elems) == 0 {
+		return nil, false, NewError(CodeInvalidRequest, "empty batch")
+	}
+	msgs := make([]*Message, 0, len(elems))
+	for _, elem := range elems {
+		msgs = append(msgs, decodeJSONRPC(elem))
+	}
+	return msgs, true, nil
+}
+
+// decodeJSONRPC 解码一条消息，有 method 的是请求，没有 method 且有 result 或 error 的是响应，
+// 其他的以 Invalid 标记为不合法的请求
+func decodeJSONRPC(data []byte) *Message {
+	invalid := &Message{Invalid: NewError(CodeInvalidRequest, "invalid request")}
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(data, &fields) != nil {
+		return invalid
+	}
+	if id, ok := fields["id"]; ok && validID(id) {
+		invalid.ID = id
+	}
+
+	var m jsonRPCMessage
+	if json.Unmarshal(data, &m) != nil || m.Version != "2.0" || !validID(m.ID) {
+		return invalid
+	}
+	msg := &Message{ID: m.ID, Method: m.Method, Params: m.Params, Result: m.Result, Error: m.Error}
+	_, hasMethod := fields["method"]
+	_, hasResult := fields["result"]
+	_, hasError := fields["error"]
+	switch {
+	case hasMethod:
+		if m.Method != "" && validParams(m.Params) {
+			return msg
+		}
+	case hasResult || hasError:
+		return msg
+	}
+	return invalid
+}
+
+// validID id 只能是字符串、数字或 null
+func validID(id json.RawMessage) bool {
+	id = bytes.TrimSpace(id)
+	if len(id) == 0 || string(id) == "null" {
+		return true
+	}
+	return id[0] == '"' || id[0] == '-' || (id[0] >= '0' && id[0] <= '9')
+}
+
+// validParams params 只能是数组或对象
+func validParams(params json.RawMessage) bool {
+	params = bytes.TrimSpace(params)
+	return len(params) == 0 || params[0] == '[' || params[0] == '{'
+}
+
+func (jsonRPC) Encode(msgs []*Message, batch bool) ([]byte, error) {
+	raws := make([]jsonRPCMessage, 0, len(msgs))
+	for _, m := range msgs {
+		raw := jsonRPCMessage{Version: "2.0", ID: m.ID, Method: m.Method, Params: m.Params, Result: m.Result, Error: m.Error}
+		if !m.IsRequest() {
+			if len(raw.ID) == 0 {
+				// 无法解析 id 的错误响应，id 必须为 null
+				raw.ID = json.RawMessage("null")
+			}
+			if raw.Error == nil && len(raw.Result) == 0 {
+				raw.Result = json.RawMessage("null")
+			}
+		}
+		raws = append(raws, raw)
+	}
+	if batch {
+		return json.Marshal(raws)
+	}
+	if len(raws) != 1 {
+		return nil, fmt.Errorf("rpc: encode %d messages without batch", len(raws))
+	}
+	return json.Marshal(&raws[0])
+}
+
+const (
+	nativeCall   = "call"
+	nativeReply  = "reply"
+	nativeNotify = "notify"
+)
+
+type nativeMessage struct {
+	Type   string          `json:"type"`
+	ID     json.RawMessage `json:"id,omitempty"`
+	Method string          `json:"method,omitempty"`
+	Data   json.RawMessage `json:"data,omitempty"`
+	Error  *Error          `json:"error,omitempty"`
+}
+
+type native struct{}
+
+func (native) Name() string {
+	return "native"
+}
+
+func (native) Decode(data []byte) ([]*Message, bool, error) {
+	var m nativeMessage
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, false, ErrNotRPC
+	}
+
+	msg := &Message{ID: m.ID, Method: m.Method, Error: m.Error}
+	switch m.Type {
+	case nativeCall, nativeNotify:
+		if m.Method == "" {
+			return nil, false, ErrNotRPC
+		}
+		if m.Type == nativeNotify {
+			msg.ID = nil
+		}
+		msg.Params = m.Data
+	case nativeReply:
+		msg.Method = ""
+		msg.Result = m.Data
+	default:
+		return nil, false, ErrNotRPC
+	}
+	return []*Message{msg}, false, nil
+}
+
+func (native) Encode(msgs []*Message, batch bool) ([]byte, error) {
+	if batch || len(msgs) != 1 {
+		return nil, errors.New("rpc: native format does not support batch")
+	}
+
+	m := msgs[0]
+	raw := nativeMessage{ID: m.ID, Method: m.Method, Error: m.Error}
+	switch {
+	case m.IsNotify():
+		raw.Type = nativeNotify
+		raw.Data = m.Params
+	case m.IsRequest():
+		raw.Type = nativeCall
+		raw.Data = m.Params
+	default:
+		raw.Type = nativeReply
+		raw.Data = m.Result
+	}
+	return json.Marshal(&raw)
+}
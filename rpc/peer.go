@@ -0,0 +1,227 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/assembly-hub/websocket"
+)
+
+var (
+	// ErrClosed 连接已关闭，未完成的调用全部失败
+	ErrClosed = errors.New("rpc: connection closed")
+	// ErrTimeout 调用超时
+	ErrTimeout = errors.New("rpc: call timeout")
+	// ErrNotSent 发送队列已满或连接已关闭，消息未发送
+	ErrNotSent = errors.New("rpc: send queue full or connection closed")
+)
+
+// Future 服务端调用客户端的结果
+type Future struct {
+	done   chan struct{}
+	once   sync.Once
+	result json.RawMessage
+	err    error
+	// 调用超时的定时器，完成时停止
+	timer *time.Timer
+}
+
+func newFuture() *Future {
+	return &Future{done: make(chan struct{})}
+}
+
+// finish 只有第一次调用生效
+func (f *Future) finish(result json.RawMessage, err error) {
+	f.once.Do(func() {
+		if f.timer != nil {
+			f.timer.Stop()
+		}
+		f.result, f.err = result, err
+		close(f.done)
+	})
+}
+
+// Done 调用完成（成功、失败或超时）时关闭
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait 等待调用结果，ctx 结束时返回 ctx.Err()，调用本身不会取消
+func (f *Future) Wait(ctx context.Context) (json.RawMessage, error) {
+	select {
+	case <-f.done:
+		return f.result, f.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Decode 等待调用结果并解码到 v
+func (f *Future) Decode(ctx context.Context, v interface{}) error {
+	result, err := f.Wait(ctx)
+	if err != nil {
+		return err
+	}
+	if v == nil || len(result) == 0 {
+		return nil
+	}
+	return json.Unmarshal(result, v)
+}
+
+// Peer 一个连接上的 rpc 状态，记录服务端发起、等待响应的调用
+type Peer struct {
+	client  *websocket.Client
+	format  Format
+	timeout time.Duration
+
+	seq     atomic.Uint64
+	mutex   sync.Mutex
+	pending map[string]*Future
+	closed  bool
+}
+
+func newPeer(c *websocket.Client, format Format, timeout time.Duration) *Peer {
+	return &Peer{
+		client:  c,
+		format:  format,
+		timeout: timeout,
+		pending: map[string]*Future{},
+	}
+}
+
+// Client 对应的连接
+func (p *Peer) Client() *websocket.Client {
+	return p.client
+}
+
+// Call 调用客户端方法，ctx 没有 deadline 时使用 Server.CallTimeout。
+// ctx 结束或超时时 Future 以对应错误完成，之后到达的响应被丢弃
+func (p *Peer) Call(ctx context.Context, method string, params interface{}) *Future {
+	f := newFuture()
+	raw, err := marshalParams(params)
+	if err != nil {
+		f.finish(nil, err)
+		return f
+	}
+
+	id := strconv.FormatUint(p.seq.Add(1), 10)
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		f.finish(nil, ErrClosed)
+		return f
+	}
+	p.pending[id] = f
+	if _, ok := ctx.Deadline(); !ok && p.timeout > 0 {
+		// 在锁内设置，调用完成时 finish 一定能看到并停止
+		f.timer = time.AfterFunc(p.timeout, func() { p.fail(id, ErrTimeout) })
+	}
+	p.mutex.Unlock()
+
+	data, err := p.format.Encode([]*Message{{ID: json.RawMessage(id), Method: method, Params: raw}}, false)
+	if err != nil {
+		p.fail(id, err)
+		return f
+	}
+
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				err := ctx.Err()
+				if errors.Is(err, context.DeadlineExceeded) {
+					err = ErrTimeout
+				}
+				p.fail(id, err)
+			case <-f.done:
+			}
+		}()
+	}
+
+	if !p.client.TrySend(data) {
+		p.fail(id, ErrNotSent)
+	}
+	return f
+}
+
+// Notify 向客户端发送通知，不等待响应
+func (p *Peer) Notify(method string, params interface{}) error {
+	raw, err := marshalParams(params)
+	if err != nil {
+		return err
+	}
+	data, err := p.format.Encode([]*Message{{Method: method, Params: raw}}, false)
+	if err != nil {
+		return err
+	}
+	if !p.client.TrySend(data) {
+		return ErrNotSent
+	}
+	return nil
+}
+
+func (p *Peer) fail(id string, err error) {
+	p.mutex.Lock()
+	f := p.pending[id]
+	delete(p.pending, id)
+	p.mutex.Unlock()
+	if f != nil {
+		f.finish(nil, err)
+	}
+}
+
+// resolve 处理客户端的响应，返回是否有对应的调用
+func (p *Peer) resolve(m *Message) bool {
+	id := idKey(m.ID)
+	p.mutex.Lock()
+	f := p.pending[id]
+	delete(p.pending, id)
+	p.mutex.Unlock()
+	if f == nil {
+		return false
+	}
+
+	if m.Error != nil {
+		f.finish(nil, m.Error)
+	} else {
+		f.finish(m.Result, nil)
+	}
+	return true
+}
+
+// close 连接关闭，未完成的调用以 ErrClosed 失败
+func (p *Peer) close() {
+	p.mutex.Lock()
+	pending := p.pending
+	p.pending = map[string]*Future{}
+	p.closed = true
+	p.mutex.Unlock()
+
+	for _, f := range pending {
+		f.finish(nil, ErrClosed)
+	}
+}
+
+func marshalParams(params interface{}) (json.RawMessage, error) {
+	if params == nil {
+		return nil, nil
+	}
+	if raw, ok := params.(json.RawMessage); ok {
+		return raw, nil
+	}
+	return json.Marshal(params)
+}
+
+// idKey 统一数字和字符串形式的 id，对端把数字 id 作为字符串返回时也能对应
+func idKey(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	return string(raw)
+}
@@ -0,0 +1,139 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/assembly-hub/websocket"
+)
+
+// newTestServer 不经过网络的连接，回复从 c.Send 读取
+func newTestServer(t *testing.T) (*Server, *websocket.Client) {
+	t.Helper()
+	srv := NewServer(JSONRPC)
+	srv.Register("add", func(ctx context.Context, req *Request) (interface{}, error) {
+		var args [2]int
+		if err := req.Bind(&args); err != nil {
+			return nil, err
+		}
+		return args[0] + args[1], nil
+	})
+	c := websocket.NewClient(context.Background(), nil, 8)
+	if err := srv.OnConnect(context.Background(), c); err != nil {
+		t.Fatal(err)
+	}
+	return srv, c
+}
+
+func readReply(t *testing.T, c *websocket.Client) string {
+	t.Helper()
+	select {
+	case data := <-c.Send:
+		return string(data)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reply")
+		return ""
+	}
+}
+
+func TestJSONRPCErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "parse error",
+			in:   `{"jsonrpc": "2.0", "method": "add", "params": [1, 2`,
+			want: `{"jsonrpc":"2.0","id":null,"error":{"code":-32700,"message":"parse error"}}`,
+		},
+		{
+			name: "empty batch",
+			in:   `[]`,
+			want: `{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"empty batch"}}`,
+		},
+		{
+			name: "missing method",
+			in:   `{"jsonrpc": "2.0", "id": 1}`,
+			want: `{"jsonrpc":"2.0","id":1,"error":{"code":-32600,"message":"invalid request"}}`,
+		},
+		{
+			name: "method not string",
+			in:   `{"jsonrpc": "2.0", "id": 2, "method": 1}`,
+			want: `{"jsonrpc":"2.0","id":2,"error":{"code":-32600,"message":"invalid request"}}`,
+		},
+		{
+			name: "bad params",
+			in:   `{"jsonrpc": "2.0", "id": 3, "method": "add", "params": "bar"}`,
+			want: `{"jsonrpc":"2.0","id":3,"error":{"code":-32600,"message":"invalid request"}}`,
+		},
+		{
+			name: "wrong version",
+			in:   `{"jsonrpc": "1.0", "id": 4, "method": "add"}`,
+			want: `{"jsonrpc":"2.0","id":4,"error":{"code":-32600,"message":"invalid request"}}`,
+		},
+		{
+			name: "batch with bad elements",
+			in:   `[1, {"jsonrpc": "2.0", "id": 5, "method": "add", "params": [1, 2]}, {"jsonrpc": "2.0", "id": 6}]`,
+			want: `[{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"invalid request"}},` +
+				`{"jsonrpc":"2.0","id":5,"result":3},` +
+				`{"jsonrpc":"2.0","id":6,"error":{"code":-32600,"message":"invalid request"}}]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, c := newTestServer(t)
+			if err := srv.OnMessage(context.Background(), c, websocket.TextMessage, []byte(tt.in)); err != nil {
+				t.Fatal(err)
+			}
+			if got := readReply(t, c); got != tt.want {
+				t.Fatalf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONRPCNotRPC(t *testing.T) {
+	for _, in := range []string{`ping`, `{"event": "x"}`, ``} {
+		if _, _, err := JSONRPC.Decode([]byte(in)); !errors.Is(err, ErrNotRPC) {
+			t.Fatalf("%q: got %v", in, err)
+		}
+	}
+}
+
+func TestCallAfterSendClosed(t *testing.T) {
+	c := websocket.NewClient(context.Background(), nil, 1)
+	p := newPeer(c, JSONRPC, time.Second)
+	c.CloseSend()
+
+	_, err := p.Call(context.Background(), "x", nil).Wait(context.Background())
+	if !errors.Is(err, ErrNotSent) {
+		t.Fatalf("got %v", err)
+	}
+	if err := p.Notify("x", nil); !errors.Is(err, ErrNotSent) {
+		t.Fatalf("notify got %v", err)
+	}
+}
+
+func TestCallStopsTimer(t *testing.T) {
+	c := websocket.NewClient(context.Background(), nil, 1)
+	p := newPeer(c, JSONRPC, time.Hour)
+	f := p.Call(context.Background(), "x", nil)
+
+	var sent jsonRPCMessage
+	if err := json.Unmarshal(<-c.Send, &sent); err != nil {
+		t.Fatal(err)
+	}
+	if !p.resolve(&Message{ID: sent.ID, Result: json.RawMessage(`1`)}) {
+		t.Fatal("reply not matched")
+	}
+	if result, err := f.Wait(context.Background()); err != nil || string(result) != "1" {
+		t.Fatalf("got %s, %v", result, err)
+	}
+	if f.timer.Stop() {
+		t.Fatal("timer still running after reply")
+	}
+}
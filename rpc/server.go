@@ -0,0 +1,229 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/assembly-hub/websocket"
+)
+
+// Request 客户端发起的调用
+type Request struct {
+	Peer   *Peer
+	Method string
+	Params json.RawMessage
+	// Notify 为 true 时客户端不等待响应，方法的返回值被丢弃
+	Notify bool
+}
+
+// Bind 将参数解码到 v，失败时返回 CodeInvalidParams 错误
+func (r *Request) Bind(v interface{}) error {
+	if len(r.Params) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(r.Params, v); err != nil {
+		return NewError(CodeInvalidParams, err.Error())
+	}
+	return nil
+}
+
+// Method 方法实现，返回值编码为 JSON 作为调用结果
+type Method func(ctx context.Context, req *Request) (interface{}, error)
+
+// Server 处理 rpc 消息的 Handler，不是 rpc 格式的消息交给嵌入的 Handler。
+// 每个请求在单独的 goroutine 中执行，方法内可以再调用客户端并等待结果
+type Server struct {
+	websocket.Handler
+
+	// Format 消息格式，默认 JSONRPC
+	Format Format
+	// Timeout 方法执行超时，通过 ctx 传给方法，0 不限制
+	Timeout time.Duration
+	// CallTimeout 服务端调用客户端的默认超时，调用的 ctx 有 deadline 时以 ctx 为准，0 不限制
+	CallTimeout time.Duration
+
+	mutex   sync.RWMutex
+	methods map[string]Method
+	peers   map[*websocket.Client]*Peer
+}
+
+// NewServer 创建 rpc Server，format 为 nil 时使用 JSONRPC
+func NewServer(format Format) *Server {
+	if format == nil {
+		format = JSONRPC
+	}
+	return &Server{
+		Handler:     websocket.NopHandler{},
+		Format:      format,
+		CallTimeout: 10 * time.Second,
+		methods:     map[string]Method{},
+		peers:       map[*websocket.Client]*Peer{},
+	}
+}
+
+// Register 注册方法，重复注册时覆盖
+func (s *Server) Register(name string, m Method) *Server {
+	s.mutex.Lock()
+	s.methods[name] = m
+	s.mutex.Unlock()
+	return s
+}
+
+// Peer 连接对应的 rpc 状态，连接未经过该 Server 时返回 nil
+func (s *Server) Peer(c *websocket.Client) *Peer {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.peers[c]
+}
+
+// Call 调用客户端方法
+func (s *Server) Call(ctx context.Context, c *websocket.Client, method string, params interface{}) *Future {
+	p := s.Peer(c)
+	if p == nil {
+		f := newFuture()
+		f.finish(nil, ErrClosed)
+		return f
+	}
+	return p.Call(ctx, method, params)
+}
+
+func (s *Server) OnConnect(ctx context.Context, c *websocket.Client) error {
+	c.SetWriteBatch(false)
+	s.mutex.Lock()
+	s.peers[c] = newPeer(c, s.Format, s.CallTimeout)
+	s.mutex.Unlock()
+	return s.Handler.OnConnect(ctx, c)
+}
+
+func (s *Server) OnClose(ctx context.Context, c *websocket.Client, reason websocket.CloseReason) {
+	s.mutex.Lock()
+	p := s.peers[c]
+	delete(s.peers, c)
+	s.mutex.Unlock()
+	if p != nil {
+		p.close()
+	}
+	s.Handler.OnClose(ctx, c, reason)
+}
+
+func (s *Server) OnMessage(ctx context.Context, c *websocket.Client, mt websocket.MessageType, data []byte) error {
+	p := s.Peer(c)
+	if p == nil {
+		return s.Handler.OnMessage(ctx, c, mt, data)
+	}
+
+	msgs, batch, err := s.Format.Decode(data)
+	if errors.Is(err, ErrNotRPC) {
+		return s.Handler.OnMessage(ctx, c, mt, data)
+	}
+	var re *Error
+	if errors.As(err, &re) {
+		// 整帧无法处理，以 id 为 null 的错误回复
+		c.Go("rpc", func() {
+			s.reply(ctx, p, []*Message{{Error: re}}, false)
+		})
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	reqs := make([]*Message, 0, len(msgs))
+	for _, m := range msgs {
+		if m.Invalid != nil || m.IsRequest() {
+			reqs = append(reqs, m)
+		} else {
+			p.resolve(m)
+		}
+	}
+	if len(reqs) == 0 {
+		return nil
+	}
+
+	c.Go("rpc", func() {
+		s.serve(ctx, p, reqs, batch)
+	})
+	return nil
+}
+
+// serve 执行请求并回复，批量请求全部完成后一起回复
+func (s *Server) serve(ctx context.Context, p *Peer, reqs []*Message, batch bool) {
+	replies := make([]*Message, 0, len(reqs))
+	for _, m := range reqs {
+		if reply := s.call(ctx, p, m); reply != nil {
+			replies = append(replies, reply)
+		}
+	}
+	if len(replies) == 0 {
+		return
+	}
+	s.reply(ctx, p, replies, batch)
+}
+
+// reply 编码并发送回复，发送队列已满或连接已关闭时交给 OnError
+func (s *Server) reply(ctx context.Context, p *Peer, replies []*Message, batch bool) {
+	data, err := s.Format.Encode(replies, batch)
+	if err != nil {
+		s.Handler.OnError(ctx, p.client, err)
+		return
+	}
+	if !p.client.TrySend(data) {
+		s.Handler.OnError(ctx, p.client, ErrNotSent)
+	}
+}
+
+func (s *Server) call(ctx context.Context, p *Peer, m *Message) *Message {
+	if m.Invalid != nil {
+		return &Message{ID: m.ID, Error: m.Invalid}
+	}
+
+	s.mutex.RLock()
+	method := s.methods[m.Method]
+	s.mutex.RUnlock()
+
+	var (
+		result interface{}
+		err    error
+	)
+	if method == nil {
+		err = NewError(CodeMethodNotFound, "method not found: "+m.Method)
+	} else {
+		if s.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+			defer cancel()
+		}
+		result, err = method(ctx, &Request{Peer: p, Method: m.Method, Params: m.Params, Notify: m.IsNotify()})
+	}
+
+	if m.IsNotify() {
+		if err != nil {
+			s.Handler.OnError(ctx, p.client, err)
+		}
+		return nil
+	}
+
+	reply := &Message{ID: m.ID}
+	if err != nil {
+		var re *Error
+		if !errors.As(err, &re) {
+			re = NewError(CodeInternalError, err.Error())
+		}
+		reply.Error = re
+		return reply
+	}
+
+	raw, err := marshalParams(result)
+	if err != nil {
+		reply.Error = NewError(CodeInternalError, err.Error())
+		return reply
+	}
+	if raw == nil {
+		raw = json.RawMessage("null")
+	}
+	reply.Result = raw
+	return reply
+}
@@ -2,17 +2,41 @@
 package simplesub
 
 import (
+	"context"
+	"sync"
+	"time"
+
 	"github.com/assembly-hub/websocket"
+	"github.com/assembly-hub/websocket/metrics"
+	"github.com/assembly-hub/websocket/tracing"
 )
 
+// groupMsg 组内广播的消息，ctx 携带 trace 信息，key 非空时合并发送，见 SetConflation
+type groupMsg struct {
+	ctx      context.Context
+	data     []byte
+	key      string
+	interval time.Duration
+}
+
+// newGroupMsg 入队前计算合并 key，避免在 Run 中逐条解码
+func (g *simpleGroup) newGroupMsg(ctx context.Context, msg []byte) *groupMsg {
+	gm := &groupMsg{ctx: ctx, data: msg}
+	if cf := g.m.conflation(g.groupName); cf != nil {
+		gm.key, gm.interval = cf.KeyOf(msg), cf.Interval
+	}
+	return gm
+}
+
 // simpleGroup maintains the set of active clients and broadcasts messages to the
 // clients.
 type simpleGroup struct {
 	// Registered clients.
 	clients map[*websocket.Client]struct{}
+	mutex   sync.RWMutex
 
 	// Inbound messages from the clients.
-	broadcast chan []byte
+	broadcast chan *groupMsg
 
 	// Register requests from the clients.
 	register chan *websocket.Client
@@ -20,12 +44,20 @@ type simpleGroup struct {
 	// Unregister requests from clients.
 	unregister chan *websocket.Client
 
+	groupName string
+
 	// ws manager
 	m *Manage
 }
 
 func (g *simpleGroup) SendMsg(msg []byte) error {
-	g.broadcast <- msg
+	return g.SendMsgCtx(context.Background(), msg)
+}
+
+func (g *simpleGroup) SendMsgCtx(ctx context.Context, msg []byte) error {
+	_, span := tracing.StartPublish(ctx, g.groupName, len(msg))
+	defer span.End()
+	g.broadcast <- g.newGroupMsg(ctx, msg)
 	return nil
 }
 
@@ -33,24 +65,98 @@ func (g *simpleGroup) Run() {
 	for {
 		select {
 		case c := <-g.register:
+			g.mutex.Lock()
 			g.clients[c] = struct{}{}
+			n := len(g.clients)
+			g.mutex.Unlock()
+			metrics.Default.GroupSize(g.groupName, n)
+			g.m.events.Emit(websocket.Event{Type: websocket.EventClientJoined, Group: g.groupName, ClientID: c.ID, UserID: c.UserID})
 		case c := <-g.unregister:
-			if _, ok := g.clients[c]; ok {
-				delete(g.clients, c)
-				close(c.Send)
+			g.mutex.Lock()
+			removed := g.removeClient(c)
+			n := len(g.clients)
+			g.mutex.Unlock()
+			metrics.Default.GroupSize(g.groupName, n)
+			if removed {
+				g.m.events.Emit(websocket.Event{Type: websocket.EventClientLeft, Group: g.groupName, ClientID: c.ID, UserID: c.UserID})
 			}
-			if len(g.clients) <= 0 {
+			if n <= 0 {
 				g.m.delGroup(c.GroupName)
 			}
 		case message := <-g.broadcast:
+			_, span := tracing.StartFanOut(message.ctx, g.groupName)
+			dropped := false
+			g.mutex.Lock()
+			span.SetAttributes(tracing.AttrMembers.Int(len(g.clients)))
 			for c := range g.clients {
+				if message.key != "" {
+					c.SendConflated(message.key, message.data, message.interval)
+					continue
+				}
 				select {
-				case c.Send <- message:
+				case c.Send <- message.data:
 				default:
-					g.UnRegister(c)
-					c.Close()
+					// 发送队列已满，移出组并断开
+					metrics.Default.MsgDropped(g.groupName)
+					metrics.Default.SlowConsumer(g.groupName)
+					g.removeClient(c)
+					c.Terminate(websocket.NewCloseReason(websocket.CloseSlowConsumer, ""))
+					reason := websocket.CloseSlowConsumer.String()
+					g.m.events.Emit(websocket.Event{Type: websocket.EventMessageDropped, Group: g.groupName, ClientID: c.ID, UserID: c.UserID, Reason: reason})
+					g.m.events.Emit(websocket.Event{Type: websocket.EventClientLeft, Group: g.groupName, ClientID: c.ID, UserID: c.UserID, Reason: reason})
+					dropped = true
 				}
 			}
+			n := len(g.clients)
+			g.mutex.Unlock()
+			if dropped {
+				metrics.Default.GroupSize(g.groupName, n)
+			}
+			span.End()
+		}
+	}
+}
+
+// removeClient 需持有写锁，返回连接是否在组内
+func (g *simpleGroup) removeClient(c *websocket.Client) bool {
+	if _, ok := g.clients[c]; ok {
+		delete(g.clients, c)
+		c.CloseSend()
+		return true
+	}
+	return false
+}
+
+// size 组内连接数
+func (g *simpleGroup) size() int {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	return len(g.clients)
+}
+
+// clientList 组内连接的快照
+func (g *simpleGroup) clientList() []*websocket.Client {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	list := make([]*websocket.Client, 0, len(g.clients))
+	for c := range g.clients {
+		list = append(list, c)
+	}
+	return list
+}
+
+// closeAll 以指定原因关闭组内所有连接，完成关闭握手
+func (g *simpleGroup) closeAll(reason websocket.CloseReason) {
+	for _, c := range g.clientList() {
+		go c.CloseWithReason(reason)
+	}
+}
+
+// kick 关闭连接 id 或用户 id 等于 id 的连接
+func (g *simpleGroup) kick(id string, reason websocket.CloseReason) {
+	for _, c := range g.clientList() {
+		if c.ID == id || c.UserID == id {
+			go c.CloseWithReason(reason)
 		}
 	}
 }
@@ -63,13 +169,13 @@ func (g *simpleGroup) UnRegister(cli *websocket.Client) {
 	g.unregister <- cli
 }
 
-func newSimpleGroup(m *Manage) *simpleGroup {
+func newSimpleGroup(groupName string, m *Manage) *simpleGroup {
 	g := &simpleGroup{
 		// Registered clients.
 		clients: map[*websocket.Client]struct{}{},
 
 		// Inbound messages from the clients.
-		broadcast: make(chan []byte, m.groupMsgMaxLen),
+		broadcast: make(chan *groupMsg, m.groupMsgMaxLen),
 
 		// Register requests from the clients.
 		register: make(chan *websocket.Client),
@@ -77,7 +183,8 @@ func newSimpleGroup(m *Manage) *simpleGroup {
 		// Unregister requests from clients.
 		unregister: make(chan *websocket.Client),
 
-		m: m,
+		groupName: groupName,
+		m:         m,
 	}
 	go g.Run()
 	return g
@@ -0,0 +1,71 @@
+package simplesub
+
+import (
+	"context"
+	"io"
+
+	inner "github.com/assembly-hub/websocket"
+	"github.com/assembly-hub/websocket/log"
+	"github.com/assembly-hub/websocket/mailbox"
+)
+
+// SetMailbox 设置离线消息，match 为 true 的组（如以用户 id 为组名的个人组）内没有连接时，
+// SendMsg 的消息保存到 mb，下次有连接加入该组时补发。match 为 nil 时所有组都保存，一般使用 mailbox.NewMemory
+func (m *Manage) SetMailbox(mb mailbox.Mailbox, match func(groupName string) bool) {
+	m.mailbox = mb
+	m.mailboxMatch = match
+}
+
+// Mailbox 当前的离线消息，可用于查看、清空某个组的离线消息
+func (m *Manage) Mailbox() mailbox.Mailbox {
+	return m.mailbox
+}
+
+// online 组内是否有连接
+func (m *Manage) online(groupName string) bool {
+	group := m.groups()[groupName]
+	return group != nil && group.size() > 0
+}
+
+// keep 组不在线时保存消息，返回是否已保存
+func (m *Manage) keep(ctx context.Context, groupName string, msg string) bool {
+	mb := m.mailbox
+	if mb == nil || (m.mailboxMatch != nil && !m.mailboxMatch(groupName)) || m.online(groupName) {
+		return false
+	}
+	if err := mb.Push(ctx, groupName, []byte(msg)); err != nil {
+		m.getLogger().Event(ctx, log.EventMailboxError, "save offline message failed", log.F("group", groupName), log.Err(err))
+		return false
+	}
+	return true
+}
+
+// closeMailbox 服务关闭时停止离线消息的后台任务（如 mailbox.Memory 的清理）
+func (m *Manage) closeMailbox() {
+	if c, ok := m.mailbox.(io.Closer); ok {
+		_ = c.Close()
+	}
+}
+
+// drainMailbox 补发离线消息，需在连接加入组之前调用，保证离线消息先于组内的新消息发送。
+// 发送队列放不下的消息放回，下次有连接加入该组时补发
+func (m *Manage) drainMailbox(c *inner.Client) {
+	mb := m.mailbox
+	if mb == nil {
+		return
+	}
+	ctx := c.Context()
+	msgs, err := mb.Drain(ctx, c.GroupName)
+	if err != nil {
+		m.getLogger().Event(ctx, log.EventMailboxError, "drain offline messages failed", log.F("group", c.GroupName), log.Err(err))
+		return
+	}
+	for i, msg := range msgs {
+		if !c.TrySend(msg) {
+			for _, rest := range msgs[i:] {
+				_ = mb.Push(context.Background(), c.GroupName, rest)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package simplesub
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	inner "github.com/assembly-hub/websocket"
+	"github.com/assembly-hub/websocket/mailbox"
+	"github.com/assembly-hub/websocket/metrics"
+)
+
+// startServer 连接加入 groupName，OnClose 的原因写入返回的 chan
+func startServer(t *testing.T, m *Manage, groupName string) (string, <-chan inner.CloseReason) {
+	t.Helper()
+	ch := make(chan inner.CloseReason, 4)
+	h := inner.HandlerFuncs{
+		Close: func(ctx context.Context, c *inner.Client, reason inner.CloseReason) {
+			ch <- reason
+		},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := inner.WithUserID(r.Context(), r.URL.Query().Get("user"))
+		_ = m.AddGroupWithHandler(groupName, w, r.WithContext(ctx), h)
+	}))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http"), ch
+}
+
+func dial(t *testing.T, url string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	// 读取并回应关闭帧
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	return conn
+}
+
+func waitReason(t *testing.T, ch <-chan inner.CloseReason) inner.CloseReason {
+	t.Helper()
+	select {
+	case r := <-ch:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnClose not called")
+		return inner.CloseReason{}
+	}
+}
+
+// waitMembers 等待组内连接数为 n
+func waitMembers(t *testing.T, m *Manage, groupName string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if len(m.Clients(groupName)) == n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("group %s does not have %d members", groupName, n)
+}
+
+func TestKickCloseReason(t *testing.T) {
+	m := NewManager()
+	url, ch := startServer(t, m, "g")
+	dial(t, url+"?user=u1")
+	waitMembers(t, m, "g", 1)
+
+	if err := m.Kick("u1", "spam"); err != nil {
+		t.Fatal(err)
+	}
+	r := waitReason(t, ch)
+	if r.Kind != inner.CloseKicked || r.Text != "spam" {
+		t.Fatalf("got %+v", r)
+	}
+}
+
+func TestShutdownCloseReason(t *testing.T) {
+	m := NewManager()
+	url, ch := startServer(t, m, "g")
+	dial(t, url)
+	waitMembers(t, m, "g", 1)
+
+	m.Shutdown()
+	if r := waitReason(t, ch); r.Kind != inner.CloseServerShutdown || r.Code != websocket.CloseGoingAway {
+		t.Fatalf("got %+v", r)
+	}
+}
+
+func TestSlowConsumerCloseReason(t *testing.T) {
+	m := NewManager()
+	m.SetMaxMsgLength(1)
+	release := make(chan struct{})
+	ch := make(chan inner.CloseReason, 1)
+	h := inner.HandlerFuncs{
+		Close: func(ctx context.Context, c *inner.Client, reason inner.CloseReason) {
+			ch <- reason
+		},
+	}
+	// deliver 阻塞，发送队列（3 条）很快写满
+	_, err := m.SubscribeStream(context.Background(), "g", func(msg []byte) { <-release }, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitMembers(t, m, "g", 1)
+
+	for i := 0; i < 10; i++ {
+		_ = m.SendMsg("g", "x")
+	}
+	close(release)
+	if r := waitReason(t, ch); r.Kind != inner.CloseSlowConsumer {
+		t.Fatalf("got %+v", r)
+	}
+}
+
+// sizeMetrics 记录最后一次 GroupSize
+type sizeMetrics struct {
+	metrics.Metrics
+	sizes chan int
+}
+
+func (m sizeMetrics) GroupSize(group string, n int) {
+	m.sizes <- n
+}
+
+func TestSlowConsumerGroupSize(t *testing.T) {
+	rec := sizeMetrics{Metrics: metrics.NoMetrics, sizes: make(chan int, 16)}
+	metrics.SetDefMetrics(rec)
+	defer metrics.SetDefMetrics(nil)
+
+	m := NewManager()
+	m.SetMaxMsgLength(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	release := make(chan struct{})
+	// 先结束订阅，再恢复全局指标
+	defer func() {
+		cancel()
+		close(release)
+		waitMembers(t, m, "g", 0)
+	}()
+	if _, err := m.SubscribeStream(ctx, "g", func(msg []byte) { <-release }, nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := <-rec.sizes; n != 1 {
+		t.Fatalf("size after join %d", n)
+	}
+	for i := 0; i < 10; i++ {
+		_ = m.SendMsg("g", "x")
+	}
+	select {
+	case n := <-rec.sizes:
+		if n != 0 {
+			t.Fatalf("size after slow consumer %d", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("GroupSize not updated")
+	}
+}
+
+func TestMailboxReplayOrder(t *testing.T) {
+	m := NewManager()
+	m.SetMailbox(mailbox.NewMemory(100, time.Minute), nil)
+	for _, msg := range []string{"1", "2"} {
+		if err := m.SendMsg("g", msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := make(chan string, 8)
+	if _, err := m.SubscribeStream(context.Background(), "g", func(msg []byte) { got <- string(msg) }, nil); err != nil {
+		t.Fatal(err)
+	}
+	waitMembers(t, m, "g", 1)
+	if err := m.SendMsg("g", "3"); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"1", "2", "3"} {
+		select {
+		case msg := <-got:
+			if msg != want {
+				t.Fatalf("got %q, want %q", msg, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%q not delivered", want)
+		}
+	}
+}
+
+func TestMailboxReplayOverflow(t *testing.T) {
+	m := NewManager()
+	m.SetMaxMsgLength(1)
+	mb := mailbox.NewMemory(100, time.Minute)
+	m.SetMailbox(mb, nil)
+	for i := 0; i < 5; i++ {
+		_ = m.SendMsg("g", "x")
+	}
+
+	release := make(chan struct{})
+	defer close(release)
+	if _, err := m.SubscribeStream(context.Background(), "g", func(msg []byte) { <-release }, nil); err != nil {
+		t.Fatal(err)
+	}
+	// 发送队列为 3 条，其余放回
+	if n, err := mb.Len(context.Background(), "g"); err != nil || n != 2 {
+		t.Fatalf("left %d, %v", n, err)
+	}
+}
+
+// subscribe 以 SubscribeStream 加入组，收到的消息写入返回的 chan
+func subscribe(t *testing.T, m *Manage, groupName string) <-chan string {
+	t.Helper()
+	got := make(chan string, 8)
+	if _, err := m.SubscribeStream(context.Background(), groupName, func(msg []byte) { got <- string(msg) }, nil); err != nil {
+		t.Fatal(err)
+	}
+	waitMembers(t, m, groupName, 1)
+	return got
+}
+
+func TestTopicWildcards(t *testing.T) {
+	tests := []struct {
+		name    string
+		enable  bool
+		topic   string
+		deliver bool
+	}{
+		{"disabled literal name", false, "a.*", true},
+		{"disabled no match", false, "a.b", false},
+		{"enabled match", true, "a.b", true},
+		{"enabled no match", true, "b.c", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewManager()
+			m.SetTopicWildcards(tt.enable)
+			got := subscribe(t, m, "a.*")
+			if err := m.SendMsg(tt.topic, "x"); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case <-got:
+				if !tt.deliver {
+					t.Fatalf("%s delivered to a.*", tt.topic)
+				}
+			case <-time.After(100 * time.Millisecond):
+				if tt.deliver {
+					t.Fatalf("%s not delivered to a.*", tt.topic)
+				}
+			}
+		})
+	}
+}
+
+func TestConflationKeyOnEnqueue(t *testing.T) {
+	m := NewManager()
+	calls := 0
+	m.SetConflation("g", &inner.Conflation{
+		Key:      func(msg []byte) string { calls++; return string(msg) },
+		Interval: time.Second,
+	})
+	g := newSimpleGroup("g", m)
+	if err := g.SendMsg([]byte("k")); err != nil {
+		t.Fatal(err)
+	}
+	gm := <-g.broadcast
+	if calls != 1 || gm.key != "k" || gm.interval != time.Second {
+		t.Fatalf("calls %d, got %+v", calls, gm)
+	}
+}
+
+func TestBanDuration(t *testing.T) {
+	m := NewManager()
+	for _, d := range []time.Duration{0, -time.Minute} {
+		if err := m.Ban("u1", "g", d); err == nil {
+			t.Fatalf("ban %v accepted", d)
+		}
+	}
+	if m.isBanned("u1", "g") {
+		t.Fatal("rejected ban recorded")
+	}
+	if err := m.Ban("u1", "g", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if !m.isBanned("u1", "g") || m.isBanned("u1", "h") {
+		t.Fatal("ban not scoped to group")
+	}
+}
+
+func TestMailboxMatch(t *testing.T) {
+	m := NewManager()
+	mb := mailbox.NewMemory(100, time.Minute)
+	m.SetMailbox(mb, func(groupName string) bool { return strings.HasPrefix(groupName, "user:") })
+	_ = m.SendMsg("user:1", "x")
+	_ = m.SendMsg("room", "x")
+	if n, _ := mb.Len(context.Background(), "user:1"); n != 1 {
+		t.Fatalf("user group kept %d", n)
+	}
+	if n, _ := mb.Len(context.Background(), "room"); n != 0 {
+		t.Fatalf("unmatched group kept %d", n)
+	}
+	m.Shutdown()
+}
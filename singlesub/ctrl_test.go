@@ -0,0 +1,27 @@
+package singlesub
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBanDuration(t *testing.T) {
+	tests := []struct {
+		name string
+		d    time.Duration
+		err  string
+	}{
+		{"zero", 0, "ban duration must be positive"},
+		{"negative", -time.Minute, "ban duration must be positive"},
+		// 时间有效时才检查 redis
+		{"positive", time.Minute, "redis is nil"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Manage{}
+			if err := m.Ban("u1", "g", tt.d); err == nil || err.Error() != tt.err {
+				t.Fatalf("got %v, want %s", err, tt.err)
+			}
+		})
+	}
+}
@@ -2,17 +2,41 @@
 package singlesub
 
 import (
+	"context"
+	"sync"
+	"time"
+
 	"github.com/assembly-hub/websocket"
+	"github.com/assembly-hub/websocket/metrics"
+	"github.com/assembly-hub/websocket/tracing"
 )
 
+// groupMsg 组内广播的消息，ctx 携带 trace 信息，key 非空时合并发送，见 SetConflation
+type groupMsg struct {
+	ctx      context.Context
+	data     []byte
+	key      string
+	interval time.Duration
+}
+
+// newGroupMsg 入队前计算合并 key，避免在 Run 中逐条解码
+func (g *redisGroup) newGroupMsg(ctx context.Context, msg []byte) *groupMsg {
+	gm := &groupMsg{ctx: ctx, data: msg}
+	if cf := g.m.conflation(g.groupName); cf != nil {
+		gm.key, gm.interval = cf.KeyOf(msg), cf.Interval
+	}
+	return gm
+}
+
 // redisGroup maintains the set of active clients and broadcasts messages to the
 // clients.
 type redisGroup struct {
 	// Registered clients.
 	clients map[*websocket.Client]struct{}
+	mutex   sync.RWMutex
 
 	// Inbound messages from the clients.
-	broadcast chan []byte
+	broadcast chan *groupMsg
 
 	// Register requests from the clients.
 	register chan *websocket.Client
@@ -25,36 +49,114 @@ type redisGroup struct {
 	m *Manage
 }
 
-func (g *redisGroup) sendData(msg []byte) {
-	g.broadcast <- msg
+func (g *redisGroup) sendData(ctx context.Context, msg []byte) {
+	g.broadcast <- g.newGroupMsg(ctx, msg)
 }
 
 func (g *redisGroup) SendMsg(msg []byte) error {
-	return g.m.sendMsg(g.groupName, string(msg))
+	return g.SendMsgCtx(context.Background(), msg)
+}
+
+func (g *redisGroup) SendMsgCtx(ctx context.Context, msg []byte) error {
+	return g.m.sendMsg(ctx, g.groupName, string(msg))
 }
 
 func (g *redisGroup) Run() {
 	for {
 		select {
 		case c := <-g.register:
+			g.mutex.Lock()
 			g.clients[c] = struct{}{}
+			n := len(g.clients)
+			g.mutex.Unlock()
+			metrics.Default.GroupSize(g.groupName, n)
+			g.m.events.Emit(websocket.Event{Type: websocket.EventClientJoined, Group: g.groupName, ClientID: c.ID, UserID: c.UserID})
 		case c := <-g.unregister:
-			if _, ok := g.clients[c]; ok {
-				delete(g.clients, c)
-				close(c.Send)
+			g.mutex.Lock()
+			removed := g.removeClient(c)
+			n := len(g.clients)
+			g.mutex.Unlock()
+			metrics.Default.GroupSize(g.groupName, n)
+			if removed {
+				g.m.events.Emit(websocket.Event{Type: websocket.EventClientLeft, Group: g.groupName, ClientID: c.ID, UserID: c.UserID})
 			}
-			if len(g.clients) <= 0 {
+			if n <= 0 {
 				g.m.delGroup(c.GroupName)
 			}
 		case message := <-g.broadcast:
+			_, span := tracing.StartFanOut(message.ctx, g.groupName)
+			dropped := false
+			g.mutex.Lock()
+			span.SetAttributes(tracing.AttrMembers.Int(len(g.clients)))
 			for c := range g.clients {
+				if message.key != "" {
+					c.SendConflated(message.key, message.data, message.interval)
+					continue
+				}
 				select {
-				case c.Send <- message:
+				case c.Send <- message.data:
 				default:
-					g.UnRegister(c)
-					c.Close()
+					// 发送队列已满，移出组并断开
+					metrics.Default.MsgDropped(g.groupName)
+					metrics.Default.SlowConsumer(g.groupName)
+					g.removeClient(c)
+					c.Terminate(websocket.NewCloseReason(websocket.CloseSlowConsumer, ""))
+					reason := websocket.CloseSlowConsumer.String()
+					g.m.events.Emit(websocket.Event{Type: websocket.EventMessageDropped, Group: g.groupName, ClientID: c.ID, UserID: c.UserID, Reason: reason})
+					g.m.events.Emit(websocket.Event{Type: websocket.EventClientLeft, Group: g.groupName, ClientID: c.ID, UserID: c.UserID, Reason: reason})
+					dropped = true
 				}
 			}
+			n := len(g.clients)
+			g.mutex.Unlock()
+			if dropped {
+				metrics.Default.GroupSize(g.groupName, n)
+			}
+			span.End()
+		}
+	}
+}
+
+// removeClient 需持有写锁，返回连接是否在组内
+func (g *redisGroup) removeClient(c *websocket.Client) bool {
+	if _, ok := g.clients[c]; ok {
+		delete(g.clients, c)
+		c.CloseSend()
+		return true
+	}
+	return false
+}
+
+// size 组内连接数
+func (g *redisGroup) size() int {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	return len(g.clients)
+}
+
+// clientList 组内连接的快照
+func (g *redisGroup) clientList() []*websocket.Client {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	list := make([]*websocket.Client, 0, len(g.clients))
+	for c := range g.clients {
+		list = append(list, c)
+	}
+	return list
+}
+
+// closeAll 以指定原因关闭组内所有连接，完成关闭握手
+func (g *redisGroup) closeAll(reason websocket.CloseReason) {
+	for _, c := range g.clientList() {
+		go c.CloseWithReason(reason)
+	}
+}
+
+// kick 关闭连接 id 或用户 id 等于 id 的连接
+func (g *redisGroup) kick(id string, reason websocket.CloseReason) {
+	for _, c := range g.clientList() {
+		if c.ID == id || c.UserID == id {
+			go c.CloseWithReason(reason)
 		}
 	}
 }
@@ -73,7 +175,7 @@ func newRedisGroup(groupName string, m *Manage) *redisGroup {
 		clients: map[*websocket.Client]struct{}{},
 
 		// Inbound messages from the clients.
-		broadcast: make(chan []byte, m.groupMsgMaxLen),
+		broadcast: make(chan *groupMsg, m.groupMsgMaxLen),
 
 		// Register requests from the clients.
 		register: make(chan *websocket.Client),
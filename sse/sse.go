@@ -0,0 +1,179 @@
+// Package sse Server-Sent Events 传输，用于代理拦截 WebSocket 升级等无法建立 ws 连接的环境。
+// 每个事件流是一个 transport.Session，以 websocket.NewStreamClient 创建的连接加入组，
+// 管理器的 SendMsg 同时送达 ws 和 SSE 连接；
+// 客户端通过配套的 POST 请求发送消息，消息交给 Handler 的 OnMessage，与 ws 连接一致。
+//
+// 事件流断开后在 ResumeTTL 内仍留在组内并缓存消息，EventSource 带 Last-Event-ID 重连时补发。
+// SSE 只能承载文本，组内消息须为 UTF-8 文本
+package sse
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/assembly-hub/websocket"
+	"github.com/assembly-hub/websocket/transport"
+)
+
+const (
+	// EventStream 连接建立后发送的第一个事件，data 为事件流 id，POST 消息时使用
+	EventStream = "stream"
+	// HeaderStreamID POST 请求中携带事件流 id 的 header，也可以使用 stream 参数
+	HeaderStreamID = "X-Stream-ID"
+)
+
+// Server SSE 传输，GET 请求建立事件流，POST 请求发送消息。
+// 会话的缓存、保留时间、Handler 等配置见 transport.Sessions
+type Server struct {
+	*transport.Sessions
+
+	// Group 从请求中获取组名，返回 error 时响应 400
+	Group func(r *http.Request) (string, error)
+	// Heartbeat 发送注释行保活的间隔，防止代理断开空闲连接
+	Heartbeat time.Duration
+	// Retry 建议客户端重连的间隔
+	Retry time.Duration
+}
+
+// NewServer 创建 SSE 传输，sub 一般为 simplesub、singlesub、multisub 的 Manage
+func NewServer(sub websocket.StreamSubscriber, group func(r *http.Request) (string, error)) *Server {
+	return &Server{
+		Sessions:  transport.NewSessions(sub, websocket.TransportSSE),
+		Group:     group,
+		Heartbeat: 15 * time.Second,
+		Retry:     3 * time.Second,
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.Stream(w, r)
+	case http.MethodPost:
+		s.Publish(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// Stream 建立或接续事件流，组内消息作为 message 事件发送，事件 id 为 "事件流 id:序号"
+func (s *Server) Stream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	group, err := s.Group(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	st, last := s.resume(r, group)
+	if st == nil {
+		if st, err = s.Open(r, group); err != nil {
+			http.Error(w, err.Error(), transport.OpenError(err))
+			return
+		}
+	}
+	attached := s.Attach(st)
+	defer s.Detach(st, attached)
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	buf := &bytes.Buffer{}
+	if s.Retry > 0 {
+		fmt.Fprintf(buf, "retry: %d\n\n", s.Retry.Milliseconds())
+	}
+	fmt.Fprintf(buf, "id: %s:%d\nevent: %s\ndata: %s\n\n", st.ID(), last, EventStream, st.ID())
+
+	var heartbeat <-chan time.Time
+	if s.Heartbeat > 0 {
+		ticker := time.NewTicker(s.Heartbeat)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+	for {
+		msgs, _ := st.Since(last)
+		for _, msg := range msgs {
+			writeEvent(buf, st.ID(), msg)
+			last = msg.Seq
+		}
+		if buf.Len() > 0 {
+			if _, err = w.Write(buf.Bytes()); err != nil {
+				return
+			}
+			buf.Reset()
+			flusher.Flush()
+		}
+
+		select {
+		case <-st.Notify():
+		case <-heartbeat:
+			buf.WriteString(": ping\n\n")
+		case <-attached:
+			// 被新的请求接续
+			return
+		case <-st.Done():
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// Publish 客户端发送消息，请求体作为一条消息交给事件流连接的 Handler
+func (s *Server) Publish(w http.ResponseWriter, r *http.Request) {
+	id := r.Header.Get(HeaderStreamID)
+	if id == "" {
+		id = r.URL.Query().Get("stream")
+	}
+	st := s.Get(r, id)
+	if st == nil {
+		http.Error(w, "stream not found", http.StatusNotFound)
+		return
+	}
+	s.Receive(w, r, st)
+}
+
+// resume 按 Last-Event-ID 查找可接续的事件流
+func (s *Server) resume(r *http.Request, group string) (*transport.Session, uint64) {
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("lastEventId")
+	}
+	i := strings.LastIndexByte(lastID, ':')
+	if i < 0 {
+		return nil, 0
+	}
+	seq, err := strconv.ParseUint(lastID[i+1:], 10, 64)
+	if err != nil {
+		return nil, 0
+	}
+
+	st := s.Get(r, lastID[:i])
+	if st == nil || st.Client().GroupName != group {
+		return nil, 0
+	}
+	return st, seq
+}
+
+func writeEvent(buf *bytes.Buffer, id string, msg transport.Message) {
+	fmt.Fprintf(buf, "id: %s:%d\n", id, msg.Seq)
+	data := bytes.ReplaceAll(msg.Data, []byte("\r\n"), []byte("\n"))
+	for _, line := range bytes.Split(bytes.ReplaceAll(data, []byte("\r"), []byte("\n")), []byte("\n")) {
+		buf.WriteString("data: ")
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+}
@@ -0,0 +1,258 @@
+// Package stomp STOMP 1.2 over WebSocket，SUBSCRIBE、SEND 的 destination 映射为管理器的组
+//
+// 协议见 https://stomp.github.io/stomp-specification-1.2.html
+package stomp
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Subprotocol Sec-WebSocket-Protocol 中的子协议名
+const Subprotocol = "v12.stomp"
+
+// 客户端命令
+const (
+	CmdConnect     = "CONNECT"
+	CmdStomp       = "STOMP"
+	CmdSend        = "SEND"
+	CmdSubscribe   = "SUBSCRIBE"
+	CmdUnsubscribe = "UNSUBSCRIBE"
+	CmdAck         = "ACK"
+	CmdNack        = "NACK"
+	CmdBegin       = "BEGIN"
+	CmdCommit      = "COMMIT"
+	CmdAbort       = "ABORT"
+	CmdDisconnect  = "DISCONNECT"
+)
+
+// 服务端命令
+const (
+	CmdConnected = "CONNECTED"
+	CmdMessage   = "MESSAGE"
+	CmdReceipt   = "RECEIPT"
+	CmdError     = "ERROR"
+)
+
+// 常用 header
+const (
+	HdrAcceptVersion = "accept-version"
+	HdrVersion       = "version"
+	HdrHost          = "host"
+	HdrLogin         = "login"
+	HdrPasscode      = "passcode"
+	HdrHeartBeat     = "heart-beat"
+	HdrSession       = "session"
+	HdrServer        = "server"
+	HdrDestination   = "destination"
+	HdrID            = "id"
+	HdrAck           = "ack"
+	HdrSubscription  = "subscription"
+	HdrMessageID     = "message-id"
+	HdrReceipt       = "receipt"
+	HdrReceiptID     = "receipt-id"
+	HdrTransaction   = "transaction"
+	HdrContentType   = "content-type"
+	HdrContentLength = "content-length"
+	HdrMessage       = "message"
+)
+
+var (
+	ErrInvalidFrame = errors.New("stomp: invalid frame")
+	ErrNoNull       = errors.New("stomp: frame not terminated by NULL")
+)
+
+// Header 有序的 header，重复的 key 以第一个为准
+type Header [][2]string
+
+// Get 获取 header，不存在时返回空字符串
+func (h Header) Get(key string) string {
+	v, _ := h.Lookup(key)
+	return v
+}
+
+// Lookup 获取 header 及是否存在
+func (h Header) Lookup(key string) (string, bool) {
+	for _, kv := range h {
+		if kv[0] == key {
+			return kv[1], true
+		}
+	}
+	return "", false
+}
+
+// Set 设置 header，已存在时替换第一个
+func (h *Header) Set(key, value string) {
+	for i, kv := range *h {
+		if kv[0] == key {
+			(*h)[i][1] = value
+			return
+		}
+	}
+	*h = append(*h, [2]string{key, value})
+}
+
+// Frame STOMP 帧
+type Frame struct {
+	Command string
+	Header  Header
+	Body    []byte
+}
+
+// NewFrame 创建帧，kv 为成对的 header
+func NewFrame(command string, kv ...string) *Frame {
+	f := &Frame{Command: command}
+	for i := 0; i+1 < len(kv); i += 2 {
+		f.Header.Set(kv[i], kv[i+1])
+	}
+	return f
+}
+
+// escaped CONNECT、CONNECTED 帧的 header 不转义
+func escaped(command string) bool {
+	return command != CmdConnect && command != CmdConnected
+}
+
+var (
+	escaper   = strings.NewReplacer(`\`, `\\`, "\r", `\r`, "\n", `\n`, ":", `\c`)
+	unescaper = strings.NewReplacer(`\\`, `\`, `\r`, "\r", `\n`, "\n", `\c`, ":")
+)
+
+// Bytes 编码帧，有 body 时自动带 content-length
+func (f *Frame) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(f.Command)
+	buf.WriteByte('\n')
+
+	esc := escaped(f.Command)
+	h := f.Header
+	if len(f.Body) > 0 {
+		if _, ok := h.Lookup(HdrContentLength); !ok {
+			h = append(h[:len(h):len(h)], [2]string{HdrContentLength, strconv.Itoa(len(f.Body))})
+		}
+	}
+	for _, kv := range h {
+		k, v := kv[0], kv[1]
+		if esc {
+			k, v = escaper.Replace(k), escaper.Replace(v)
+		}
+		buf.WriteString(k)
+		buf.WriteByte(':')
+		buf.WriteString(v)
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+	buf.Write(f.Body)
+	buf.WriteByte(0)
+	return buf.Bytes()
+}
+
+// Parse 解析一条 ws 消息中的所有帧，帧之间及单独的换行为心跳，返回空列表
+func Parse(data []byte) ([]*Frame, error) {
+	var frames []*Frame
+	for {
+		data = trimEOL(data)
+		if len(data) == 0 {
+			return frames, nil
+		}
+		f, rest, err := parseFrame(data)
+		if err != nil {
+			return frames, err
+		}
+		frames = append(frames, f)
+		data = rest
+	}
+}
+
+func trimEOL(data []byte) []byte {
+	for len(data) > 0 && (data[0] == '\n' || data[0] == '\r') {
+		data = data[1:]
+	}
+	return data
+}
+
+func readLine(data []byte) (line, rest []byte, ok bool) {
+	i := bytes.IndexByte(data, '\n')
+	if i < 0 {
+		return nil, nil, false
+	}
+	line = data[:i]
+	if n := len(line); n > 0 && line[n-1] == '\r' {
+		line = line[:n-1]
+	}
+	return line, data[i+1:], true
+}
+
+func parseFrame(data []byte) (*Frame, []byte, error) {
+	line, data, ok := readLine(data)
+	if !ok || len(line) == 0 {
+		return nil, nil, ErrInvalidFrame
+	}
+	f := &Frame{Command: string(line)}
+	esc := escaped(f.Command)
+
+	for {
+		line, data, ok = readLine(data)
+		if !ok {
+			return nil, nil, ErrInvalidFrame
+		}
+		if len(line) == 0 {
+			break
+		}
+		i := bytes.IndexByte(line, ':')
+		if i < 0 {
+			return nil, nil, ErrInvalidFrame
+		}
+		k, v := string(line[:i]), string(line[i+1:])
+		if esc {
+			if !validEscape(k) || !validEscape(v) {
+				return nil, nil, ErrInvalidFrame
+			}
+			k, v = unescaper.Replace(k), unescaper.Replace(v)
+		}
+		// 重复的 header 以第一个为准
+		if _, exists := f.Header.Lookup(k); !exists {
+			f.Header = append(f.Header, [2]string{k, v})
+		}
+	}
+
+	if cl, ok := f.Header.Lookup(HdrContentLength); ok {
+		n, err := strconv.Atoi(cl)
+		if err != nil || n < 0 {
+			return nil, nil, ErrInvalidFrame
+		}
+		if len(data) < n+1 || data[n] != 0 {
+			return nil, nil, ErrNoNull
+		}
+		f.Body = data[:n]
+		return f, data[n+1:], nil
+	}
+
+	i := bytes.IndexByte(data, 0)
+	if i < 0 {
+		return nil, nil, ErrNoNull
+	}
+	f.Body = data[:i]
+	return f, data[i+1:], nil
+}
+
+// validEscape 只允许 \r \n \c \\ 四种转义
+func validEscape(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			continue
+		}
+		if i+1 >= len(s) {
+			return false
+		}
+		switch s[i+1] {
+		case 'r', 'n', 'c', '\\':
+			i++
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,153 @@
+package stomp
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name   string
+		in     string
+		frames []*Frame
+		err    error
+	}{
+		{
+			name:   "body until NULL",
+			in:     "SEND\ndestination:/a\n\nhello\x00",
+			frames: []*Frame{{Command: CmdSend, Header: Header{{HdrDestination, "/a"}}, Body: []byte("hello")}},
+		},
+		{
+			name:   "CRLF EOL",
+			in:     "SEND\r\ndestination:/a\r\n\r\nhi\x00",
+			frames: []*Frame{{Command: CmdSend, Header: Header{{HdrDestination, "/a"}}, Body: []byte("hi")}},
+		},
+		{
+			name: "heart-beat only",
+			in:   "\n\r\n\n",
+		},
+		{
+			name: "heart-beats around frames",
+			in:   "\nSEND\ndestination:/a\n\n\x00\r\n\nSEND\ndestination:/b\n\n\x00\n",
+			frames: []*Frame{
+				{Command: CmdSend, Header: Header{{HdrDestination, "/a"}}, Body: []byte{}},
+				{Command: CmdSend, Header: Header{{HdrDestination, "/b"}}, Body: []byte{}},
+			},
+		},
+		{
+			name:   "escaped header",
+			in:     "SEND\ndestination:/a\\cb\\nc\\\\d\\r\n\n\x00",
+			frames: []*Frame{{Command: CmdSend, Header: Header{{HdrDestination, "/a:b\nc\\d\r"}}, Body: []byte{}}},
+		},
+		{
+			name:   "CONNECT header not unescaped",
+			in:     "CONNECT\nlogin:a\\cb\n\n\x00",
+			frames: []*Frame{{Command: CmdConnect, Header: Header{{HdrLogin, "a\\cb"}}, Body: []byte{}}},
+		},
+		{
+			name: "invalid escape",
+			in:   "SEND\ndestination:/a\\t\n\n\x00",
+			err:  ErrInvalidFrame,
+		},
+		{
+			name:   "content-length with NULL in body",
+			in:     "SEND\ncontent-length:3\n\na\x00b\x00",
+			frames: []*Frame{{Command: CmdSend, Header: Header{{HdrContentLength, "3"}}, Body: []byte("a\x00b")}},
+		},
+		{
+			name: "content-length longer than body",
+			in:   "SEND\ncontent-length:5\n\nab\x00",
+			err:  ErrNoNull,
+		},
+		{
+			name: "invalid content-length",
+			in:   "SEND\ncontent-length:x\n\nab\x00",
+			err:  ErrInvalidFrame,
+		},
+		{
+			name: "missing NULL",
+			in:   "SEND\ndestination:/a\n\nhello",
+			err:  ErrNoNull,
+		},
+		{
+			name: "header without colon",
+			in:   "SEND\ndestination\n\n\x00",
+			err:  ErrInvalidFrame,
+		},
+		{
+			name:   "repeated header keeps first",
+			in:     "SEND\nfoo:1\nfoo:2\n\n\x00",
+			frames: []*Frame{{Command: CmdSend, Header: Header{{"foo", "1"}}, Body: []byte{}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frames, err := Parse([]byte(tt.in))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err %v, want %v", err, tt.err)
+			}
+			if tt.err != nil {
+				return
+			}
+			if !reflect.DeepEqual(frames, tt.frames) {
+				t.Fatalf("got %+v, want %+v", frames, tt.frames)
+			}
+		})
+	}
+}
+
+func TestBytes(t *testing.T) {
+	tests := []struct {
+		name string
+		f    *Frame
+		want string
+	}{
+		{
+			name: "no body",
+			f:    NewFrame(CmdReceipt, HdrReceiptID, "r1"),
+			want: "RECEIPT\nreceipt-id:r1\n\n\x00",
+		},
+		{
+			name: "content-length added",
+			f:    &Frame{Command: CmdMessage, Header: Header{{HdrDestination, "/a"}}, Body: []byte("a\x00b")},
+			want: "MESSAGE\ndestination:/a\ncontent-length:3\n\na\x00b\x00",
+		},
+		{
+			name: "content-length kept",
+			f:    &Frame{Command: CmdMessage, Header: Header{{HdrContentLength, "2"}}, Body: []byte("ab")},
+			want: "MESSAGE\ncontent-length:2\n\nab\x00",
+		},
+		{
+			name: "escaped header",
+			f:    NewFrame(CmdError, HdrMessage, "a:b\nc\\d\r"),
+			want: "ERROR\nmessage:a\\cb\\nc\\\\d\\r\n\n\x00",
+		},
+		{
+			name: "CONNECTED header not escaped",
+			f:    NewFrame(CmdConnected, HdrServer, "a:b"),
+			want: "CONNECTED\nserver:a:b\n\n\x00",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(tt.f.Bytes()); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBytesParseRoundTrip(t *testing.T) {
+	f := NewFrame(CmdMessage, HdrDestination, "/a:b", "x\\y", "1\n2")
+	f.Body = []byte("hello\x00world")
+	frames, err := Parse(f.Bytes())
+	if err != nil || len(frames) != 1 {
+		t.Fatalf("got %v, %v", frames, err)
+	}
+	got := frames[0]
+	if got.Command != f.Command || string(got.Body) != string(f.Body) ||
+		got.Header.Get(HdrDestination) != "/a:b" || got.Header.Get("x\\y") != "1\n2" {
+		t.Fatalf("got %+v", got)
+	}
+}
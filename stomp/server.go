@@ -0,0 +1,525 @@
+package stomp
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/assembly-hub/websocket"
+)
+
+// ack 模式
+const (
+	AckAuto             = "auto"
+	AckClient           = "client"
+	AckClientIndividual = "client-individual"
+)
+
+var heartBeat = []byte{'\n'}
+
+// Publisher 可向组发送消息的管理器，simplesub、singlesub、multisub 的 Manage 均已实现
+type Publisher interface {
+	SendMsgCtx(ctx context.Context, groupName string, msg string) error
+}
+
+// Server STOMP 1.2 的 Handler，连接建立、关闭、出错交给嵌入的 Handler
+type Server struct {
+	websocket.Handler
+
+	// Subscriber SUBSCRIBE 时订阅组的管理器
+	Subscriber websocket.Subscriber
+	// Publisher SEND 时发送到组的管理器
+	Publisher Publisher
+	// Destination 将 destination 映射为组名，返回 error 时回复 ERROR，为 nil 时直接作为组名
+	Destination func(dest string) (string, error)
+	// Authenticate 校验 CONNECT 帧（login、passcode 等），返回 error 时回复 ERROR 并关闭连接
+	Authenticate func(ctx context.Context, c *websocket.Client, f *Frame) error
+	// OnAck client、client-individual 模式下消息被 ACK（ack 为 true）或 NACK 时调用
+	OnAck func(c *websocket.Client, subscription, messageID string, ack bool)
+	// HeartBeat 服务端的心跳设置：[0] 服务端能发送心跳的最小间隔，[1] 希望收到客户端心跳的间隔，0 表示不需要
+	HeartBeat [2]time.Duration
+	// Name CONNECTED 帧中的 server
+	Name string
+	// ReadLimit 接收消息的最大长度，超过时关闭连接，0 使用连接的默认值（512 字节）
+	ReadLimit int64
+	// MaxPending client、client-individual 模式下每个订阅等待 ACK 的消息上限，超过时按慢消费者断开连接，0 表示不限制
+	MaxPending int
+	// MaxTransactions 每个连接同时进行的事务上限，超过时回复 ERROR，0 表示不限制
+	MaxTransactions int
+	// MaxTxFrames 每个事务缓存的帧数上限，超过时回复 ERROR，0 表示不限制
+	MaxTxFrames int
+
+	mutex    sync.Mutex
+	sessions map[*websocket.Client]*session
+}
+
+type subscription struct {
+	id    string
+	dest  string
+	ack   string
+	v     *websocket.Client
+	mutex sync.Mutex
+	// 等待 ACK 的 message-id，按发送顺序
+	pending []string
+}
+
+type session struct {
+	mutex     sync.Mutex
+	connected bool
+	subs      map[string]*subscription
+	txs       map[string][]*Frame
+	seq       atomic.Uint64
+	lastRecv  atomic.Int64
+	stop      chan struct{}
+	stopOnce  sync.Once
+}
+
+func (s *session) close() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+// NewServer 创建 STOMP 服务，m 一般为 simplesub、singlesub、multisub 的 Manage
+func NewServer(m interface {
+	websocket.Subscriber
+	Publisher
+}) *Server {
+	return &Server{
+		Handler:         websocket.NopHandler{},
+		Subscriber:      m,
+		Publisher:       m,
+		HeartBeat:       [2]time.Duration{10 * time.Second, 10 * time.Second},
+		Name:            "assembly-hub-websocket",
+		ReadLimit:       64 << 10,
+		MaxPending:      1024,
+		MaxTransactions: 16,
+		MaxTxFrames:     1024,
+		sessions:        map[*websocket.Client]*session{},
+	}
+}
+
+func (s *Server) getSession(c *websocket.Client) *session {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.sessions[c]
+}
+
+func (s *Server) OnConnect(ctx context.Context, c *websocket.Client) error {
+	if s.ReadLimit > 0 {
+		c.SetReadLimit(s.ReadLimit)
+	}
+	ss := &session{
+		subs: map[string]*subscription{},
+		txs:  map[string][]*Frame{},
+		stop: make(chan struct{}),
+	}
+	ss.lastRecv.Store(time.Now().UnixNano())
+	s.mutex.Lock()
+	s.sessions[c] = ss
+	s.mutex.Unlock()
+	return s.Handler.OnConnect(ctx, c)
+}
+
+func (s *Server) OnClose(ctx context.Context, c *websocket.Client, reason websocket.CloseReason) {
+	s.mutex.Lock()
+	ss := s.sessions[c]
+	delete(s.sessions, c)
+	s.mutex.Unlock()
+
+	if ss != nil {
+		ss.close()
+		ss.mutex.Lock()
+		subs := ss.subs
+		ss.subs = map[string]*subscription{}
+		ss.mutex.Unlock()
+		for _, sub := range subs {
+			sub.v.Close()
+		}
+	}
+	s.Handler.OnClose(ctx, c, reason)
+}
+
+func (s *Server) OnMessage(ctx context.Context, c *websocket.Client, mt websocket.MessageType, data []byte) error {
+	ss := s.getSession(c)
+	if ss == nil {
+		return nil
+	}
+	ss.lastRecv.Store(time.Now().UnixNano())
+
+	frames, err := Parse(data)
+	if err != nil {
+		s.sendError(c, nil, err.Error())
+		return nil
+	}
+	for _, f := range frames {
+		if errMsg := s.handle(ctx, c, ss, f); errMsg != "" {
+			s.sendError(c, f, errMsg)
+			return nil
+		}
+		if receipt := f.Header.Get(HdrReceipt); receipt != "" && f.Command != CmdConnect && f.Command != CmdStomp {
+			if !trySend(c, NewFrame(CmdReceipt, HdrReceiptID, receipt)) {
+				return nil
+			}
+		}
+		if f.Command == CmdDisconnect {
+			go c.CloseWith(1000, "")
+			return nil
+		}
+	}
+	return nil
+}
+
+// handle 处理一帧，返回非空字符串时回复 ERROR 并关闭连接
+func (s *Server) handle(ctx context.Context, c *websocket.Client, ss *session, f *Frame) string {
+	ss.mutex.Lock()
+	connected := ss.connected
+	ss.mutex.Unlock()
+
+	if f.Command == CmdConnect || f.Command == CmdStomp {
+		if connected {
+			return "already connected"
+		}
+		return s.connect(ctx, c, ss, f)
+	}
+	if !connected {
+		return "not connected"
+	}
+
+	switch f.Command {
+	case CmdSend, CmdAck, CmdNack:
+		if tx := f.Header.Get(HdrTransaction); tx != "" {
+			ss.mutex.Lock()
+			frames, ok := ss.txs[tx]
+			full := ok && s.MaxTxFrames > 0 && len(frames) >= s.MaxTxFrames
+			if ok && !full {
+				ss.txs[tx] = append(frames, f)
+			}
+			ss.mutex.Unlock()
+			if !ok {
+				return "unknown transaction " + tx
+			}
+			if full {
+				return "too many frames in transaction " + tx
+			}
+			return ""
+		}
+		if f.Command == CmdSend {
+			return s.send(ctx, f)
+		}
+		return s.ack(c, ss, f)
+	case CmdSubscribe:
+		return s.subscribe(c, ss, f)
+	case CmdUnsubscribe:
+		id := f.Header.Get(HdrID)
+		ss.mutex.Lock()
+		sub := ss.subs[id]
+		delete(ss.subs, id)
+		ss.mutex.Unlock()
+		if sub == nil {
+			return "unknown subscription " + id
+		}
+		sub.v.Close()
+	case CmdBegin:
+		tx := f.Header.Get(HdrTransaction)
+		if tx == "" {
+			return "missing transaction header"
+		}
+		ss.mutex.Lock()
+		_, exists := ss.txs[tx]
+		full := s.MaxTransactions > 0 && len(ss.txs) >= s.MaxTransactions
+		if !exists && !full {
+			ss.txs[tx] = nil
+		}
+		ss.mutex.Unlock()
+		if exists {
+			return "transaction " + tx + " already started"
+		}
+		if full {
+			return "too many transactions"
+		}
+	case CmdCommit, CmdAbort:
+		tx := f.Header.Get(HdrTransaction)
+		ss.mutex.Lock()
+		frames, ok := ss.txs[tx]
+		delete(ss.txs, tx)
+		ss.mutex.Unlock()
+		if !ok {
+			return "unknown transaction " + tx
+		}
+		if f.Command == CmdCommit {
+			for _, tf := range frames {
+				var errMsg string
+				if tf.Command == CmdSend {
+					errMsg = s.send(ctx, tf)
+				} else {
+					errMsg = s.ack(c, ss, tf)
+				}
+				if errMsg != "" {
+					return errMsg
+				}
+			}
+		}
+	case CmdDisconnect:
+	default:
+		return "unknown command " + f.Command
+	}
+	return ""
+}
+
+func (s *Server) connect(ctx context.Context, c *websocket.Client, ss *session, f *Frame) string {
+	versions, ok := f.Header.Lookup(HdrAcceptVersion)
+	if !ok || !containsVersion(versions, "1.2") {
+		return "supported protocol versions are 1.2"
+	}
+	if s.Authenticate != nil {
+		if err := s.Authenticate(ctx, c, f); err != nil {
+			return "authentication failed: " + err.Error()
+		}
+	}
+
+	cx, cy := parseHeartBeat(f.Header.Get(HdrHeartBeat))
+	sx, sy := s.HeartBeat[0], s.HeartBeat[1]
+	// 发送间隔取双方要求的较大值，任一方为 0 时不发送
+	var out, in time.Duration
+	if sx > 0 && cy > 0 {
+		out = maxDuration(sx, cy)
+	}
+	if sy > 0 && cx > 0 {
+		in = maxDuration(sy, cx)
+	}
+
+	ss.mutex.Lock()
+	ss.connected = true
+	ss.mutex.Unlock()
+
+	hb := strconv.FormatInt(sx.Milliseconds(), 10) + "," + strconv.FormatInt(sy.Milliseconds(), 10)
+	if !trySend(c, NewFrame(CmdConnected,
+		HdrVersion, "1.2",
+		HdrHeartBeat, hb,
+		HdrSession, c.ID,
+		HdrServer, s.Name,
+	)) {
+		return ""
+	}
+
+	if out > 0 || in > 0 {
+		go s.heartBeat(c, ss, out, in)
+	}
+	return ""
+}
+
+// heartBeat 按协商结果发送心跳，并在超过 2 倍间隔没有收到数据时断开连接
+func (s *Server) heartBeat(c *websocket.Client, ss *session, out, in time.Duration) {
+	var outC, inC <-chan time.Time
+	if out > 0 {
+		t := time.NewTicker(out)
+		defer t.Stop()
+		outC = t.C
+	}
+	if in > 0 {
+		t := time.NewTicker(in)
+		defer t.Stop()
+		inC = t.C
+	}
+
+	for {
+		select {
+		case <-outC:
+			// 连接可能已被移出组，发送队列已满时数据帧也可作为心跳
+			c.TrySend(heartBeat)
+		case now := <-inC:
+			if now.Sub(time.Unix(0, ss.lastRecv.Load())) > 2*in {
+				c.Terminate(websocket.NewCloseReason(websocket.CloseTimeout, "stomp heart-beat timeout"))
+				return
+			}
+		case <-ss.stop:
+			return
+		}
+	}
+}
+
+func (s *Server) group(dest string) (string, error) {
+	if dest == "" {
+		return "", fmt.Errorf("missing destination header")
+	}
+	if s.Destination == nil {
+		return dest, nil
+	}
+	return s.Destination(dest)
+}
+
+func (s *Server) send(ctx context.Context, f *Frame) string {
+	group, err := s.group(f.Header.Get(HdrDestination))
+	if err != nil {
+		return err.Error()
+	}
+	if err = s.Publisher.SendMsgCtx(ctx, group, string(f.Body)); err != nil {
+		return "send failed: " + err.Error()
+	}
+	return ""
+}
+
+func (s *Server) subscribe(c *websocket.Client, ss *session, f *Frame) string {
+	id := f.Header.Get(HdrID)
+	if id == "" {
+		return "missing id header"
+	}
+	dest := f.Header.Get(HdrDestination)
+	group, err := s.group(dest)
+	if err != nil {
+		return err.Error()
+	}
+	mode := f.Header.Get(HdrAck)
+	switch mode {
+	case "":
+		mode = AckAuto
+	case AckAuto, AckClient, AckClientIndividual:
+	default:
+		return "invalid ack mode " + mode
+	}
+
+	ss.mutex.Lock()
+	if _, ok := ss.subs[id]; ok {
+		ss.mutex.Unlock()
+		return "subscription " + id + " already exists"
+	}
+	sub := &subscription{id: id, dest: dest, ack: mode}
+	ss.subs[id] = sub
+	ss.mutex.Unlock()
+
+	deliver := func(msg []byte) {
+		msgID := c.ID + "-" + strconv.FormatUint(ss.seq.Add(1), 10)
+		mf := NewFrame(CmdMessage,
+			HdrSubscription, id,
+			HdrMessageID, msgID,
+			HdrDestination, dest,
+		)
+		if mode != AckAuto {
+			mf.Header.Set(HdrAck, msgID)
+			sub.mutex.Lock()
+			full := s.MaxPending > 0 && len(sub.pending) >= s.MaxPending
+			if !full {
+				sub.pending = append(sub.pending, msgID)
+			}
+			sub.mutex.Unlock()
+			if full {
+				// 客户端长时间不 ACK
+				c.Terminate(websocket.NewCloseReason(websocket.CloseSlowConsumer, "stomp too many unacknowledged messages"))
+				return
+			}
+		}
+		mf.Body = msg
+		trySend(c, mf)
+	}
+	v, err := s.Subscriber.Subscribe(c, group, deliver, nil)
+	if err != nil {
+		ss.mutex.Lock()
+		delete(ss.subs, id)
+		ss.mutex.Unlock()
+		return "subscribe failed: " + err.Error()
+	}
+	sub.v = v
+	return ""
+}
+
+func (s *Server) ack(c *websocket.Client, ss *session, f *Frame) string {
+	id := f.Header.Get(HdrID)
+	if id == "" {
+		return "missing id header"
+	}
+
+	ss.mutex.Lock()
+	subs := make([]*subscription, 0, len(ss.subs))
+	for _, sub := range ss.subs {
+		subs = append(subs, sub)
+	}
+	ss.mutex.Unlock()
+
+	for _, sub := range subs {
+		if acked := sub.take(id); acked != nil {
+			if s.OnAck != nil {
+				for _, msgID := range acked {
+					s.OnAck(c, sub.id, msgID, f.Command == CmdAck)
+				}
+			}
+			return ""
+		}
+	}
+	return "unknown message " + id
+}
+
+// take 移除被确认的消息，client 模式下确认该消息及之前的所有消息
+func (sub *subscription) take(msgID string) []string {
+	sub.mutex.Lock()
+	defer sub.mutex.Unlock()
+	for i, id := range sub.pending {
+		if id != msgID {
+			continue
+		}
+		var acked []string
+		if sub.ack == AckClient {
+			acked = append(acked, sub.pending[:i+1]...)
+			sub.pending = append(sub.pending[:0:0], sub.pending[i+1:]...)
+		} else {
+			acked = []string{id}
+			sub.pending = append(sub.pending[:i:i], sub.pending[i+1:]...)
+		}
+		return acked
+	}
+	return nil
+}
+
+// sendError 回复 ERROR 帧并关闭连接
+func (s *Server) sendError(c *websocket.Client, f *Frame, msg string) {
+	ef := NewFrame(CmdError, HdrMessage, msg)
+	if f != nil {
+		if receipt := f.Header.Get(HdrReceipt); receipt != "" {
+			ef.Header.Set(HdrReceiptID, receipt)
+		}
+	}
+	// 发送失败时同样关闭连接
+	c.TrySend(ef.Bytes())
+	go c.CloseWith(1002, "stomp error")
+}
+
+// trySend 不阻塞地发送帧，发送队列已满时按慢消费者断开连接，连接已关闭时返回 false
+func trySend(c *websocket.Client, f *Frame) bool {
+	if c.TrySend(f.Bytes()) {
+		return true
+	}
+	c.Terminate(websocket.NewCloseReason(websocket.CloseSlowConsumer, ""))
+	return false
+}
+
+func containsVersion(versions, v string) bool {
+	for _, s := range strings.Split(versions, ",") {
+		if strings.TrimSpace(s) == v {
+			return true
+		}
+	}
+	return false
+}
+
+// parseHeartBeat 解析 "cx,cy"（毫秒），格式错误时视为 0,0
+func parseHeartBeat(s string) (time.Duration, time.Duration) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return 0, 0
+	}
+	x, err1 := strconv.ParseInt(strings.TrimSpace(parts[0]), 10, 64)
+	y, err2 := strconv.ParseInt(strings.TrimSpace(parts[1]), 10, 64)
+	if err1 != nil || err2 != nil || x < 0 || y < 0 {
+		return 0, 0
+	}
+	return time.Duration(x) * time.Millisecond, time.Duration(y) * time.Millisecond
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,325 @@
+package stomp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	gorilla "github.com/gorilla/websocket"
+
+	"github.com/assembly-hub/websocket"
+	"github.com/assembly-hub/websocket/simplesub"
+)
+
+// testConn STOMP 客户端，收到的帧逐个读出
+type testConn struct {
+	t      *testing.T
+	conn   *gorilla.Conn
+	frames chan *Frame
+	beats  chan struct{}
+}
+
+func startServer(t *testing.T, srv *Server) *testConn {
+	t.Helper()
+	m := simplesub.NewManager()
+	srv.Subscriber, srv.Publisher = m, m
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = m.AddGroupWithHandler("stomp", w, r, srv)
+	}))
+	t.Cleanup(hs.Close)
+
+	conn, _, err := gorilla.DefaultDialer.Dial("ws"+strings.TrimPrefix(hs.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	tc := &testConn{t: t, conn: conn, frames: make(chan *Frame, 16), beats: make(chan struct{}, 16)}
+	go func() {
+		defer close(tc.frames)
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if strings.Trim(string(data), "\r\n") == "" {
+				select {
+				case tc.beats <- struct{}{}:
+				default:
+				}
+				continue
+			}
+			frames, err := Parse(data)
+			if err != nil {
+				t.Errorf("parse %q: %v", data, err)
+				return
+			}
+			for _, f := range frames {
+				tc.frames <- f
+			}
+		}
+	}()
+	return tc
+}
+
+func (tc *testConn) send(command string, kv ...string) {
+	tc.t.Helper()
+	f := NewFrame(command, kv...)
+	tc.sendFrame(f)
+}
+
+func (tc *testConn) sendFrame(f *Frame) {
+	tc.t.Helper()
+	if err := tc.conn.WriteMessage(gorilla.TextMessage, f.Bytes()); err != nil {
+		tc.t.Fatal(err)
+	}
+}
+
+func (tc *testConn) expect(command string) *Frame {
+	tc.t.Helper()
+	select {
+	case f, ok := <-tc.frames:
+		if !ok {
+			tc.t.Fatalf("connection closed, want %s", command)
+		}
+		if f.Command != command {
+			tc.t.Fatalf("got %s %v %q, want %s", f.Command, f.Header, f.Body, command)
+		}
+		return f
+	case <-time.After(5 * time.Second):
+		tc.t.Fatalf("no %s frame", command)
+		return nil
+	}
+}
+
+// expectClosed ERROR 之后连接被关闭
+func (tc *testConn) expectClosed() {
+	tc.t.Helper()
+	select {
+	case f, ok := <-tc.frames:
+		if ok {
+			tc.t.Fatalf("got %s after ERROR", f.Command)
+		}
+	case <-time.After(5 * time.Second):
+		tc.t.Fatal("connection not closed")
+	}
+}
+
+func (tc *testConn) connect() {
+	tc.t.Helper()
+	tc.send(CmdConnect, HdrAcceptVersion, "1.1,1.2", HdrHost, "test", HdrHeartBeat, "0,0")
+	tc.expect(CmdConnected)
+}
+
+func TestConnect(t *testing.T) {
+	tests := []struct {
+		name    string
+		frame   *Frame
+		command string
+		message string
+	}{
+		{
+			name:    "connect",
+			frame:   NewFrame(CmdConnect, HdrAcceptVersion, "1.2", HdrHost, "test"),
+			command: CmdConnected,
+		},
+		{
+			name:    "stomp command",
+			frame:   NewFrame(CmdStomp, HdrAcceptVersion, "1.2", HdrHost, "test"),
+			command: CmdConnected,
+		},
+		{
+			name:    "unsupported version",
+			frame:   NewFrame(CmdConnect, HdrAcceptVersion, "1.0,1.1", HdrHost, "test"),
+			command: CmdError,
+			message: "supported protocol versions are 1.2",
+		},
+		{
+			name:    "frame before connect",
+			frame:   NewFrame(CmdSend, HdrDestination, "/a"),
+			command: CmdError,
+			message: "not connected",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := startServer(t, NewServer(simplesub.NewManager()))
+			tc.sendFrame(tt.frame)
+			f := tc.expect(tt.command)
+			if tt.command == CmdConnected {
+				if f.Header.Get(HdrVersion) != "1.2" || f.Header.Get(HdrSession) == "" {
+					t.Fatalf("got %v", f.Header)
+				}
+				return
+			}
+			if got := f.Header.Get(HdrMessage); got != tt.message {
+				t.Fatalf("message %q, want %q", got, tt.message)
+			}
+			tc.expectClosed()
+		})
+	}
+}
+
+func TestSubscribeSendAck(t *testing.T) {
+	acks := make(chan string, 4)
+	srv := NewServer(simplesub.NewManager())
+	srv.OnAck = func(c *websocket.Client, sub, msgID string, ack bool) {
+		if ack {
+			acks <- sub + ":" + msgID
+		}
+	}
+	tc := startServer(t, srv)
+	tc.connect()
+
+	tc.send(CmdSubscribe, HdrID, "0", HdrDestination, "/topic/a", HdrAck, AckClientIndividual, HdrReceipt, "r1")
+	if f := tc.expect(CmdReceipt); f.Header.Get(HdrReceiptID) != "r1" {
+		t.Fatalf("got %v", f.Header)
+	}
+
+	send := NewFrame(CmdSend, HdrDestination, "/topic/a", HdrReceipt, "r2")
+	send.Body = []byte("hello\x00world")
+	tc.sendFrame(send)
+
+	var msg *Frame
+	// RECEIPT 与 MESSAGE 的顺序不确定
+	for i := 0; i < 2; i++ {
+		select {
+		case f := <-tc.frames:
+			switch f.Command {
+			case CmdReceipt:
+				if f.Header.Get(HdrReceiptID) != "r2" {
+					t.Fatalf("got %v", f.Header)
+				}
+			case CmdMessage:
+				msg = f
+			default:
+				t.Fatalf("got %s %v", f.Command, f.Header)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no RECEIPT or MESSAGE")
+		}
+	}
+	if msg == nil || string(msg.Body) != "hello\x00world" ||
+		msg.Header.Get(HdrSubscription) != "0" || msg.Header.Get(HdrDestination) != "/topic/a" {
+		t.Fatalf("got %+v", msg)
+	}
+	ackID := msg.Header.Get(HdrAck)
+	if ackID == "" || ackID != msg.Header.Get(HdrMessageID) {
+		t.Fatalf("ack header %q", ackID)
+	}
+
+	tc.send(CmdAck, HdrID, ackID, HdrReceipt, "r3")
+	tc.expect(CmdReceipt)
+	if got := <-acks; got != "0:"+ackID {
+		t.Fatalf("OnAck got %s", got)
+	}
+
+	// 已确认的消息再次 ACK 时回复 ERROR
+	tc.send(CmdAck, HdrID, ackID, HdrReceipt, "r4")
+	if f := tc.expect(CmdError); f.Header.Get(HdrReceiptID) != "r4" || !strings.HasPrefix(f.Header.Get(HdrMessage), "unknown message") {
+		t.Fatalf("got %v", f.Header)
+	}
+	tc.expectClosed()
+}
+
+func TestSubscribeErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		kv      []string
+		message string
+	}{
+		{"missing id", []string{HdrDestination, "/a"}, "missing id header"},
+		{"missing destination", []string{HdrID, "0"}, "missing destination header"},
+		{"invalid ack mode", []string{HdrID, "0", HdrDestination, "/a", HdrAck, "none"}, "invalid ack mode none"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := startServer(t, NewServer(simplesub.NewManager()))
+			tc.connect()
+			tc.send(CmdSubscribe, tt.kv...)
+			if f := tc.expect(CmdError); f.Header.Get(HdrMessage) != tt.message {
+				t.Fatalf("got %q", f.Header.Get(HdrMessage))
+			}
+			tc.expectClosed()
+		})
+	}
+}
+
+func TestHeartBeat(t *testing.T) {
+	srv := NewServer(simplesub.NewManager())
+	srv.HeartBeat = [2]time.Duration{10 * time.Millisecond, 0}
+	tc := startServer(t, srv)
+	tc.send(CmdConnect, HdrAcceptVersion, "1.2", HdrHost, "test", HdrHeartBeat, "0,10")
+	if f := tc.expect(CmdConnected); f.Header.Get(HdrHeartBeat) != "10,0" {
+		t.Fatalf("heart-beat %q", f.Header.Get(HdrHeartBeat))
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-tc.beats:
+		case <-time.After(5 * time.Second):
+			t.Fatal("no heart-beat")
+		}
+	}
+}
+
+func TestLargeFrame(t *testing.T) {
+	tc := startServer(t, NewServer(simplesub.NewManager()))
+	tc.connect()
+	tc.send(CmdSubscribe, HdrID, "0", HdrDestination, "/topic/a")
+	send := NewFrame(CmdSend, HdrDestination, "/topic/a")
+	// 超过连接默认的 512 字节
+	send.Body = []byte(strings.Repeat("x", 4096))
+	tc.sendFrame(send)
+	if f := tc.expect(CmdMessage); len(f.Body) != 4096 {
+		t.Fatalf("body %d bytes", len(f.Body))
+	}
+}
+
+func TestMaxPending(t *testing.T) {
+	srv := NewServer(simplesub.NewManager())
+	srv.MaxPending = 1
+	tc := startServer(t, srv)
+	tc.connect()
+	tc.send(CmdSubscribe, HdrID, "0", HdrDestination, "/topic/a", HdrAck, AckClient, HdrReceipt, "r1")
+	tc.expect(CmdReceipt)
+
+	// 第一条消息未 ACK 时第二条超过上限，连接被断开
+	tc.send(CmdSend, HdrDestination, "/topic/a")
+	tc.expect(CmdMessage)
+	tc.send(CmdSend, HdrDestination, "/topic/a")
+	tc.expectClosed()
+}
+
+func TestMaxTransactions(t *testing.T) {
+	tests := []struct {
+		name    string
+		frames  [][]string
+		message string
+	}{
+		{"transactions", [][]string{
+			{CmdBegin, HdrTransaction, "t1"},
+			{CmdBegin, HdrTransaction, "t2"},
+		}, "too many transactions"},
+		{"frames", [][]string{
+			{CmdBegin, HdrTransaction, "t1"},
+			{CmdSend, HdrTransaction, "t1", HdrDestination, "/a"},
+			{CmdSend, HdrTransaction, "t1", HdrDestination, "/a"},
+		}, "too many frames in transaction t1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := NewServer(simplesub.NewManager())
+			srv.MaxTransactions, srv.MaxTxFrames = 1, 1
+			tc := startServer(t, srv)
+			tc.connect()
+			for _, f := range tt.frames {
+				tc.send(f[0], f[1:]...)
+			}
+			if f := tc.expect(CmdError); f.Header.Get(HdrMessage) != tt.message {
+				t.Fatalf("got %q", f.Header.Get(HdrMessage))
+			}
+			tc.expectClosed()
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package websocket
+
+import (
+	"context"
+	"sync"
+)
+
+// 订阅事件，见 Router.HandleSubscribe
+const (
+	EventSubscribe    = "subscribe"
+	EventUnsubscribe  = "unsubscribe"
+	EventSubscribed   = "subscribed"
+	EventUnsubscribed = "unsubscribed"
+)
+
+// 订阅错误码
+const (
+	ErrCodeForbidden       = "forbidden"
+	ErrCodeSubscribeFailed = "subscribe_failed"
+)
+
+// SubscribeData 订阅事件的 data
+type SubscribeData struct {
+	Group string `json:"group"`
+}
+
+// groupSubs 各连接订阅的组
+type groupSubs struct {
+	mutex sync.Mutex
+	subs  map[*Client]map[string]*Client
+}
+
+// remove 移除订阅，v 不为空时只移除该虚拟连接
+func (g *groupSubs) remove(c *Client, group string, v *Client) *Client {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	old := g.subs[c][group]
+	if old == nil || (v != nil && old != v) {
+		return nil
+	}
+	delete(g.subs[c], group)
+	if len(g.subs[c]) == 0 {
+		delete(g.subs, c)
+	}
+	return old
+}
+
+// HandleSubscribe 注册 subscribe、unsubscribe 事件，连接以虚拟连接订阅其他组，组内消息原样发给连接。
+// allow 判断连接能否订阅该组，为 nil 时拒绝所有订阅；成功时回复 subscribed、unsubscribed 事件，wsclient 的 SubscribeGroup 使用该协议。
+// 连接的发送队列已满时按慢连接断开
+func (r *Router) HandleSubscribe(s Subscriber, allow func(c *Client, group string) bool) *Router {
+	g := &groupSubs{subs: map[*Client]map[string]*Client{}}
+
+	r.On(EventSubscribe, func(ec *EventContext) error {
+		var data SubscribeData
+		if err := ec.Bind(&data); err != nil || data.Group == "" {
+			return ec.Emit(EventError, &EventErrorData{Code: ErrCodeInvalidMessage, Message: "data must be {\"group\": \"...\"}"})
+		}
+		if allow == nil || !allow(ec.Client, data.Group) {
+			return ec.Emit(EventError, &EventErrorData{Code: ErrCodeForbidden, Message: data.Group})
+		}
+
+		c := ec.Client
+		g.mutex.Lock()
+		_, ok := g.subs[c][data.Group]
+		g.mutex.Unlock()
+		if ok {
+			return ec.Emit(EventSubscribed, &data)
+		}
+
+		group := data.Group
+		onEnd := HandlerFuncs{Close: func(ctx context.Context, v *Client, reason CloseReason) {
+			g.remove(c, group, v)
+		}}
+		deliver := func(msg []byte) {
+			if !c.TrySend(msg) {
+				c.Terminate(NewCloseReason(CloseSlowConsumer, ""))
+			}
+		}
+		v, err := s.Subscribe(c, group, deliver, onEnd)
+		if err != nil {
+			return ec.Emit(EventError, &EventErrorData{Code: ErrCodeSubscribeFailed, Message: err.Error()})
+		}
+
+		g.mutex.Lock()
+		m := g.subs[c]
+		if m == nil {
+			m = map[string]*Client{}
+			g.subs[c] = m
+		}
+		m[group] = v
+		g.mutex.Unlock()
+		if v.Context().Err() != nil {
+			// 订阅期间连接已关闭
+			g.remove(c, group, v)
+		}
+		return ec.Emit(EventSubscribed, &data)
+	})
+
+	r.On(EventUnsubscribe, func(ec *EventContext) error {
+		var data SubscribeData
+		if err := ec.Bind(&data); err != nil || data.Group == "" {
+			return ec.Emit(EventError, &EventErrorData{Code: ErrCodeInvalidMessage, Message: "data must be {\"group\": \"...\"}"})
+		}
+		if v := g.remove(ec.Client, data.Group, nil); v != nil {
+			v.Close()
+		}
+		return ec.Emit(EventUnsubscribed, &data)
+	})
+	return r
+}
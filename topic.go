@@ -0,0 +1,177 @@
+package websocket
+
+import (
+	"strings"
+	"sync"
+)
+
+// 分层主题，组名以 . 分隔层级，如 market.us.AAPL。
+// 组名为通配主题时，该组接收所有匹配主题的消息：* 匹配一个层级，> 只能在最后，匹配之后的一个或多个层级。
+// 管理器默认不开启，需调用 SetTopicWildcards(true)
+const (
+	TopicSep          = "."
+	TopicWildcardOne  = "*"
+	TopicWildcardRest = ">"
+)
+
+// IsTopicPattern 组名是否为通配主题，> 不在最后一级时按普通组名处理
+func IsTopicPattern(name string) bool {
+	tokens := strings.Split(name, TopicSep)
+	for i, t := range tokens {
+		if t == TopicWildcardRest && i != len(tokens)-1 {
+			return false
+		}
+	}
+	for _, t := range tokens {
+		if t == TopicWildcardOne || t == TopicWildcardRest {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchTopic 主题 topic 是否匹配通配主题 pattern
+func MatchTopic(pattern, topic string) bool {
+	ps, ts := strings.Split(pattern, TopicSep), strings.Split(topic, TopicSep)
+	for i, p := range ps {
+		if p == TopicWildcardRest && i == len(ps)-1 {
+			return len(ts) > i
+		}
+		if i >= len(ts) || (p != TopicWildcardOne && p != ts[i]) {
+			return false
+		}
+	}
+	return len(ps) == len(ts)
+}
+
+// TopicRedisPattern 通配主题对应的 redis PSUBSCRIBE 模式，prefix 为频道前缀。
+// glob 的 * 会跨越层级，收到消息后须再以 MatchTopic 过滤
+func TopicRedisPattern(prefix, pattern string) string {
+	tokens := strings.Split(pattern, TopicSep)
+	for i, t := range tokens {
+		if t == TopicWildcardOne || (t == TopicWildcardRest && i == len(tokens)-1) {
+			tokens[i] = "*"
+		} else {
+			tokens[i] = globEscape(t)
+		}
+	}
+	return globEscape(prefix) + strings.Join(tokens, TopicSep)
+}
+
+func globEscape(s string) string {
+	if !strings.ContainsAny(s, `*?[]\`) {
+		return s
+	}
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// TopicTrie 通配主题的前缀树，管理器用于查找匹配主题的通配组
+type TopicTrie struct {
+	mutex sync.RWMutex
+	root  topicNode
+	size  int
+}
+
+type topicNode struct {
+	children map[string]*topicNode
+	// pattern 以该节点结尾的通配主题
+	pattern string
+	end     bool
+}
+
+// Add 加入通配主题
+func (t *TopicTrie) Add(pattern string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	n := &t.root
+	for _, tok := range strings.Split(pattern, TopicSep) {
+		if n.children == nil {
+			n.children = map[string]*topicNode{}
+		}
+		child := n.children[tok]
+		if child == nil {
+			child = &topicNode{}
+			n.children[tok] = child
+		}
+		n = child
+	}
+	if !n.end {
+		n.end, n.pattern = true, pattern
+		t.size++
+	}
+}
+
+// Remove 移除通配主题，并清理不再使用的节点
+func (t *TopicTrie) Remove(pattern string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	tokens := strings.Split(pattern, TopicSep)
+	path := make([]*topicNode, 0, len(tokens)+1)
+	n := &t.root
+	path = append(path, n)
+	for _, tok := range tokens {
+		if n = n.children[tok]; n == nil {
+			return
+		}
+		path = append(path, n)
+	}
+	if !n.end {
+		return
+	}
+	n.end, n.pattern = false, ""
+	t.size--
+	for i := len(tokens) - 1; i >= 0; i-- {
+		child := path[i+1]
+		if child.end || len(child.children) > 0 {
+			break
+		}
+		delete(path[i].children, tokens[i])
+	}
+}
+
+// Match 匹配主题 topic 的所有通配主题
+func (t *TopicTrie) Match(topic string) []string {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	if t.size == 0 {
+		return nil
+	}
+	var list []string
+	t.root.match(strings.Split(topic, TopicSep), &list)
+	return list
+}
+
+// Len 通配主题数
+func (t *TopicTrie) Len() int {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.size
+}
+
+func (n *topicNode) match(tokens []string, list *[]string) {
+	if len(tokens) == 0 {
+		if n.end {
+			*list = append(*list, n.pattern)
+		}
+		return
+	}
+	if rest := n.children[TopicWildcardRest]; rest != nil && rest.end {
+		*list = append(*list, rest.pattern)
+	}
+	if child := n.children[tokens[0]]; child != nil {
+		child.match(tokens[1:], list)
+	}
+	if tokens[0] != TopicWildcardOne {
+		if child := n.children[TopicWildcardOne]; child != nil {
+			child.match(tokens[1:], list)
+		}
+	}
+}
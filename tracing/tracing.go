@@ -0,0 +1,122 @@
+// Package tracing 基于 OpenTelemetry 的链路追踪，未设置 TracerProvider 时使用 otel 全局配置（默认不记录）
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/assembly-hub/websocket"
+
+// span 名称
+const (
+	SpanUpgrade      = "websocket.upgrade"
+	SpanReceive      = "websocket.receive"
+	SpanPublish      = "websocket.publish"
+	SpanRedisReceive = "websocket.redis.receive"
+	SpanFanOut       = "websocket.fanout"
+)
+
+// 常用属性
+const (
+	AttrGroup   = attribute.Key("websocket.group")
+	AttrConnID  = attribute.Key("websocket.conn_id")
+	AttrUserID  = attribute.Key("websocket.user_id")
+	AttrMsgSize = attribute.Key("websocket.message_size")
+	AttrMembers = attribute.Key("websocket.members")
+)
+
+var (
+	tracerProvider trace.TracerProvider
+	propagator     propagation.TextMapPropagator = propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{})
+)
+
+// SetTracerProvider 设置使用的 TracerProvider，为空时使用 otel.GetTracerProvider()
+func SetTracerProvider(tp trace.TracerProvider) {
+	tracerProvider = tp
+}
+
+// SetPropagator 设置跨节点传递 trace 上下文的格式，默认 W3C TraceContext + Baggage
+func SetPropagator(p propagation.TextMapPropagator) {
+	if p != nil {
+		propagator = p
+	}
+}
+
+func tracer() trace.Tracer {
+	tp := tracerProvider
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return tp.Tracer(instrumentationName)
+}
+
+// Start 开始一个 span
+func Start(ctx context.Context, name string, kind trace.SpanKind, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return tracer().Start(ctx, name, trace.WithSpanKind(kind), trace.WithAttributes(attrs...))
+}
+
+// End 结束 span，err 不为空时记录错误
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Inject 导出 ctx 中的 trace 上下文，ctx 中没有有效 span 时返回空
+func Inject(ctx context.Context) map[string]string {
+	if ctx == nil || !trace.SpanContextFromContext(ctx).IsValid() {
+		return nil
+	}
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+	return carrier
+}
+
+// Extract 从 header 中恢复 trace 上下文
+func Extract(ctx context.Context, header map[string]string) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if len(header) == 0 {
+		return ctx
+	}
+	return propagator.Extract(ctx, propagation.MapCarrier(header))
+}
+
+// StartUpgrade ws 升级
+func StartUpgrade(ctx context.Context, group string) (context.Context, trace.Span) {
+	return Start(ctx, SpanUpgrade, trace.SpanKindServer, AttrGroup.String(group))
+}
+
+// StartReceive 处理一条客户端消息
+func StartReceive(ctx context.Context, connID, userID, group string, size int) (context.Context, trace.Span) {
+	return Start(ctx, SpanReceive, trace.SpanKindServer, AttrConnID.String(connID),
+		AttrUserID.String(userID), AttrGroup.String(group), AttrMsgSize.Int(size))
+}
+
+// StartPublish 向组发布消息
+func StartPublish(ctx context.Context, group string, size int) (context.Context, trace.Span) {
+	return Start(ctx, SpanPublish, trace.SpanKindProducer, AttrGroup.String(group), AttrMsgSize.Int(size))
+}
+
+// StartRedisReceive 从 redis 收到其他节点发布的消息，header 为消息封装中的 trace 上下文
+func StartRedisReceive(header map[string]string, group string) (context.Context, trace.Span) {
+	return Start(Extract(context.Background(), header), SpanRedisReceive, trace.SpanKindConsumer, AttrGroup.String(group))
+}
+
+// StartFanOut 组内广播
+func StartFanOut(ctx context.Context, group string) (context.Context, trace.Span) {
+	return Start(ctx, SpanFanOut, trace.SpanKindInternal, AttrGroup.String(group))
+}
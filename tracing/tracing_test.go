@@ -0,0 +1,71 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/assembly-hub/websocket/envelope"
+)
+
+func TestTraceAcrossRedisHop(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+	SetTracerProvider(tp)
+	defer SetTracerProvider(nil)
+
+	// 发布节点：span 上下文随消息封装写入 redis
+	ctx, pub := StartPublish(context.Background(), "g", 5)
+	data := envelope.Encode(Inject(ctx), []byte("hello"))
+	End(pub, nil)
+
+	// 接收节点
+	header, payload := envelope.Decode(data)
+	if string(payload) != "hello" {
+		t.Fatalf("payload %q", payload)
+	}
+	ctx, recv := StartRedisReceive(header, "g")
+	_, fan := StartFanOut(ctx, "g")
+	End(fan, nil)
+	End(recv, nil)
+
+	spans := rec.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("got %d spans", len(spans))
+	}
+	byName := map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range spans {
+		byName[s.Name()] = s
+	}
+	p, r, f := byName[SpanPublish], byName[SpanRedisReceive], byName[SpanFanOut]
+	if p == nil || r == nil || f == nil {
+		t.Fatalf("missing span: %v", byName)
+	}
+	traceID := p.SpanContext().TraceID()
+	if r.SpanContext().TraceID() != traceID || f.SpanContext().TraceID() != traceID {
+		t.Fatal("trace id not propagated across redis")
+	}
+	if r.Parent().SpanID() != p.SpanContext().SpanID() || !r.Parent().IsRemote() {
+		t.Fatalf("redis receive parent %v, want remote %v", r.Parent().SpanID(), p.SpanContext().SpanID())
+	}
+	if f.Parent().SpanID() != r.SpanContext().SpanID() {
+		t.Fatal("fan-out is not a child of redis receive")
+	}
+	for _, kv := range r.Attributes() {
+		if kv.Key == AttrGroup && kv.Value.AsString() != "g" {
+			t.Fatalf("group attribute %q", kv.Value.AsString())
+		}
+	}
+}
+
+func TestInjectWithoutSpan(t *testing.T) {
+	if h := Inject(context.Background()); h != nil {
+		t.Fatalf("got %v", h)
+	}
+	// 没有 header 的消息保持原样
+	if data := envelope.Encode(nil, []byte("x")); string(data) != "x" {
+		t.Fatalf("got %q", data)
+	}
+}
@@ -0,0 +1,50 @@
+// Package websocket
+package websocket
+
+import "context"
+
+// Transport 连接使用的传输。各种传输的连接都是 *Client，加入同一个组、使用同一个 Handler，
+// SendMsg、Close 等方法的行为一致，Handler 一般不需要关心连接的传输
+type Transport string
+
+const (
+	TransportWebSocket Transport = "websocket"
+	TransportSSE       Transport = "sse"
+	TransportLongPoll  Transport = "longpoll"
+)
+
+type transportKey struct{}
+
+type remoteAddrKey struct{}
+
+// WithTransport 在请求 context 中记录传输，NewStreamClient 创建的连接使用该传输
+func WithTransport(ctx context.Context, t Transport) context.Context {
+	return context.WithValue(ctx, transportKey{}, t)
+}
+
+// WithRemoteAddr 在请求 context 中记录对端地址，NewStreamClient 创建的连接使用该地址
+func WithRemoteAddr(ctx context.Context, addr string) context.Context {
+	return context.WithValue(ctx, remoteAddrKey{}, addr)
+}
+
+// Transport 连接使用的传输，虚拟连接返回所属连接的传输
+func (c *Client) Transport() Transport {
+	if c.parent != nil {
+		return c.parent.Transport()
+	}
+	if c.transport == "" {
+		return TransportWebSocket
+	}
+	return c.transport
+}
+
+// RemoteAddr 对端地址
+func (c *Client) RemoteAddr() string {
+	if c.parent != nil {
+		return c.parent.RemoteAddr()
+	}
+	if c.Conn != nil {
+		return c.Conn.RemoteAddr().String()
+	}
+	return c.remoteAddr
+}
@@ -0,0 +1,248 @@
+// Package transport SSE、长轮询等 HTTP 传输共用的会话。
+// 每个会话以 websocket.NewStreamClient 创建的连接加入组，组内消息编号后缓存，
+// 承载会话的请求断开后会话保留 TTL，期间客户端可用会话 id 和消息序号接续
+package transport
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/assembly-hub/websocket"
+)
+
+// Message 会话缓存的消息，Seq 从 1 开始递增
+type Message struct {
+	Seq  uint64
+	Data []byte
+}
+
+// Session 一个会话，对应组内的一个连接
+type Session struct {
+	c *websocket.Client
+
+	mutex sync.Mutex
+	seq   uint64
+	msgs  []Message
+	size  int
+	// 当前承载会话的请求，新请求接续时关闭
+	attached chan struct{}
+	expire   *time.Timer
+
+	notify chan struct{}
+	done   chan struct{}
+}
+
+// ID 会话 id，即连接 id
+func (ss *Session) ID() string {
+	return ss.c.ID
+}
+
+// Client 会话在组内的连接
+func (ss *Session) Client() *websocket.Client {
+	return ss.c
+}
+
+// Notify 有新消息时可读
+func (ss *Session) Notify() <-chan struct{} {
+	return ss.notify
+}
+
+// Done 会话退出组时关闭
+func (ss *Session) Done() <-chan struct{} {
+	return ss.done
+}
+
+// Since seq 之后缓存的消息，lost 为超出缓存被丢弃的条数
+func (ss *Session) Since(seq uint64) (msgs []Message, lost uint64) {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	i := len(ss.msgs)
+	for i > 0 && ss.msgs[i-1].Seq > seq {
+		i--
+	}
+	if i == 0 && len(ss.msgs) > 0 && ss.msgs[0].Seq > seq+1 {
+		lost = ss.msgs[0].Seq - seq - 1
+	}
+	return append([]Message(nil), ss.msgs[i:]...), lost
+}
+
+// Ack 客户端已收到 seq 及之前的消息，不再缓存
+func (ss *Session) Ack(seq uint64) {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	i := 0
+	for i < len(ss.msgs) && ss.msgs[i].Seq <= seq {
+		i++
+	}
+	ss.msgs = ss.msgs[i:]
+}
+
+func (ss *Session) push(msg []byte) {
+	ss.mutex.Lock()
+	ss.seq++
+	ss.msgs = append(ss.msgs, Message{Seq: ss.seq, Data: msg})
+	if n := len(ss.msgs) - ss.size; n > 0 {
+		ss.msgs = ss.msgs[n:]
+	}
+	ss.mutex.Unlock()
+	select {
+	case ss.notify <- struct{}{}:
+	default:
+	}
+}
+
+// sessionHandler 会话退出组时移除记录
+type sessionHandler struct {
+	websocket.Handler
+	s  *Sessions
+	ss *Session
+}
+
+func (h sessionHandler) OnClose(ctx context.Context, c *websocket.Client, reason websocket.CloseReason) {
+	h.s.mutex.Lock()
+	if h.s.sessions[c.ID] == h.ss {
+		delete(h.s.sessions, c.ID)
+	}
+	h.s.mutex.Unlock()
+	close(h.ss.done)
+	h.Handler.OnClose(ctx, c, reason)
+}
+
+// Sessions 一种传输的所有会话
+type Sessions struct {
+	// Subscriber 加入组的管理器
+	Subscriber websocket.StreamSubscriber
+	// Handler 连接的事件处理，为 nil 时直接广播收到的消息
+	Handler websocket.Handler
+	// ResumeTTL 没有请求承载时会话保留的时间，为 0 时请求结束即退出组
+	ResumeTTL time.Duration
+	// BufferSize 每个会话缓存的消息条数，超过时丢弃最早的消息
+	BufferSize int
+	// MaxBodySize 客户端发送的消息的最大长度
+	MaxBodySize int64
+
+	transport websocket.Transport
+	mutex     sync.Mutex
+	sessions  map[string]*Session
+}
+
+// NewSessions 创建会话记录，sub 一般为 simplesub、singlesub、multisub 的 Manage
+func NewSessions(sub websocket.StreamSubscriber, t websocket.Transport) *Sessions {
+	return &Sessions{
+		Subscriber:  sub,
+		ResumeTTL:   30 * time.Second,
+		BufferSize:  256,
+		MaxBodySize: 64 << 10,
+		transport:   t,
+		sessions:    map[string]*Session{},
+	}
+}
+
+// Open 创建会话并加入组
+func (s *Sessions) Open(r *http.Request, group string) (*Session, error) {
+	size := s.BufferSize
+	if size <= 0 {
+		size = 1
+	}
+	ss := &Session{
+		size:   size,
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	h := s.Handler
+	if h == nil {
+		h = websocket.NewExtHandler(nil)
+	}
+	ctx := websocket.WithRemoteAddr(websocket.WithTransport(r.Context(), s.transport), r.RemoteAddr)
+	c, err := s.Subscriber.SubscribeStream(ctx, group, ss.push, sessionHandler{Handler: h, s: s, ss: ss})
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	ss.c = c
+	select {
+	case <-ss.done:
+		// 加入组后立即被关闭
+	default:
+		s.sessions[c.ID] = ss
+	}
+	s.mutex.Unlock()
+	return ss, nil
+}
+
+// Get 查找会话，会话须属于请求的用户（见 websocket.WithUserID）
+func (s *Sessions) Get(r *http.Request, id string) *Session {
+	s.mutex.Lock()
+	ss := s.sessions[id]
+	s.mutex.Unlock()
+	if ss == nil || ss.c.UserID != websocket.UserIDFromContext(r.Context()) {
+		return nil
+	}
+	return ss
+}
+
+// Attach 请求开始承载会话，停止过期计时，之前承载的请求收到返回的 chan 关闭信号后应结束
+func (s *Sessions) Attach(ss *Session) <-chan struct{} {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	if ss.expire != nil {
+		ss.expire.Stop()
+		ss.expire = nil
+	}
+	if ss.attached != nil {
+		close(ss.attached)
+	}
+	ss.attached = make(chan struct{})
+	return ss.attached
+}
+
+// Detach 请求结束，没有新的请求承载时在 ResumeTTL 后退出组
+func (s *Sessions) Detach(ss *Session, attached <-chan struct{}) {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	if ss.attached == nil || (<-chan struct{})(ss.attached) != attached {
+		return
+	}
+	ss.attached = nil
+	if s.ResumeTTL <= 0 {
+		go ss.c.Close()
+		return
+	}
+	ss.expire = time.AfterFunc(s.ResumeTTL, ss.c.Close)
+}
+
+// Receive 将请求体作为一条消息交给会话连接的 Handler，响应 204，出错时响应对应的状态码
+func (s *Sessions) Receive(w http.ResponseWriter, r *http.Request, ss *Session) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, s.MaxBodySize+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if int64(len(body)) > s.MaxBodySize {
+		http.Error(w, "message too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if err = ss.c.Receive(websocket.TextMessage, body); err != nil {
+		code := http.StatusBadRequest
+		if err == websocket.ErrClosed {
+			code = http.StatusGone
+		}
+		http.Error(w, err.Error(), code)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// OpenError Open 出错时的响应状态码
+func OpenError(err error) int {
+	if errors.Is(err, websocket.ErrBanned) {
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
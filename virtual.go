@@ -0,0 +1,118 @@
+// Package websocket
+package websocket
+
+import (
+	"context"
+	"time"
+
+	"github.com/assembly-hub/websocket/metrics"
+	"github.com/assembly-hub/websocket/tracing"
+)
+
+// NewVirtualClient 创建没有 ws 连接的虚拟连接，用于一个 ws 连接以多个订阅加入多个组
+// （如 graphql-transport-ws、STOMP）。加入组并 Run 后，组内消息交给 deliver；
+// 调用 Close 或 parent 关闭时退出组，并调用虚拟连接 Handler 的 OnClose
+func NewVirtualClient(parent *Client, sendLen int, deliver func(msg []byte)) *Client {
+	c := &Client{
+		ID:        parent.ID + "/" + newConnID()[:8],
+		UserID:    parent.UserID,
+		SessionID: parent.SessionID,
+		Send:      make(chan []byte, sendLen),
+		done:      make(chan struct{}),
+		deliver:   deliver,
+		parent:    parent,
+		logger:    parent.logger,
+	}
+	c.ctx, c.cancel = context.WithCancel(parent.Context())
+	c.connectedAt = parent.connectedAt
+	return c
+}
+
+// Parent 虚拟连接所属的连接，普通连接返回 nil
+func (c *Client) Parent() *Client {
+	return c.parent
+}
+
+// forward 虚拟连接及其他传输连接的发送循环，组内消息交给 deliver
+func (c *Client) forward() {
+	h := c.getHandler()
+	var connErr error
+	defer func() {
+		c.cancel()
+		close(c.done)
+		if c.Group != nil {
+			c.Group.UnRegister(c)
+		}
+		c.setReason(NewCloseReason(CloseLocal, ""))
+		c.fireClose(h, connErr)
+	}()
+
+	// 其他传输的连接与 ws 连接一样触发 OnConnect，虚拟连接属于 parent，不再触发
+	if c.parent == nil {
+		if connErr = c.callConnect(h); connErr != nil {
+			c.callError(h, connErr)
+			return
+		}
+	}
+
+	conflated := c.conflateQueue()
+	for {
+		select {
+		case <-conflated.notify:
+			for msg := conflated.next(time.Now()); msg != nil; msg = conflated.next(time.Now()) {
+				c.bytesOut.Add(int64(len(msg)))
+				c.touch()
+				c.protect("deliver", func() { c.deliver(msg) })
+			}
+		case msg, ok := <-c.Send:
+			if !ok {
+				// 已被移出组
+				return
+			}
+			c.bytesOut.Add(int64(len(msg)))
+			c.touch()
+			c.protect("deliver", func() { c.deliver(msg) })
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// NewStreamClient 创建由其他传输（如 SSE、长轮询）承载的连接，ctx 一般为 http 请求的 context，
+// 传输和对端地址从 ctx 中获取（见 WithTransport、WithRemoteAddr）。
+// 加入组并 Run 后触发 Handler 的 OnConnect，组内消息交给 deliver，客户端发来的消息通过 Receive 交给 Handler
+func NewStreamClient(ctx context.Context, sendLen int, deliver func(msg []byte)) *Client {
+	c := &Client{
+		ID:      newConnID(),
+		Send:    make(chan []byte, sendLen),
+		done:    make(chan struct{}),
+		deliver: deliver,
+
+		connectedAt: time.Now(),
+	}
+	if ctx != nil {
+		c.transport, _ = ctx.Value(transportKey{}).(Transport)
+		c.remoteAddr, _ = ctx.Value(remoteAddrKey{}).(string)
+	}
+	c.SetContext(ctx)
+	return c
+}
+
+// Receive 将其他传输收到的消息交给连接的 Handler，与 ws 连接的读循环一致，
+// Handler 返回的 error 交给 OnError 并返回
+func (c *Client) Receive(mt MessageType, msg []byte) error {
+	if c.Context().Err() != nil {
+		return ErrClosed
+	}
+	metrics.Default.MsgIn(c.GroupName, len(msg))
+	c.bytesIn.Add(int64(len(msg)))
+	c.touch()
+	h := c.getHandler()
+	ctx, span := tracing.StartReceive(c.ctx, c.ID, c.UserID, c.GroupName, len(msg))
+	err := c.callMessage(ctx, h, mt, msg)
+	if err != nil {
+		c.callError(h, err)
+	}
+	tracing.End(span, err)
+	return err
+}
@@ -0,0 +1,493 @@
+// Package wsclient 连接 ws 服务的 Go 客户端，底层连接复用 websocket.Client 的读写循环（含 ping/pong），
+// 断线后按退避重连，重连后重新发送订阅消息和断开期间缓存的消息。
+//
+// 服务端使用 websocket.Router 的 HandleSubscribe 时，可用 SubscribeGroup 订阅组
+package wsclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	gws "github.com/gorilla/websocket"
+
+	"github.com/assembly-hub/websocket"
+)
+
+var (
+	// ErrQueueFull 断开期间缓存的消息已满
+	ErrQueueFull = errors.New("wsclient: send queue is full")
+	// ErrClosed 客户端已关闭
+	ErrClosed = errors.New("wsclient: client is closed")
+)
+
+// DefaultResumeHeader 携带恢复凭证的默认 header
+const DefaultResumeHeader = "X-Resume-Token"
+
+// Dialer 客户端配置
+type Dialer struct {
+	// Dialer 底层拨号，为 nil 时使用 gorilla 的 DefaultDialer
+	Dialer *gws.Dialer
+	// Header 握手请求头
+	Header http.Header
+	// Handler 每个底层连接的事件处理，每次连接、断开都会调用 OnConnect、OnClose
+	Handler websocket.Handler
+	// MinBackoff、MaxBackoff 重连的退避时间，每次失败翻倍，并加入最多一半的随机抖动
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// MaxRetries 连续重连失败的最大次数，超过后客户端关闭，为 0 时不限制
+	MaxRetries int
+	// QueueSize 断开期间缓存的待发送消息数，超过时 Send 返回 ErrQueueFull
+	QueueSize int
+	// SendLen 底层连接的发送队列长度
+	SendLen int
+	// ReadLimit 接收消息的最大长度
+	ReadLimit int64
+	// ResumeHeader 携带恢复凭证的请求头，握手响应中的同名 header 会更新凭证，为空时不使用
+	ResumeHeader string
+	// SplitLines 按换行拆分收到的文本消息。服务端默认将发送队列中的文本消息以换行合并为一帧（见 SetWriteBatch），
+	// 消息本身不含换行时应开启
+	SplitLines bool
+	// ShouldReconnect 连接关闭后是否重连，为 nil 时除服务端正常关闭（1000）和踢出（1008）外都重连
+	ShouldReconnect func(reason websocket.CloseReason) bool
+}
+
+// NewDialer 创建默认配置的 Dialer
+func NewDialer() *Dialer {
+	return &Dialer{
+		MinBackoff:   500 * time.Millisecond,
+		MaxBackoff:   30 * time.Second,
+		QueueSize:    1024,
+		SendLen:      256,
+		ReadLimit:    1 << 20,
+		ResumeHeader: DefaultResumeHeader,
+		SplitLines:   true,
+	}
+}
+
+func defaultShouldReconnect(reason websocket.CloseReason) bool {
+	if reason.Kind == websocket.ClosePeer {
+		return reason.Code != gws.CloseNormalClosure && reason.Code != gws.ClosePolicyViolation
+	}
+	return true
+}
+
+// Dial 连接 url，第一次连接失败时返回 error，之后断线自动重连
+func (d *Dialer) Dial(ctx context.Context, url string) (*Client, error) {
+	c := &Client{
+		d:    d,
+		url:  url,
+		done: make(chan struct{}),
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	closed, err := c.connect(ctx)
+	if err != nil {
+		c.cancel()
+		return nil, err
+	}
+	go c.loop(closed)
+	return c, nil
+}
+
+type subscription struct {
+	key   string
+	frame []byte
+}
+
+// Client 自动重连的客户端
+type Client struct {
+	d   *Dialer
+	url string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// sendMutex 保证 Send、订阅消息按调用顺序发送，等待发送队列时只持有 sendMutex
+	sendMutex sync.Mutex
+	mutex     sync.Mutex
+	// 当前底层连接，断开期间为 nil，gone 在连接断开时关闭
+	conn  *websocket.Client
+	gone  chan struct{}
+	queue [][]byte
+	subs  []subscription
+	// 当前连接上发送的订阅、取消订阅消息，断开时不放回缓存
+	control map[string]struct{}
+	token   string
+
+	done chan struct{}
+	err  error
+}
+
+// connHandler 底层连接关闭时通知重连
+type connHandler struct {
+	websocket.Handler
+	c      *Client
+	closed chan websocket.CloseReason
+	gone   chan struct{}
+}
+
+func (h connHandler) OnMessage(ctx context.Context, wc *websocket.Client, mt websocket.MessageType, msg []byte) error {
+	if !h.c.d.SplitLines || mt != websocket.TextMessage || bytes.IndexByte(msg, '\n') < 0 {
+		return h.Handler.OnMessage(ctx, wc, mt, msg)
+	}
+	var first error
+	for _, line := range bytes.Split(msg, []byte{'\n'}) {
+		if err := h.Handler.OnMessage(ctx, wc, mt, line); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (h connHandler) OnClose(ctx context.Context, wc *websocket.Client, reason websocket.CloseReason) {
+	// 唤醒等待发送队列的 Send
+	close(h.gone)
+	h.c.mutex.Lock()
+	if h.c.conn == wc {
+		h.c.conn = nil
+		h.c.requeue(wc)
+	}
+	h.c.mutex.Unlock()
+	h.Handler.OnClose(ctx, wc, reason)
+	h.closed <- reason
+}
+
+func (c *Client) connect(ctx context.Context) (chan websocket.CloseReason, error) {
+	d := c.d
+	dialer := d.Dialer
+	if dialer == nil {
+		dialer = gws.DefaultDialer
+	}
+	header := d.Header.Clone()
+	c.mutex.Lock()
+	token := c.token
+	c.mutex.Unlock()
+	if d.ResumeHeader != "" && token != "" {
+		if header == nil {
+			header = http.Header{}
+		}
+		header.Set(d.ResumeHeader, token)
+	}
+
+	conn, resp, err := dialer.DialContext(ctx, c.url, header)
+	if resp != nil && d.ResumeHeader != "" {
+		if t := resp.Header.Get(d.ResumeHeader); t != "" {
+			c.SetResumeToken(t)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sendLen := d.SendLen
+	if sendLen <= 0 {
+		sendLen = 256
+	}
+	h := d.Handler
+	if h == nil {
+		h = websocket.NopHandler{}
+	}
+	closed := make(chan websocket.CloseReason, 1)
+	gone := make(chan struct{})
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	// 发送队列留出重新订阅和缓存消息的空间，先重新订阅，再发送断开期间缓存的消息
+	wc := websocket.NewClient(c.ctx, conn, sendLen+len(c.subs)+len(c.queue))
+	wc.SetHandler(connHandler{Handler: h, c: c, closed: closed, gone: gone})
+	wc.SetWriteBatch(false)
+	if d.ReadLimit > 0 {
+		wc.SetReadLimit(d.ReadLimit)
+	}
+	c.control = map[string]struct{}{}
+	for _, sub := range c.subs {
+		wc.Send <- sub.frame
+		c.control[string(sub.frame)] = struct{}{}
+	}
+	for _, msg := range c.queue {
+		wc.Send <- msg
+	}
+	c.queue = nil
+	c.conn, c.gone = wc, gone
+	wc.Run()
+	return closed, nil
+}
+
+func (c *Client) loop(closed chan websocket.CloseReason) {
+	for {
+		var reason websocket.CloseReason
+		select {
+		case reason = <-closed:
+		case <-c.ctx.Done():
+			c.finish(ErrClosed)
+			return
+		}
+		if c.ctx.Err() != nil {
+			c.finish(ErrClosed)
+			return
+		}
+
+		should := c.d.ShouldReconnect
+		if should == nil {
+			should = defaultShouldReconnect
+		}
+		if !should(reason) {
+			c.finish(closeError{reason})
+			return
+		}
+
+		var err error
+		for attempt := 1; ; attempt++ {
+			timer := time.NewTimer(c.backoff(attempt))
+			select {
+			case <-timer.C:
+			case <-c.ctx.Done():
+				timer.Stop()
+				c.finish(ErrClosed)
+				return
+			}
+			if closed, err = c.connect(c.ctx); err == nil {
+				break
+			}
+			if c.d.MaxRetries > 0 && attempt >= c.d.MaxRetries {
+				c.finish(err)
+				return
+			}
+		}
+	}
+}
+
+func (c *Client) backoff(attempt int) time.Duration {
+	min, max := c.d.MinBackoff, c.d.MaxBackoff
+	if min <= 0 {
+		min = 500 * time.Millisecond
+	}
+	if max < min {
+		max = min
+	}
+	d := min
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d + time.Duration(rand.Int63n(int64(d)/2+1))
+}
+
+func (c *Client) finish(err error) {
+	c.mutex.Lock()
+	conn := c.conn
+	c.conn = nil
+	c.err = err
+	c.mutex.Unlock()
+	if conn != nil {
+		_ = conn.CloseWith(gws.CloseNormalClosure, "")
+	}
+	c.cancel()
+	close(c.done)
+}
+
+// requeue 断开的连接中未发送的消息放回缓存，重连后发送，需持有锁
+func (c *Client) requeue(wc *websocket.Client) {
+	if pending := unsent(wc, c.control); len(pending) > 0 {
+		c.queue = append(pending, c.queue...)
+	}
+}
+
+// unsent 取出断开的连接中未发送的消息，需持有锁。
+// 订阅消息由重连时的重新订阅发送，取消订阅在新连接上不需要，control 为该连接上发送的这两类消息，不取回
+func unsent(wc *websocket.Client, control map[string]struct{}) [][]byte {
+	var pending [][]byte
+	for {
+		select {
+		case msg := <-wc.Send:
+			if _, ok := control[string(msg)]; !ok {
+				pending = append(pending, msg)
+			}
+			continue
+		default:
+		}
+		return pending
+	}
+}
+
+// closeError 服务端关闭且不重连
+type closeError struct {
+	reason websocket.CloseReason
+}
+
+func (e closeError) Error() string {
+	return "wsclient: closed by server: " + e.reason.String()
+}
+
+// Send 发送消息，断开期间缓存，重连后按顺序发送。已连接且发送队列已满时阻塞，
+// 等待期间不影响重连、订阅和 Close
+func (c *Client) Send(msg []byte) error {
+	return c.send(msg, false)
+}
+
+// send 发送 msg，control 为订阅、取消订阅消息，断开期间不缓存，重连后由重新订阅恢复。
+// 等待发送队列时不持有 mutex；放入队列后连接断开的，OnClose 可能已取过发送队列，
+// 剩余的消息取回后在新连接上发送或放回缓存
+func (c *Client) send(msg []byte, control bool) error {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+	msgs := [][]byte{msg}
+	for len(msgs) > 0 {
+		c.mutex.Lock()
+		if c.ctx.Err() != nil {
+			c.mutex.Unlock()
+			return ErrClosed
+		}
+		if c.conn == nil {
+			var err error
+			if !control {
+				// 取回的消息先于 msg，不受 QueueSize 限制
+				last := len(msgs) - 1
+				c.queue = append(c.queue, msgs[:last]...)
+				err = c.enqueue(msgs[last])
+			}
+			c.mutex.Unlock()
+			return err
+		}
+		conn, gone, sent := c.conn, c.gone, c.control
+		if control {
+			sent[string(msgs[0])] = struct{}{}
+		}
+		c.mutex.Unlock()
+
+		select {
+		case conn.Send <- msgs[0]:
+			msgs = msgs[1:]
+		case <-gone:
+			if control {
+				msgs = nil
+			}
+		case <-c.ctx.Done():
+			return ErrClosed
+		}
+		select {
+		case <-gone:
+			c.mutex.Lock()
+			msgs = append(unsent(conn, sent), msgs...)
+			c.mutex.Unlock()
+			control = false
+		default:
+		}
+	}
+	return nil
+}
+
+// enqueue 需持有锁
+func (c *Client) enqueue(msg []byte) error {
+	if c.d.QueueSize > 0 && len(c.queue) >= c.d.QueueSize {
+		return ErrQueueFull
+	}
+	c.queue = append(c.queue, msg)
+	return nil
+}
+
+// Emit 发送 websocket.Router 格式的事件消息
+func (c *Client) Emit(event string, data interface{}) error {
+	msg, err := websocket.NewEventMessage(event, data)
+	if err != nil {
+		return err
+	}
+	return c.Send(msg)
+}
+
+// Subscribe 发送订阅消息 frame，每次重连后重新发送，key 相同时替换
+func (c *Client) Subscribe(key string, frame []byte) error {
+	c.mutex.Lock()
+	replaced := false
+	for i := range c.subs {
+		if c.subs[i].key == key {
+			c.subs[i].frame = frame
+			replaced = true
+		}
+	}
+	if !replaced {
+		c.subs = append(c.subs, subscription{key: key, frame: frame})
+	}
+	c.mutex.Unlock()
+	return c.send(frame, true)
+}
+
+// Unsubscribe 取消 key 的订阅，frame 不为空且已连接时发送
+func (c *Client) Unsubscribe(key string, frame []byte) error {
+	c.mutex.Lock()
+	for i := range c.subs {
+		if c.subs[i].key == key {
+			c.subs = append(c.subs[:i], c.subs[i+1:]...)
+			break
+		}
+	}
+	c.mutex.Unlock()
+	if frame == nil {
+		return nil
+	}
+	return c.send(frame, true)
+}
+
+// SubscribeGroup 订阅组，服务端须使用 websocket.Router 的 HandleSubscribe
+func (c *Client) SubscribeGroup(group string) error {
+	frame, err := websocket.NewEventMessage(websocket.EventSubscribe, &websocket.SubscribeData{Group: group})
+	if err != nil {
+		return err
+	}
+	return c.Subscribe("group:"+group, frame)
+}
+
+// UnsubscribeGroup 取消 SubscribeGroup 的订阅
+func (c *Client) UnsubscribeGroup(group string) error {
+	frame, err := websocket.NewEventMessage(websocket.EventUnsubscribe, &websocket.SubscribeData{Group: group})
+	if err != nil {
+		return err
+	}
+	return c.Unsubscribe("group:"+group, frame)
+}
+
+// SetResumeToken 设置恢复凭证，重连时通过 ResumeHeader 发给服务端
+func (c *Client) SetResumeToken(token string) {
+	c.mutex.Lock()
+	c.token = token
+	c.mutex.Unlock()
+}
+
+// ResumeToken 当前的恢复凭证
+func (c *Client) ResumeToken() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.token
+}
+
+// Connected 当前是否已连接
+func (c *Client) Connected() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.conn != nil
+}
+
+// Close 关闭客户端，不再重连
+func (c *Client) Close() error {
+	c.cancel()
+	<-c.done
+	return nil
+}
+
+// Done 客户端关闭（主动关闭、不再重连或重连失败）时关闭
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err 客户端关闭的原因
+func (c *Client) Err() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.err
+}
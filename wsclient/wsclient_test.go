@@ -0,0 +1,371 @@
+package wsclient
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gws "github.com/gorilla/websocket"
+
+	"github.com/assembly-hub/websocket"
+	"github.com/assembly-hub/websocket/simplesub"
+)
+
+// testServer 记录每个连接收到的消息，可断开当前连接、拒绝新连接
+type testServer struct {
+	url    string
+	refuse atomic.Bool
+	mutex  sync.Mutex
+	conn   *gws.Conn
+	conns  chan chan string
+}
+
+func startServer(t *testing.T) *testServer {
+	t.Helper()
+	ts := &testServer{conns: make(chan chan string, 8)}
+	up := gws.Upgrader{}
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ts.refuse.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		conn, err := up.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		ts.mutex.Lock()
+		ts.conn = conn
+		ts.mutex.Unlock()
+		msgs := make(chan string, 64)
+		ts.conns <- msgs
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				close(msgs)
+				return
+			}
+			msgs <- string(msg)
+		}
+	}))
+	t.Cleanup(hs.Close)
+	ts.url = "ws" + strings.TrimPrefix(hs.URL, "http")
+	return ts
+}
+
+// drop 断开当前连接，不发送关闭帧
+func (ts *testServer) drop() {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	_ = ts.conn.UnderlyingConn().Close()
+}
+
+func (ts *testServer) accept(t *testing.T) chan string {
+	t.Helper()
+	select {
+	case msgs := <-ts.conns:
+		return msgs
+	case <-time.After(5 * time.Second):
+		t.Fatal("client did not connect")
+		return nil
+	}
+}
+
+// expect 按顺序收到 want，之后短时间内没有其他消息
+func expect(t *testing.T, msgs chan string, want ...string) {
+	t.Helper()
+	for _, w := range want {
+		select {
+		case got := <-msgs:
+			if got != w {
+				t.Fatalf("got %s, want %s", got, w)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s not received", w)
+		}
+	}
+	select {
+	case got, ok := <-msgs:
+		if ok {
+			t.Fatalf("unexpected %s", got)
+		}
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func dial(t *testing.T, url string) *Client {
+	t.Helper()
+	d := NewDialer()
+	d.MinBackoff = 10 * time.Millisecond
+	d.MaxBackoff = 20 * time.Millisecond
+	c, err := d.Dial(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+func waitConnected(t *testing.T, c *Client, connected bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for c.Connected() != connected {
+		if time.Now().After(deadline) {
+			t.Fatalf("connected != %v", connected)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestReconnectResubscribe(t *testing.T) {
+	ts := startServer(t)
+	c := dial(t, ts.url)
+	first := ts.accept(t)
+
+	if err := c.Subscribe("a", []byte("sub a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Send([]byte("m1")); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, first, "sub a", "m1")
+
+	ts.drop()
+	second := ts.accept(t)
+	waitConnected(t, c, true)
+	if err := c.Send([]byte("m2")); err != nil {
+		t.Fatal(err)
+	}
+	// 订阅只重新发送一次
+	expect(t, second, "sub a", "m2")
+}
+
+func TestSendWhileDisconnected(t *testing.T) {
+	ts := startServer(t)
+	c := dial(t, ts.url)
+	first := ts.accept(t)
+	if err := c.Subscribe("a", []byte("sub a")); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, first, "sub a")
+
+	ts.refuse.Store(true)
+	ts.drop()
+	waitConnected(t, c, false)
+	for _, op := range []func() error{
+		func() error { return c.Send([]byte("m1")) },
+		func() error { return c.Subscribe("b", []byte("sub b")) },
+		func() error { return c.Unsubscribe("a", []byte("unsub a")) },
+		func() error { return c.Send([]byte("m2")) },
+	} {
+		if err := op(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ts.refuse.Store(false)
+	second := ts.accept(t)
+	// 先重新订阅，再按顺序发送缓存的消息，断开期间的订阅、取消订阅不重复发送
+	expect(t, second, "sub b", "m1", "m2")
+}
+
+func TestRequeueSkipsControlFrames(t *testing.T) {
+	c := &Client{control: map[string]struct{}{"sub a": {}}}
+	wc := websocket.NewClient(context.Background(), nil, 4)
+	for _, msg := range []string{"m1", "sub a", "m2"} {
+		wc.Send <- []byte(msg)
+	}
+	c.queue = [][]byte{[]byte("m3")}
+	c.requeue(wc)
+
+	var got []string
+	for _, msg := range c.queue {
+		got = append(got, string(msg))
+	}
+	if strings.Join(got, ",") != "m1,m2,m3" {
+		t.Fatalf("got %v", got)
+	}
+}
+
+func TestSendDoesNotBlockClient(t *testing.T) {
+	// 服务端不读取，连接的发送队列很快占满
+	up := gws.Upgrader{}
+	release := make(chan struct{})
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := up.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		<-release
+		_ = conn.Close()
+	}))
+	t.Cleanup(hs.Close)
+	t.Cleanup(func() { close(release) })
+	d := NewDialer()
+	d.SendLen = 1
+	c, err := d.Dial(context.Background(), "ws"+strings.TrimPrefix(hs.URL, "http"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sendErr := make(chan error, 1)
+	go func() {
+		msg := bytes.Repeat([]byte("x"), 4<<20)
+		for i := 0; i < 16; i++ {
+			if err := c.Send(msg); err != nil {
+				sendErr <- err
+				return
+			}
+		}
+		sendErr <- nil
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	// Send 等待发送队列时不影响其他调用
+	done := make(chan struct{})
+	go func() {
+		c.SetResumeToken("t")
+		_ = c.Connected()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("client blocked by a full send queue")
+	}
+
+	// Close 唤醒等待中的 Send
+	go func() { _ = c.Close() }()
+	select {
+	case err := <-sendErr:
+		if err != ErrClosed {
+			t.Fatalf("got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Send not released by Close")
+	}
+}
+
+// startManager simplesub 服务端，每个连接加入自己的组，以 HandleSubscribe 订阅其他组。
+// 握手时没有恢复凭证的连接分配新凭证，收到的凭证写入 tokens
+func startManager(t *testing.T) (*simplesub.Manage, string, chan string) {
+	t.Helper()
+	m := simplesub.NewManager()
+	router := websocket.NewRouter().HandleSubscribe(m, func(c *websocket.Client, group string) bool {
+		return strings.HasPrefix(group, "news:")
+	})
+	tokens := make(chan string, 8)
+	var n atomic.Int32
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get(DefaultResumeHeader)
+		tokens <- token
+		if token == "" {
+			token = fmt.Sprintf("session-%d", n.Add(1))
+			w.Header().Set(DefaultResumeHeader, token)
+		}
+		_ = m.AddGroupWithHandler("conn:"+token, w, r, router)
+	}))
+	t.Cleanup(hs.Close)
+	return m, "ws" + strings.TrimPrefix(hs.URL, "http"), tokens
+}
+
+func waitGroup(t *testing.T, m *simplesub.Manage, group string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(m.Clients(group)) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("nobody in %s", group)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestManagerResubscribe(t *testing.T) {
+	m, url, tokens := startManager(t)
+	got := make(chan string, 16)
+	d := NewDialer()
+	d.MinBackoff = 10 * time.Millisecond
+	d.MaxBackoff = 20 * time.Millisecond
+	d.ShouldReconnect = func(reason websocket.CloseReason) bool { return true }
+	d.Handler = websocket.HandlerFuncs{
+		Message: func(ctx context.Context, c *websocket.Client, mt websocket.MessageType, msg []byte) error {
+			got <- string(msg)
+			return nil
+		},
+	}
+	c, err := d.Dial(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	if token := <-tokens; token != "" {
+		t.Fatalf("first handshake token %q", token)
+	}
+	if c.ResumeToken() != "session-1" {
+		t.Fatalf("resume token %q", c.ResumeToken())
+	}
+
+	if err := c.SubscribeGroup("news:tech"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SubscribeGroup("secret"); err != nil {
+		t.Fatal(err)
+	}
+	waitGroup(t, m, "news:tech")
+	_ = m.SendMsg("secret", "s1")
+	_ = m.SendMsg("news:tech", "m1")
+	var msgs []string
+	for !contains(msgs, "m1") {
+		select {
+		case msg := <-got:
+			msgs = append(msgs, msg)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %v, m1 not received", msgs)
+		}
+	}
+
+	// 服务端关闭连接，重连时带上凭证，并重新订阅
+	if err := m.CloseGroup("conn:session-1", "restart"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case token := <-tokens:
+		if token != "session-1" {
+			t.Fatalf("reconnect token %q", token)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("client did not reconnect")
+	}
+	waitGroup(t, m, "conn:session-1")
+	waitGroup(t, m, "news:tech")
+	_ = m.SendMsg("news:tech", "m2")
+	for !contains(msgs, "m2") {
+		select {
+		case msg := <-got:
+			msgs = append(msgs, msg)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %v, m2 not received after reconnect", msgs)
+		}
+	}
+	if contains(msgs, "s1") {
+		t.Fatalf("got %v", msgs)
+	}
+	if len(m.Clients("secret")) != 0 {
+		t.Fatal("subscribed to a group not allowed")
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}